@@ -0,0 +1,144 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+const (
+	familyIPv4 = 0x01
+	familyIPv6 = 0x02
+)
+
+var (
+	ErrInvalidFamily        = errors.New("Invalid Address Family!")
+	ErrInvalidAddressLength = errors.New("Invalid Address Length!")
+)
+
+//checkAddress makes sure an address attribute has a known family and the right length for it
+func checkAddress(ba []byte) error {
+	if len(ba) < 4 {
+		return ErrInvalidAddressLength
+	}
+	switch ba[1] {
+	case familyIPv4:
+		if len(ba) != 8 {
+			return ErrInvalidAddressLength
+		}
+	case familyIPv6:
+		if len(ba) != 20 {
+			return ErrInvalidAddressLength
+		}
+	default:
+		return ErrInvalidFamily
+	}
+	return nil
+}
+
+//ParseAddrPort turns an un-masked address attribute []byte into a netip.AddrPort
+func ParseAddrPort(ba []byte) (netip.AddrPort, error) {
+	if err := checkAddress(ba); err != nil {
+		return netip.AddrPort{}, err
+	}
+	port := binary.BigEndian.Uint16(ba[2:4])
+	if ba[1] == familyIPv4 {
+		return netip.AddrPortFrom(netip.AddrFrom4([4]byte{ba[4], ba[5], ba[6], ba[7]}), port), nil
+	}
+	var ip [16]byte
+	copy(ip[:], ba[4:20])
+	return netip.AddrPortFrom(netip.AddrFrom16(ip), port), nil
+}
+
+//UnMaskAddrPort turns an xor'd address attribute []byte, like an SAXORMappedAddress,
+//into a netip.AddrPort
+func UnMaskAddrPort(tid TransactionID, ba []byte) (netip.AddrPort, error) {
+	return unmaskAddrPort(tid.GetTID(), ba)
+}
+
+func unmaskAddrPort(tid []byte, ba []byte) (netip.AddrPort, error) {
+	if err := checkAddress(ba); err != nil {
+		return netip.AddrPort{}, err
+	}
+	port := binary.BigEndian.Uint16(ba[2:4]) ^ stunShortMagic
+	var ip [16]byte
+	binary.BigEndian.PutUint32(ip[:4], binary.BigEndian.Uint32(ba[4:8])^stunMagic)
+	if ba[1] == familyIPv4 {
+		return netip.AddrPortFrom(netip.AddrFrom4([4]byte{ip[0], ip[1], ip[2], ip[3]}), port), nil
+	}
+	for i := 4; i < 16; i++ {
+		ip[i] = ba[i+4] ^ tid[i-4]
+	}
+	return netip.AddrPortFrom(netip.AddrFrom16(ip), port), nil
+}
+
+//CreateAddrPort turns a netip.AddrPort into an un-masked address attribute []byte,
+//ipv4 mapped ipv6 addresses are sent as ipv4
+func CreateAddrPort(ap netip.AddrPort) []byte {
+	ip := ap.Addr().Unmap()
+	var ba []byte
+	if ip.Is4() {
+		ba = make([]byte, 8)
+		ba[1] = familyIPv4
+		ip4 := ip.As4()
+		copy(ba[4:], ip4[:])
+	} else {
+		ba = make([]byte, 20)
+		ba[1] = familyIPv6
+		ip16 := ip.As16()
+		copy(ba[4:], ip16[:])
+	}
+	binary.BigEndian.PutUint16(ba[2:4], ap.Port())
+	return ba
+}
+
+//CreateMaskedAddrPort turns a netip.AddrPort into an xor'd address attribute []byte
+func CreateMaskedAddrPort(tid TransactionID, ap netip.AddrPort) []byte {
+	ba := CreateAddrPort(ap)
+	binary.BigEndian.PutUint16(ba[2:4], ap.Port()^stunShortMagic)
+	binary.BigEndian.PutUint32(ba[4:8], binary.BigEndian.Uint32(ba[4:8])^stunMagic)
+	tidbb := tid.GetTID()
+	for i := 8; i < len(ba); i++ {
+		ba[i] ^= tidbb[i-8]
+	}
+	return ba
+}
+
+//GetXORMappedAddrPort returns the SAXORMappedAddress in this StunPacket as a netip.AddrPort.
+//Unlike GetAddress it does not allocate and it works the same for StunPackets from any transport
+func (sp *StunPacket) GetXORMappedAddrPort() (netip.AddrPort, error) {
+	return sp.GetAddrPortAttribute(SAXORMappedAddress)
+}
+
+//GetMappedAddrPort returns the SAMappedAddress in this StunPacket as a netip.AddrPort
+func (sp *StunPacket) GetMappedAddrPort() (netip.AddrPort, error) {
+	return sp.GetAddrPortAttribute(SAMappedAddress)
+}
+
+//GetAddrPortAttribute is GetAddressAttribute returning a netip.AddrPort
+func (sp *StunPacket) GetAddrPortAttribute(sa StunAttribute) (netip.AddrPort, error) {
+	ba := sp.GetAttribute(sa)
+	if ba == nil {
+		return netip.AddrPort{}, fmt.Errorf("%s Not found!", sa)
+	}
+	if IsXORAddress(sa) {
+		return unmaskAddrPort(sp.buffer[8:20], ba)
+	}
+	return ParseAddrPort(ba)
+}
+
+//SetXORMappedAddrPort adds an SAXORMappedAddress for the netip.AddrPort
+func (spb *StunPacketBuilder) SetXORMappedAddrPort(ap netip.AddrPort) *StunPacketBuilder {
+	if spb.tid == nil {
+		spb.tid = spb.createTID()
+	}
+	spb.ReplaceAttribute(SAXORMappedAddress, CreateMaskedAddrPort(*spb.tid, ap))
+	return spb
+}
+
+//SetMappedAddrPort adds an SAMappedAddress for the netip.AddrPort
+func (spb *StunPacketBuilder) SetMappedAddrPort(ap netip.AddrPort) *StunPacketBuilder {
+	spb.ReplaceAttribute(SAMappedAddress, CreateAddrPort(ap))
+	return spb
+}
@@ -0,0 +1,87 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetXORMappedAddrPort(t *testing.T) {
+	ba, _ := hex.DecodeString(SPRESP1)
+	sp, err := NewStunPacket(ba)
+	assert.NoError(t, err)
+	ap, err := sp.GetXORMappedAddrPort()
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddrPort("192.0.2.1:32853"), ap)
+	_, err = sp.GetMappedAddrPort()
+	assert.Error(t, err)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		sp.GetXORMappedAddrPort()
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestAddrPortRoundTrip(t *testing.T) {
+	for _, s := range []string{"192.0.2.1:32853", "[2001:db8:1234:5678:11:2233:4455:6677]:32853", "[::ffff:10.1.2.3]:3478"} {
+		ap := netip.MustParseAddrPort(s)
+		ua := net.UDPAddrFromAddrPort(ap)
+		tid := CreateTID()
+		assert.Equal(t, CreateMaskedAddress(*tid, ua), CreateMaskedAddrPort(*tid, ap))
+		assert.Equal(t, CreateAddress(ua), CreateAddrPort(ap))
+
+		sp := NewStunPacketBuilder().SetTXID(tid).SetXORMappedAddrPort(ap).SetMappedAddrPort(ap).Build()
+		xap, err := sp.GetXORMappedAddrPort()
+		assert.NoError(t, err)
+		assert.Equal(t, ap.Addr().Unmap(), xap.Addr())
+		assert.Equal(t, ap.Port(), xap.Port())
+		mapped, err := sp.GetMappedAddrPort()
+		assert.NoError(t, err)
+		assert.Equal(t, xap, mapped)
+
+		spb := NewStunPacketBuilder()
+		assert.NoError(t, spb.SetValue(SAXORMappedAddress, ap))
+		got, err := spb.Build().GetXORMappedAddrPort()
+		assert.NoError(t, err)
+		assert.Equal(t, xap, got)
+	}
+}
+
+func TestAddressFamilyValidation(t *testing.T) {
+	tid := CreateTID()
+	for _, tc := range []struct {
+		ba  []byte
+		err error
+	}{
+		{[]byte{0, 3, 0, 1, 1, 2, 3, 4}, ErrInvalidFamily},
+		{[]byte{0, 0, 0, 1, 1, 2, 3, 4}, ErrInvalidFamily},
+		{make([]byte, 20), ErrInvalidFamily},
+		{append([]byte{0, 1, 0, 1}, make([]byte, 16)...), ErrInvalidAddressLength},
+		{[]byte{0, 2, 0, 1, 1, 2, 3, 4}, ErrInvalidAddressLength},
+		{[]byte{0, 1, 0}, ErrInvalidAddressLength},
+	} {
+		_, err := ParseAddrPort(tc.ba)
+		assert.Equal(t, tc.err, err)
+		_, err = UnMaskAddrPort(*tid, tc.ba)
+		assert.Equal(t, tc.err, err)
+		_, err = ParseAddress(tc.ba)
+		assert.Equal(t, tc.err, err)
+
+		sp := NewStunPacketBuilder().SetTXID(tid).SetAttribue(SAXORMappedAddress, tc.ba).Build()
+		_, err = sp.GetAddress()
+		assert.Equal(t, tc.err, err)
+		_, err = sp.GetXORMappedAddrPort()
+		assert.Equal(t, tc.err, err)
+	}
+}
+
+func BenchmarkStunParseXORAddrPort(b *testing.B) {
+	sp := NewStunPacketBuilder().SetXORMappedAddrPort(netip.MustParseAddrPort("127.0.0.1:8080")).Build()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sp.GetXORMappedAddrPort()
+	}
+}
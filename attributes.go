@@ -0,0 +1,376 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+//AttributeCodec converts the []byte of a StunAttribute to and from a Go value.
+//The TransactionID is provided for attributes that are masked with it
+type AttributeCodec interface {
+	//Name is the rfc name of the attribute, ie "XOR-MAPPED-ADDRESS"
+	Name() string
+	Decode(tid *TransactionID, ba []byte) (interface{}, error)
+	Encode(tid *TransactionID, value interface{}) ([]byte, error)
+}
+
+type attributeCodec struct {
+	name   string
+	decode func(tid *TransactionID, ba []byte) (interface{}, error)
+	encode func(tid *TransactionID, value interface{}) ([]byte, error)
+}
+
+func (ac *attributeCodec) Name() string {
+	return ac.name
+}
+
+func (ac *attributeCodec) Decode(tid *TransactionID, ba []byte) (interface{}, error) {
+	return ac.decode(tid, ba)
+}
+
+func (ac *attributeCodec) Encode(tid *TransactionID, value interface{}) ([]byte, error) {
+	return ac.encode(tid, value)
+}
+
+//NewAttributeCodec creates an AttributeCodec from a name and a decode and encode function
+func NewAttributeCodec(name string,
+	decode func(tid *TransactionID, ba []byte) (interface{}, error),
+	encode func(tid *TransactionID, value interface{}) ([]byte, error)) AttributeCodec {
+	return &attributeCodec{name: name, decode: decode, encode: encode}
+}
+
+var (
+	codecLock sync.RWMutex
+	codecs    = make(map[StunAttribute]AttributeCodec)
+)
+
+//RegisterAttribute sets the AttributeCodec used for a StunAttribute.
+//This can be used to add vendor attributes or replace the built in ones
+func RegisterAttribute(sa StunAttribute, codec AttributeCodec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecs[sa] = codec
+}
+
+//LookupAttribute returns the AttributeCodec registered for a StunAttribute
+func LookupAttribute(sa StunAttribute) (AttributeCodec, bool) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	codec, ok := codecs[sa]
+	return codec, ok
+}
+
+//String returns the registered name of this StunAttribute or its hex value if it is unknown
+func (sa StunAttribute) String() string {
+	if codec, ok := LookupAttribute(sa); ok {
+		return codec.Name()
+	}
+	return fmt.Sprintf("0x%04X", uint16(sa))
+}
+
+//Attribute is a StunAttribute decoded with its registered AttributeCodec.
+//Unknown attributes, or ones that failed to decode, have their Raw []byte as the Value
+type Attribute struct {
+	Type  StunAttribute
+	Value interface{}
+	Raw   []byte
+}
+
+//Name returns the name of this Attributes StunAttribute
+func (a Attribute) Name() string {
+	return a.Type.String()
+}
+
+func (a Attribute) String() string {
+	if a.Value == nil {
+		return a.Name()
+	}
+	return a.Name() + ": " + formatValue(a.Value)
+}
+
+//Decode returns every attribute in this StunPacket decoded with its registered AttributeCodec.
+//All attributes are always returned, the error is the first decode failure if there was one
+func (sp *StunPacket) Decode() ([]Attribute, error) {
+	var firstErr error
+	tid := sp.GetTxID()
+	attrs := make([]Attribute, 0)
+	pos := 20
+	for pos+4 <= len(sp.buffer) {
+		sa := StunAttribute(binary.BigEndian.Uint16(sp.buffer[pos : pos+2]))
+		s := int(binary.BigEndian.Uint16(sp.buffer[pos+2 : pos+4]))
+		if pos+4+s > len(sp.buffer) {
+			return attrs, errors.New("Attribute is longer than the StunPacket!")
+		}
+		a := Attribute{Type: sa, Raw: sp.buffer[pos+4 : pos+4+s], Value: sp.buffer[pos+4 : pos+4+s]}
+		if codec, ok := LookupAttribute(sa); ok {
+			v, err := codec.Decode(tid, a.Raw)
+			if err == nil {
+				a.Value = v
+			} else if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %s", sa, err)
+			}
+		}
+		attrs = append(attrs, a)
+		pos = ((pos + s + 4 + 3) & ^3)
+	}
+	return attrs, firstErr
+}
+
+//SetValue encodes the value with the AttributeCodec registered for the StunAttribute and adds it
+func (spb *StunPacketBuilder) SetValue(sa StunAttribute, value interface{}) error {
+	codec, ok := LookupAttribute(sa)
+	if !ok {
+		return fmt.Errorf("No AttributeCodec for %s!", sa)
+	}
+	if spb.tid == nil {
+		spb.tid = spb.createTID()
+	}
+	ba, err := codec.Encode(spb.tid, value)
+	if err != nil {
+		return err
+	}
+	spb.ReplaceAttribute(sa, ba)
+	return nil
+}
+
+func wrongType(value interface{}) error {
+	return fmt.Errorf("Unsupported value type %T!", value)
+}
+
+func decodeAddress(tid *TransactionID, ba []byte) (interface{}, error) {
+	return ParseAddress(ba)
+}
+
+func encodeAddress(tid *TransactionID, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case *net.UDPAddr:
+		return CreateAddress(v), nil
+	case netip.AddrPort:
+		return CreateAddrPort(v), nil
+	}
+	return nil, wrongType(value)
+}
+
+func decodeXORAddress(tid *TransactionID, ba []byte) (interface{}, error) {
+	if err := checkAddress(ba); err != nil {
+		return nil, err
+	}
+	return UnMaskAddress(*tid, ba), nil
+}
+
+func encodeXORAddress(tid *TransactionID, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case *net.UDPAddr:
+		return CreateMaskedAddress(*tid, v), nil
+	case netip.AddrPort:
+		return CreateMaskedAddrPort(*tid, v), nil
+	}
+	return nil, wrongType(value)
+}
+
+func textCodec(name string, maxChars int, maxBytes int) AttributeCodec {
+	return NewAttributeCodec(name,
+		func(tid *TransactionID, ba []byte) (interface{}, error) {
+			s := string(ba)
+			if err := checkText(s, maxChars, maxBytes); err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+		func(tid *TransactionID, value interface{}) ([]byte, error) {
+			s, ok := value.(string)
+			if !ok {
+				return nil, wrongType(value)
+			}
+			if err := checkText(s, maxChars, maxBytes); err != nil {
+				return nil, err
+			}
+			return []byte(s), nil
+		})
+}
+
+func bytesCodec(name string) AttributeCodec {
+	return NewAttributeCodec(name,
+		func(tid *TransactionID, ba []byte) (interface{}, error) {
+			return ba, nil
+		},
+		func(tid *TransactionID, value interface{}) ([]byte, error) {
+			ba, ok := value.([]byte)
+			if !ok {
+				return nil, wrongType(value)
+			}
+			return ba, nil
+		})
+}
+
+func uint32Codec(name string) AttributeCodec {
+	return NewAttributeCodec(name,
+		func(tid *TransactionID, ba []byte) (interface{}, error) {
+			if len(ba) != 4 {
+				return nil, errors.New("Invalid Length!")
+			}
+			return binary.BigEndian.Uint32(ba), nil
+		},
+		func(tid *TransactionID, value interface{}) ([]byte, error) {
+			v, ok := value.(uint32)
+			if !ok {
+				return nil, wrongType(value)
+			}
+			ba := make([]byte, 4)
+			binary.BigEndian.PutUint32(ba, v)
+			return ba, nil
+		})
+}
+
+func uint64Codec(name string) AttributeCodec {
+	return NewAttributeCodec(name,
+		func(tid *TransactionID, ba []byte) (interface{}, error) {
+			if len(ba) != 8 {
+				return nil, errors.New("Invalid Length!")
+			}
+			return binary.BigEndian.Uint64(ba), nil
+		},
+		func(tid *TransactionID, value interface{}) ([]byte, error) {
+			v, ok := value.(uint64)
+			if !ok {
+				return nil, wrongType(value)
+			}
+			ba := make([]byte, 8)
+			binary.BigEndian.PutUint64(ba, v)
+			return ba, nil
+		})
+}
+
+//uint16Codec is for a uint16 followed by 2 reserved bytes
+func uint16Codec(name string) AttributeCodec {
+	return NewAttributeCodec(name,
+		func(tid *TransactionID, ba []byte) (interface{}, error) {
+			if len(ba) != 4 {
+				return nil, errors.New("Invalid Length!")
+			}
+			return binary.BigEndian.Uint16(ba), nil
+		},
+		func(tid *TransactionID, value interface{}) ([]byte, error) {
+			v, ok := value.(uint16)
+			if !ok {
+				return nil, wrongType(value)
+			}
+			ba := make([]byte, 4)
+			binary.BigEndian.PutUint16(ba, v)
+			return ba, nil
+		})
+}
+
+func flagCodec(name string) AttributeCodec {
+	return NewAttributeCodec(name,
+		func(tid *TransactionID, ba []byte) (interface{}, error) {
+			if len(ba) != 0 {
+				return nil, errors.New("Invalid Length!")
+			}
+			return nil, nil
+		},
+		func(tid *TransactionID, value interface{}) ([]byte, error) {
+			return []byte{}, nil
+		})
+}
+
+func decodeErrorCode(tid *TransactionID, ba []byte) (interface{}, error) {
+	if len(ba) < 4 {
+		return nil, errors.New("Invalid Length!")
+	}
+	return &StunError{Code: int(ba[2]&0x07)*100 + int(ba[3]), Reason: string(ba[4:])}, nil
+}
+
+func encodeErrorCode(tid *TransactionID, value interface{}) ([]byte, error) {
+	se, ok := value.(*StunError)
+	if !ok {
+		return nil, wrongType(value)
+	}
+	ba := make([]byte, 4+len(se.Reason))
+	ba[2] = byte(se.Code / 100)
+	ba[3] = byte(se.Code % 100)
+	copy(ba[4:], se.Reason)
+	return ba, nil
+}
+
+func decodeUnknownAttributes(tid *TransactionID, ba []byte) (interface{}, error) {
+	if len(ba)%2 != 0 {
+		return nil, errors.New("Invalid Length!")
+	}
+	sas := make([]StunAttribute, 0, len(ba)/2)
+	for i := 0; i < len(ba); i += 2 {
+		sas = append(sas, StunAttribute(binary.BigEndian.Uint16(ba[i:i+2])))
+	}
+	return sas, nil
+}
+
+func encodeUnknownAttributes(tid *TransactionID, value interface{}) ([]byte, error) {
+	sas, ok := value.([]StunAttribute)
+	if !ok {
+		return nil, wrongType(value)
+	}
+	ba := make([]byte, len(sas)*2)
+	for i, sa := range sas {
+		binary.BigEndian.PutUint16(ba[i*2:], uint16(sa))
+	}
+	return ba, nil
+}
+
+func decodeRequestedTransport(tid *TransactionID, ba []byte) (interface{}, error) {
+	if len(ba) != 4 {
+		return nil, errors.New("Invalid Length!")
+	}
+	return ba[0], nil
+}
+
+func encodeRequestedTransport(tid *TransactionID, value interface{}) ([]byte, error) {
+	v, ok := value.(uint8)
+	if !ok {
+		return nil, wrongType(value)
+	}
+	return []byte{v, 0, 0, 0}, nil
+}
+
+func init() {
+	RegisterAttribute(SAMappedAddress, NewAttributeCodec("MAPPED-ADDRESS", decodeAddress, encodeAddress))
+	RegisterAttribute(SAResponseAddress, NewAttributeCodec("RESPONSE-ADDRESS", decodeAddress, encodeAddress))
+	RegisterAttribute(SAChangeRequest, uint32Codec("CHANGE-REQUEST"))
+	RegisterAttribute(SASourceAddress, NewAttributeCodec("SOURCE-ADDRESS", decodeAddress, encodeAddress))
+	RegisterAttribute(SAChangedAddress, NewAttributeCodec("CHANGED-ADDRESS", decodeAddress, encodeAddress))
+	RegisterAttribute(SAUsername, textCodec("USERNAME", 0, maxUsernameBytes))
+	RegisterAttribute(SAPassword, bytesCodec("PASSWORD"))
+	RegisterAttribute(SAMessageIntegrity, bytesCodec("MESSAGE-INTEGRITY"))
+	RegisterAttribute(SAErrorCode, NewAttributeCodec("ERROR-CODE", decodeErrorCode, encodeErrorCode))
+	RegisterAttribute(SAUnknownAttribute, NewAttributeCodec("UNKNOWN-ATTRIBUTES", decodeUnknownAttributes, encodeUnknownAttributes))
+	RegisterAttribute(SAReflectedFrom, NewAttributeCodec("REFLECTED-FROM", decodeAddress, encodeAddress))
+	RegisterAttribute(SAChannelNumber, uint16Codec("CHANNEL-NUMBER"))
+	RegisterAttribute(SALifetime, uint32Codec("LIFETIME"))
+	RegisterAttribute(SAXORPeerAddress, NewAttributeCodec("XOR-PEER-ADDRESS", decodeXORAddress, encodeXORAddress))
+	RegisterAttribute(SAData, bytesCodec("DATA"))
+	RegisterAttribute(SARealm, textCodec("REALM", maxTextChars, maxTextBytes))
+	RegisterAttribute(SANonce, textCodec("NONCE", maxTextChars, maxTextBytes))
+	RegisterAttribute(SAXORRelayedAddress, NewAttributeCodec("XOR-RELAYED-ADDRESS", decodeXORAddress, encodeXORAddress))
+	RegisterAttribute(SAEvenPort, bytesCodec("EVEN-PORT"))
+	RegisterAttribute(SARequestedTransport, NewAttributeCodec("REQUESTED-TRANSPORT", decodeRequestedTransport, encodeRequestedTransport))
+	RegisterAttribute(SADontFragment, flagCodec("DONT-FRAGMENT"))
+	RegisterAttribute(SAReservationToken, bytesCodec("RESERVATION-TOKEN"))
+	RegisterAttribute(SAXORMappedAddress, NewAttributeCodec("XOR-MAPPED-ADDRESS", decodeXORAddress, encodeXORAddress))
+	RegisterAttribute(SAPriority, uint32Codec("PRIORITY"))
+	RegisterAttribute(SAUseCandidate, flagCodec("USE-CANDIDATE"))
+	RegisterAttribute(SAPadding, bytesCodec("PADDING"))
+	RegisterAttribute(SAResponsePort, uint16Codec("RESPONSE-PORT"))
+	RegisterAttribute(SAConnectionID, uint32Codec("CONNECTION-ID"))
+	RegisterAttribute(SAAlternateDomain, textCodec("ALTERNATE-DOMAIN", maxTextChars, maxTextBytes))
+	RegisterAttribute(SASoftware, textCodec("SOFTWARE", maxTextChars, maxTextBytes))
+	RegisterAttribute(SAAlternateServer, NewAttributeCodec("ALTERNATE-SERVER", decodeAddress, encodeAddress))
+	RegisterAttribute(SAFingerPrint, uint32Codec("FINGERPRINT"))
+	RegisterAttribute(SAResponseOrigin, NewAttributeCodec("RESPONSE-ORIGIN", decodeAddress, encodeAddress))
+	RegisterAttribute(SAOtherAddress, NewAttributeCodec("OTHER-ADDRESS", decodeAddress, encodeAddress))
+	RegisterAttribute(SAIceControlled, uint64Codec("ICE-CONTROLLED"))
+	RegisterAttribute(SAIceControlling, uint64Codec("ICE-CONTROLLING"))
+	RegisterAttribute(SAMobilityTicket, bytesCodec("MOBILITY-TICKET"))
+}
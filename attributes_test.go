@@ -0,0 +1,90 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const saGoogNetworkInfo StunAttribute = 0xC057
+
+type networkInfo struct {
+	ID   uint16
+	Cost uint16
+}
+
+func (ni networkInfo) String() string {
+	return fmt.Sprintf("id=%d cost=%d", ni.ID, ni.Cost)
+}
+
+func TestDecodeAttributes(t *testing.T) {
+	ba, _ := hex.DecodeString(SPRESP1)
+	sp, _ := NewStunPacket(ba)
+	attrs, err := sp.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(attrs))
+	assert.Equal(t, SASoftware, attrs[0].Type)
+	assert.Equal(t, "test vector", attrs[0].Value)
+	assert.Equal(t, "XOR-MAPPED-ADDRESS: 192.0.2.1:32853", attrs[1].String())
+	assert.Equal(t, "MESSAGE-INTEGRITY", attrs[2].Name())
+	assert.Equal(t, uint32(0xc07d4c96), attrs[3].Value)
+}
+
+func TestSetValue(t *testing.T) {
+	ua := &net.UDPAddr{IP: net.ParseIP("192.0.2.1").To4(), Port: 32853}
+	spb := NewStunPacketBuilder()
+	assert.NoError(t, spb.SetValue(SAXORMappedAddress, ua))
+	assert.NoError(t, spb.SetValue(SAErrorCode, &StunError{Code: 420, Reason: "Unknown"}))
+	assert.NoError(t, spb.SetValue(SAUnknownAttribute, []StunAttribute{0x0030, 0x0031}))
+	assert.NoError(t, spb.SetValue(SAUseCandidate, nil))
+	assert.Error(t, spb.SetValue(SAPriority, "high"))
+	assert.Error(t, spb.SetValue(saGoogNetworkInfo, networkInfo{}))
+	attrs, err := spb.Build().Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, ua, attrs[0].Value)
+	assert.Equal(t, "ERROR-CODE: Stun Error 420: Unknown", attrs[1].String())
+	assert.Equal(t, []StunAttribute{0x0030, 0x0031}, attrs[2].Value)
+	assert.Equal(t, "USE-CANDIDATE", attrs[3].String())
+}
+
+func TestRegisterVendorAttribute(t *testing.T) {
+	RegisterAttribute(saGoogNetworkInfo, NewAttributeCodec("GOOG-NETWORK-INFO",
+		func(tid *TransactionID, ba []byte) (interface{}, error) {
+			if len(ba) != 4 {
+				return nil, errors.New("Invalid Length!")
+			}
+			return networkInfo{ID: binary.BigEndian.Uint16(ba), Cost: binary.BigEndian.Uint16(ba[2:])}, nil
+		},
+		func(tid *TransactionID, value interface{}) ([]byte, error) {
+			ni := value.(networkInfo)
+			ba := make([]byte, 4)
+			binary.BigEndian.PutUint16(ba, ni.ID)
+			binary.BigEndian.PutUint16(ba[2:], ni.Cost)
+			return ba, nil
+		}))
+	defer func() {
+		codecLock.Lock()
+		delete(codecs, saGoogNetworkInfo)
+		codecLock.Unlock()
+	}()
+
+	spb := NewStunPacketBuilder()
+	assert.NoError(t, spb.SetValue(saGoogNetworkInfo, networkInfo{ID: 1, Cost: 10}))
+	spb.SetAttribue(0xC058, []byte{1, 2})
+	attrs, err := spb.Build().Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, "GOOG-NETWORK-INFO: id=1 cost=10", attrs[0].String())
+	assert.Equal(t, "0xC058: 0102", attrs[1].String())
+}
+
+func TestDecodeBadAttribute(t *testing.T) {
+	sp := NewStunPacketBuilder().SetAttribue(SAPriority, []byte{1, 2}).Build()
+	attrs, err := sp.Decode()
+	assert.Error(t, err)
+	assert.Equal(t, []byte{1, 2}, attrs[0].Value)
+}
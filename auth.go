@@ -0,0 +1,151 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"crypto/hmac"
+	crypto_rand "crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const nonceLifetime = 10 * time.Minute
+
+type longTermAuth struct {
+	realm  string
+	keys   func(username string) ([]byte, bool)
+	secret []byte
+}
+
+//SetLongTermAuth makes the server require the rfc5389 long-term credential mechanism.
+//keys returns the LongTermKey for a username, or false if the username is unknown.
+//Nonces are stateless and expire after 10 minutes. When realm can not be used as an
+//SARealm the Serve methods return the error
+func (ss *StunServer) SetLongTermAuth(realm string, keys func(username string) ([]byte, bool)) *StunServer {
+	lta, err := newLongTermAuth(realm, keys)
+	if err != nil {
+		if ss.err == nil {
+			ss.err = err
+		}
+		return ss
+	}
+	ss.auth = lta
+	return ss
+}
+
+func newLongTermAuth(realm string, keys func(username string) ([]byte, bool)) (*longTermAuth, error) {
+	if err := NewStunPacketBuilder().SetRealm(realm).Validate(); err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 16)
+	crypto_rand.Read(secret)
+	return &longTermAuth{realm: realm, keys: keys, secret: secret}, nil
+}
+
+func (lta *longTermAuth) sign(expires string) string {
+	mac := hmac.New(sha1.New, lta.secret)
+	mac.Write([]byte(expires))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+func (lta *longTermAuth) nonce() string {
+	expires := strconv.FormatInt(time.Now().Add(nonceLifetime).Unix(), 16)
+	return expires + "-" + lta.sign(expires)
+}
+
+func (lta *longTermAuth) validNonce(nonce string) bool {
+	parts := strings.SplitN(nonce, "-", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(lta.sign(parts[0]))) {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 16, 64)
+	return err == nil && time.Now().Unix() < expires
+}
+
+//check validates the credentials in a request, when they are not valid the
+//error response is put in the StunPacketBuilder and failed is true
+func (lta *longTermAuth) check(sp *StunPacket, spb *StunPacketBuilder) ([]byte, bool) {
+	failure := NewStunMessage(sp.GetStunMessageType().Method(), ClassFailure)
+	challenge := func(code int, reason string) ([]byte, bool) {
+		spb.SetStunMessage(failure).SetErrorCode(code, reason)
+		spb.SetRealm(lta.realm)
+		spb.SetNonce(lta.nonce())
+		return nil, true
+	}
+	if !sp.HasMessageIntegrity() {
+		return challenge(ECUnauthorized, "Unauthorized")
+	}
+	username, uerr := sp.GetUsername()
+	_, rerr := sp.GetRealm()
+	nonce, nerr := sp.GetNonce()
+	if uerr != nil || rerr != nil || nerr != nil {
+		spb.SetStunMessage(failure).SetErrorCode(ECBadRequest, "Bad Request")
+		return nil, true
+	}
+	if !lta.validNonce(nonce) {
+		return challenge(ECStaleNonce, "Stale Nonce")
+	}
+	key, ok := lta.keys(username)
+	if !ok || !sp.VerifyMessageIntegrity(key) {
+		return challenge(ECUnauthorized, "Unauthorized")
+	}
+	return key, false
+}
+
+//SetCredentials sets the username and password used when a server asks
+//for long-term credentials with a 401 Unauthorized
+func (sc *StunClient) SetCredentials(username string, password string) *StunClient {
+	sc.username = username
+	sc.password = password
+	return sc
+}
+
+//clientAuth holds the long-term credential state for one server
+type clientAuth struct {
+	realm string
+	nonce string
+	key   []byte
+	tries int
+}
+
+//sign adds the credentials to a request if the server has asked for them
+func (ca *clientAuth) sign(sc *StunClient, spb *StunPacketBuilder) error {
+	if ca.key == nil {
+		return nil
+	}
+	if err := spb.SetUsername(sc.username).Validate(); err != nil {
+		return err
+	}
+	spb.ReplaceAttribute(SARealm, []byte(ca.realm))
+	spb.ReplaceAttribute(SANonce, []byte(ca.nonce))
+	spb.SetMessageIntegrity(ca.key)
+	return nil
+}
+
+//challenged updates the credentials from a 401 or 438 response, false means
+//the request can not be retried
+func (ca *clientAuth) challenged(sc *StunClient, resp *StunPacket, code int) bool {
+	if sc.username == "" || ca.tries >= 2 || (code == ECUnauthorized && ca.key != nil) {
+		return false
+	}
+	realm, err := resp.GetRealm()
+	if err != nil {
+		return false
+	}
+	nonce, err := resp.GetNonce()
+	if err != nil {
+		return false
+	}
+	username, err := PrepareUsername(sc.username)
+	if err != nil {
+		return false
+	}
+	key, err := LongTermKey(username, realm, sc.password)
+	if err != nil {
+		return false
+	}
+	ca.realm, ca.nonce, ca.key = realm, nonce, key
+	ca.tries++
+	return true
+}
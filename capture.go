@@ -0,0 +1,560 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+
+	pcapngSHB = 0x0A0D0D0A
+	pcapngIDB = 0x00000001
+	pcapngSPB = 0x00000003
+	pcapngEPB = 0x00000006
+	pcapngBOM = 0x1A2B3C4D
+
+	maxCaptureFrame = 262144
+)
+
+var ErrNotCapture = errors.New("Not a pcap or pcapng file!")
+
+//CapturedPacket is a StunPacket read from, or written to, a capture file
+type CapturedPacket struct {
+	Time time.Time
+	//Protocol is "udp" or "tcp"
+	Protocol string
+	//Src and Dst are *net.UDPAddr or *net.TCPAddr depending on the Protocol
+	Src    net.Addr
+	Dst    net.Addr
+	Packet *StunPacket
+}
+
+type captureInterface struct {
+	linkType uint16
+	//resolution is the if_tsresol option of a pcapng interface
+	resolution byte
+}
+
+//CaptureReader reads StunPackets out of a pcap or pcapng file.
+//Only UDP datagrams and TCP segments that hold whole StunPackets are returned,
+//TCP streams are not reassembled and IP fragments are skipped
+type CaptureReader struct {
+	r       *bufio.Reader
+	ng      bool
+	order   binary.ByteOrder
+	nano    bool
+	link    uint16
+	ifaces  []captureInterface
+	pending []*CapturedPacket
+}
+
+//NewCaptureReader creates a CaptureReader, the format is detected from the file header
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	cr := &CaptureReader{r: bufio.NewReader(r)}
+	magic, err := cr.r.Peek(4)
+	if err != nil {
+		return nil, ErrNotCapture
+	}
+	if binary.BigEndian.Uint32(magic) == pcapngSHB {
+		cr.ng = true
+		return cr, nil
+	}
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(cr.r, hdr); err != nil {
+		return nil, ErrNotCapture
+	}
+	switch binary.BigEndian.Uint32(hdr) {
+	case 0xa1b2c3d4:
+		cr.order = binary.BigEndian
+	case 0xd4c3b2a1:
+		cr.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		cr.order, cr.nano = binary.BigEndian, true
+	case 0x4d3cb2a1:
+		cr.order, cr.nano = binary.LittleEndian, true
+	default:
+		return nil, ErrNotCapture
+	}
+	cr.link = uint16(cr.order.Uint32(hdr[20:24]))
+	return cr, nil
+}
+
+//Next returns the next StunPacket in the capture, io.EOF is returned at the end of the file
+func (cr *CaptureReader) Next() (*CapturedPacket, error) {
+	for len(cr.pending) == 0 {
+		var ts time.Time
+		var link uint16
+		var frame []byte
+		var err error
+		if cr.ng {
+			ts, link, frame, err = cr.readBlock()
+		} else {
+			ts, link, frame, err = cr.readRecord()
+		}
+		if err != nil {
+			return nil, err
+		}
+		cr.pending = decodeFrame(ts, link, frame)
+	}
+	cp := cr.pending[0]
+	cr.pending = cr.pending[1:]
+	return cp, nil
+}
+
+func (cr *CaptureReader) readRecord() (time.Time, uint16, []byte, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(cr.r, hdr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return time.Time{}, 0, nil, err
+		}
+		return time.Time{}, 0, nil, io.EOF
+	}
+	sec := int64(cr.order.Uint32(hdr[0:4]))
+	frac := int64(cr.order.Uint32(hdr[4:8]))
+	if !cr.nano {
+		frac *= 1000
+	}
+	size := cr.order.Uint32(hdr[8:12])
+	if size > maxCaptureFrame {
+		return time.Time{}, 0, nil, errors.New("Capture frame is too large!")
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, frame); err != nil {
+		return time.Time{}, 0, nil, io.ErrUnexpectedEOF
+	}
+	return time.Unix(sec, frac), cr.link, frame, nil
+}
+
+func (cr *CaptureReader) readBlock() (time.Time, uint16, []byte, error) {
+	for {
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(cr.r, hdr); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return time.Time{}, 0, nil, err
+			}
+			return time.Time{}, 0, nil, io.EOF
+		}
+		if binary.BigEndian.Uint32(hdr[0:4]) == pcapngSHB {
+			bom := make([]byte, 4)
+			if _, err := io.ReadFull(cr.r, bom); err != nil {
+				return time.Time{}, 0, nil, io.ErrUnexpectedEOF
+			}
+			if binary.BigEndian.Uint32(bom) == pcapngBOM {
+				cr.order = binary.BigEndian
+			} else if binary.LittleEndian.Uint32(bom) == pcapngBOM {
+				cr.order = binary.LittleEndian
+			} else {
+				return time.Time{}, 0, nil, ErrNotCapture
+			}
+			cr.ifaces = nil
+			size := cr.order.Uint32(hdr[4:8])
+			if size < 28 || size > maxCaptureFrame {
+				return time.Time{}, 0, nil, ErrNotCapture
+			}
+			if _, err := cr.r.Discard(int(size) - 12); err != nil {
+				return time.Time{}, 0, nil, io.ErrUnexpectedEOF
+			}
+			continue
+		}
+		if cr.order == nil {
+			return time.Time{}, 0, nil, ErrNotCapture
+		}
+		btype := cr.order.Uint32(hdr[0:4])
+		size := cr.order.Uint32(hdr[4:8])
+		if size < 12 || size > maxCaptureFrame || size&3 != 0 {
+			return time.Time{}, 0, nil, errors.New("Invalid pcapng block!")
+		}
+		body := make([]byte, size-8)
+		if _, err := io.ReadFull(cr.r, body); err != nil {
+			return time.Time{}, 0, nil, io.ErrUnexpectedEOF
+		}
+		body = body[:len(body)-4]
+		switch btype {
+		case pcapngIDB:
+			if len(body) < 8 {
+				return time.Time{}, 0, nil, errors.New("Invalid pcapng block!")
+			}
+			ci := captureInterface{linkType: cr.order.Uint16(body[0:2]), resolution: 6}
+			opts := body[8:]
+			for len(opts) >= 4 {
+				code := cr.order.Uint16(opts[0:2])
+				olen := int(cr.order.Uint16(opts[2:4]))
+				if code == 0 || 4+olen > len(opts) {
+					break
+				}
+				if code == 9 && olen == 1 {
+					//10^19 and 2^63 are the largest that fit in the uint64 timestamp
+					ci.resolution = opts[4]
+					if ci.resolution&0x80 == 0 && ci.resolution > 19 || ci.resolution&0x7f > 63 {
+						return time.Time{}, 0, nil, errors.New("Invalid pcapng block!")
+					}
+				}
+				opts = opts[(4+olen+3)&^3:]
+			}
+			cr.ifaces = append(cr.ifaces, ci)
+		case pcapngEPB:
+			if len(body) < 20 {
+				return time.Time{}, 0, nil, errors.New("Invalid pcapng block!")
+			}
+			id := int(cr.order.Uint32(body[0:4]))
+			ts := uint64(cr.order.Uint32(body[4:8]))<<32 | uint64(cr.order.Uint32(body[8:12]))
+			caplen := int(cr.order.Uint32(body[12:16]))
+			if id >= len(cr.ifaces) || 20+caplen > len(body) {
+				return time.Time{}, 0, nil, errors.New("Invalid pcapng block!")
+			}
+			ci := cr.ifaces[id]
+			return pcapngTime(ts, ci.resolution), ci.linkType, body[20 : 20+caplen], nil
+		case pcapngSPB:
+			if len(body) < 4 || len(cr.ifaces) == 0 {
+				return time.Time{}, 0, nil, errors.New("Invalid pcapng block!")
+			}
+			caplen := int(cr.order.Uint32(body[0:4]))
+			if caplen > len(body)-4 {
+				caplen = len(body) - 4
+			}
+			return time.Time{}, cr.ifaces[0].linkType, body[4 : 4+caplen], nil
+		}
+	}
+}
+
+func pcapngTime(ts uint64, resolution byte) time.Time {
+	if resolution&0x80 != 0 {
+		shift := uint(resolution & 0x7f)
+		sec := ts >> shift
+		frac := ts & (1<<shift - 1)
+		return time.Unix(int64(sec), int64((frac*1000000000)>>shift))
+	}
+	div := uint64(1)
+	for i := byte(0); i < resolution; i++ {
+		div *= 10
+	}
+	sec := ts / div
+	frac := ts % div
+	for div < 1000000000 {
+		frac *= 10
+		div *= 10
+	}
+	for div > 1000000000 {
+		frac /= 10
+		div /= 10
+	}
+	return time.Unix(int64(sec), int64(frac))
+}
+
+//decodeFrame strips the link, ip and udp/tcp headers off a frame and
+//returns any StunPackets in its payload
+func decodeFrame(ts time.Time, link uint16, frame []byte) []*CapturedPacket {
+	var ipb []byte
+	switch link {
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return nil
+		}
+		et := binary.BigEndian.Uint16(frame[12:14])
+		pos := 14
+		for (et == 0x8100 || et == 0x88a8) && len(frame) >= pos+4 {
+			et = binary.BigEndian.Uint16(frame[pos+2 : pos+4])
+			pos += 4
+		}
+		if et != 0x0800 && et != 0x86dd {
+			return nil
+		}
+		ipb = frame[pos:]
+	case linkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return nil
+		}
+		ipb = frame[16:]
+	case linkTypeNull:
+		if len(frame) < 4 {
+			return nil
+		}
+		ipb = frame[4:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		ipb = frame
+	default:
+		return nil
+	}
+	src, dst, proto, payload := decodeIP(ipb)
+	if payload == nil {
+		return nil
+	}
+	switch proto {
+	case 17:
+		if len(payload) < 8 {
+			return nil
+		}
+		sport := int(binary.BigEndian.Uint16(payload[0:2]))
+		dport := int(binary.BigEndian.Uint16(payload[2:4]))
+		data := payload[8:]
+		if ul := int(binary.BigEndian.Uint16(payload[4:6])); ul >= 8 && ul <= len(payload) {
+			data = payload[8:ul]
+		}
+		sp, err := NewStunPacket(append([]byte(nil), data...))
+		if err != nil {
+			return nil
+		}
+		return []*CapturedPacket{{
+			Time:     ts,
+			Protocol: "udp",
+			Src:      &net.UDPAddr{IP: src, Port: sport},
+			Dst:      &net.UDPAddr{IP: dst, Port: dport},
+			Packet:   sp,
+		}}
+	case 6:
+		if len(payload) < 20 {
+			return nil
+		}
+		off := int(payload[12]>>4) * 4
+		if off < 20 || off > len(payload) {
+			return nil
+		}
+		sport := int(binary.BigEndian.Uint16(payload[0:2]))
+		dport := int(binary.BigEndian.Uint16(payload[2:4]))
+		data := payload[off:]
+		cps := make([]*CapturedPacket, 0)
+		for len(data) >= 20 {
+			size := 20 + int(binary.BigEndian.Uint16(data[2:4]))
+			if size > len(data) {
+				break
+			}
+			sp, err := NewStunPacket(append([]byte(nil), data[:size]...))
+			if err != nil {
+				break
+			}
+			cps = append(cps, &CapturedPacket{
+				Time:     ts,
+				Protocol: "tcp",
+				Src:      &net.TCPAddr{IP: src, Port: sport},
+				Dst:      &net.TCPAddr{IP: dst, Port: dport},
+				Packet:   sp,
+			})
+			data = data[size:]
+		}
+		return cps
+	}
+	return nil
+}
+
+func decodeIP(ipb []byte) (net.IP, net.IP, byte, []byte) {
+	if len(ipb) < 1 {
+		return nil, nil, 0, nil
+	}
+	switch ipb[0] >> 4 {
+	case 4:
+		if len(ipb) < 20 {
+			return nil, nil, 0, nil
+		}
+		ihl := int(ipb[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(ipb[2:4]))
+		if ihl < 20 || total < ihl || total > len(ipb) {
+			return nil, nil, 0, nil
+		}
+		//skip fragments, only whole datagrams can be STUN
+		if binary.BigEndian.Uint16(ipb[6:8])&0x3fff != 0 {
+			return nil, nil, 0, nil
+		}
+		return net.IP(append([]byte(nil), ipb[12:16]...)), net.IP(append([]byte(nil), ipb[16:20]...)), ipb[9], ipb[ihl:total]
+	case 6:
+		if len(ipb) < 40 {
+			return nil, nil, 0, nil
+		}
+		end := 40 + int(binary.BigEndian.Uint16(ipb[4:6]))
+		if end > len(ipb) {
+			end = len(ipb)
+		}
+		next := ipb[6]
+		pos := 40
+		for next == 0 || next == 43 || next == 60 {
+			if pos+8 > end {
+				return nil, nil, 0, nil
+			}
+			next = ipb[pos]
+			pos += (int(ipb[pos+1]) + 1) * 8
+		}
+		if pos > end {
+			return nil, nil, 0, nil
+		}
+		return net.IP(append([]byte(nil), ipb[8:24]...)), net.IP(append([]byte(nil), ipb[24:40]...)), next, ipb[pos:end]
+	}
+	return nil, nil, 0, nil
+}
+
+//CaptureWriter writes StunPackets to a pcap file as raw IP frames
+type CaptureWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+	seqs map[string]uint32
+}
+
+//NewCaptureWriter creates a CaptureWriter and writes the pcap file header
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], 0xa1b23c4d)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2)
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], maxCaptureFrame)
+	binary.LittleEndian.PutUint32(hdr[20:24], linkTypeRaw)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w: w, seqs: make(map[string]uint32)}, nil
+}
+
+//WritePacket writes the CapturedPacket with made up IP and UDP/TCP headers.
+//A zero Time is written as the current time
+func (cw *CaptureWriter) WritePacket(cp *CapturedPacket) error {
+	src, sport := addrIPPort(cp.Src)
+	dst, dport := addrIPPort(cp.Dst)
+	if src.To4() != nil && dst.To4() != nil {
+		src, dst = src.To4(), dst.To4()
+	} else {
+		src, dst = src.To16(), dst.To16()
+	}
+	payload := cp.Packet.GetBytes()
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+	var proto byte
+	var tb []byte
+	if cp.Protocol == "tcp" {
+		proto = 6
+		tb = make([]byte, 20+len(payload))
+		key := cp.Src.String() + ">" + cp.Dst.String()
+		binary.BigEndian.PutUint32(tb[4:8], cw.seqs[key])
+		cw.seqs[key] += uint32(len(payload))
+		tb[12] = 5 << 4
+		tb[13] = 0x18
+		binary.BigEndian.PutUint16(tb[14:16], 65535)
+		copy(tb[20:], payload)
+	} else {
+		proto = 17
+		tb = make([]byte, 8+len(payload))
+		binary.BigEndian.PutUint16(tb[4:6], uint16(len(tb)))
+		copy(tb[8:], payload)
+	}
+	binary.BigEndian.PutUint16(tb[0:2], uint16(sport))
+	binary.BigEndian.PutUint16(tb[2:4], uint16(dport))
+	sum := checksum(pseudoHeader(src, dst, proto, len(tb)), tb)
+	if proto == 6 {
+		binary.BigEndian.PutUint16(tb[16:18], sum)
+	} else {
+		binary.BigEndian.PutUint16(tb[6:8], sum)
+	}
+	var ipb []byte
+	if len(src) == 4 {
+		ipb = make([]byte, 20, 20+len(tb))
+		ipb[0] = 0x45
+		binary.BigEndian.PutUint16(ipb[2:4], uint16(20+len(tb)))
+		binary.BigEndian.PutUint16(ipb[6:8], 0x4000)
+		ipb[8] = 64
+		ipb[9] = proto
+		copy(ipb[12:16], src)
+		copy(ipb[16:20], dst)
+		binary.BigEndian.PutUint16(ipb[10:12], checksum(ipb))
+	} else {
+		ipb = make([]byte, 40, 40+len(tb))
+		ipb[0] = 0x60
+		binary.BigEndian.PutUint16(ipb[4:6], uint16(len(tb)))
+		ipb[6] = proto
+		ipb[7] = 64
+		copy(ipb[8:24], src)
+		copy(ipb[24:40], dst)
+	}
+	ipb = append(ipb, tb...)
+	ts := cp.Time
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	hdr := make([]byte, 16)
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(ts.Nanosecond()))
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(len(ipb)))
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(len(ipb)))
+	if _, err := cw.w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := cw.w.Write(ipb)
+	return err
+}
+
+func addrIPPort(addr net.Addr) (net.IP, int) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	}
+	return net.IPv4zero, 0
+}
+
+func pseudoHeader(src net.IP, dst net.IP, proto byte, size int) []byte {
+	ph := make([]byte, 0, 40)
+	ph = append(ph, src...)
+	ph = append(ph, dst...)
+	if len(src) == 4 {
+		return append(ph, 0, proto, byte(size>>8), byte(size))
+	}
+	return append(ph, byte(size>>24), byte(size>>16), byte(size>>8), byte(size), 0, 0, 0, proto)
+}
+
+func checksum(bas ...[]byte) uint16 {
+	var sum uint32
+	for _, ba := range bas {
+		for i := 0; i+1 < len(ba); i += 2 {
+			sum += uint32(ba[i])<<8 | uint32(ba[i+1])
+		}
+		if len(ba)&1 == 1 {
+			sum += uint32(ba[len(ba)-1]) << 8
+		}
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+type recordingPacketConn struct {
+	net.PacketConn
+	cw *CaptureWriter
+}
+
+//RecordPacketConn wraps a net.PacketConn so every StunPacket read or
+//written on it is also written to the CaptureWriter
+func RecordPacketConn(conn net.PacketConn, cw *CaptureWriter) net.PacketConn {
+	return &recordingPacketConn{PacketConn: conn, cw: cw}
+}
+
+func (rc *recordingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := rc.PacketConn.ReadFrom(b)
+	if err == nil {
+		rc.record(addr, rc.LocalAddr(), b[:n])
+	}
+	return n, addr, err
+}
+
+func (rc *recordingPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := rc.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		rc.record(rc.LocalAddr(), addr, b[:n])
+	}
+	return n, err
+}
+
+func (rc *recordingPacketConn) record(src net.Addr, dst net.Addr, ba []byte) {
+	sp, err := NewStunPacket(append([]byte(nil), ba...))
+	if err != nil {
+		return
+	}
+	rc.cw.WritePacket(&CapturedPacket{Time: time.Now(), Protocol: "udp", Src: src, Dst: dst, Packet: sp})
+}
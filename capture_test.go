@@ -0,0 +1,141 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cw, err := NewCaptureWriter(buf)
+	assert.NoError(t, err)
+	ba, _ := hex.DecodeString(SPREQ1)
+	req, _ := NewStunPacket(ba)
+	ts := time.Unix(1500000000, 123456789)
+	c4 := &net.UDPAddr{IP: net.ParseIP("192.0.2.1").To4(), Port: 32853}
+	s4 := &net.UDPAddr{IP: net.ParseIP("198.51.100.1").To4(), Port: 3478}
+	s6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 3478}
+	c6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 40000}
+	assert.NoError(t, cw.WritePacket(&CapturedPacket{Time: ts, Protocol: "udp", Src: c4, Dst: s4, Packet: req}))
+	assert.NoError(t, cw.WritePacket(&CapturedPacket{Time: ts, Protocol: "tcp", Src: c6, Dst: s6, Packet: req}))
+
+	cr, err := NewCaptureReader(buf)
+	assert.NoError(t, err)
+	cp, err := cr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, ts.UnixNano(), cp.Time.UnixNano())
+	assert.Equal(t, "udp", cp.Protocol)
+	assert.Equal(t, c4.String(), cp.Src.String())
+	assert.Equal(t, s4.String(), cp.Dst.String())
+	assert.Equal(t, req.GetBytes(), cp.Packet.GetBytes())
+	cp, err = cr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp", cp.Protocol)
+	assert.Equal(t, c6.String(), cp.Src.String())
+	assert.Equal(t, s6.String(), cp.Dst.String())
+	_, err = cr.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestCapturePcapng(t *testing.T) {
+	ba, _ := hex.DecodeString(SPRESP1)
+	udp := make([]byte, 8, 8+len(ba))
+	binary.BigEndian.PutUint16(udp[0:2], 3478)
+	binary.BigEndian.PutUint16(udp[2:4], 32853)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(ba)))
+	udp = append(udp, ba...)
+	ip := []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 64, 17, 0, 0, 198, 51, 100, 1, 192, 0, 2, 1}
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(udp)))
+	eth := append(make([]byte, 12), 0x81, 0x00, 0, 1, 0x08, 0x00)
+	frame := append(append(eth, ip...), udp...)
+	junk := append(append([]byte(nil), eth...), 0x45, 0)
+
+	le := binary.LittleEndian
+	block := func(btype uint32, body []byte) []byte {
+		for len(body)&3 != 0 {
+			body = append(body, 0)
+		}
+		b := make([]byte, 8, 12+len(body))
+		le.PutUint32(b[0:4], btype)
+		le.PutUint32(b[4:8], uint32(12+len(body)))
+		b = append(b, body...)
+		return append(b, b[4:8]...)
+	}
+	shb := make([]byte, 16)
+	le.PutUint32(shb[0:4], pcapngBOM)
+	le.PutUint16(shb[4:6], 1)
+	binary.BigEndian.PutUint64(shb[8:16], 0xffffffffffffffff)
+	idb := []byte{1, 0, 0, 0, 0, 0, 0, 0, 9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0}
+	epb := func(data []byte) []byte {
+		b := make([]byte, 20)
+		ts := uint64(1500000000123456789)
+		le.PutUint32(b[4:8], uint32(ts>>32))
+		le.PutUint32(b[8:12], uint32(ts))
+		le.PutUint32(b[12:16], uint32(len(data)))
+		le.PutUint32(b[16:20], uint32(len(data)))
+		return append(b, data...)
+	}
+	file := block(pcapngSHB, shb)
+	file = append(file, block(pcapngIDB, idb)...)
+	file = append(file, block(pcapngEPB, epb(junk))...)
+	file = append(file, block(pcapngEPB, epb(frame))...)
+
+	cr, err := NewCaptureReader(bytes.NewReader(file))
+	assert.NoError(t, err)
+	cp, err := cr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1500000000123456789), cp.Time.UnixNano())
+	assert.Equal(t, "198.51.100.1:3478", cp.Src.String())
+	assert.Equal(t, "192.0.2.1:32853", cp.Dst.String())
+	a, _ := cp.Packet.GetAddress()
+	assert.Equal(t, "192.0.2.1:32853", a.String())
+	_, err = cr.Next()
+	assert.Equal(t, io.EOF, err)
+
+	//an if_tsresol of 10^64 or 2^64 can not be used
+	for _, res := range []byte{64, 0x80 | 64} {
+		idb[12] = res
+		file = block(pcapngSHB, shb)
+		file = append(file, block(pcapngIDB, idb)...)
+		file = append(file, block(pcapngEPB, epb(frame))...)
+		cr, err = NewCaptureReader(bytes.NewReader(file))
+		assert.NoError(t, err)
+		_, err = cr.Next()
+		assert.EqualError(t, err, "Invalid pcapng block!")
+	}
+}
+
+func TestNotCapture(t *testing.T) {
+	_, err := NewCaptureReader(bytes.NewReader([]byte("this is not a pcap file at all")))
+	assert.Equal(t, ErrNotCapture, err)
+}
+
+func TestRecordPacketConn(t *testing.T) {
+	_, sconn := startServer(t)
+	defer sconn.Close()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer conn.Close()
+	buf := &bytes.Buffer{}
+	cw, _ := NewCaptureWriter(buf)
+	sc := NewStunClient(RecordPacketConn(conn, cw)).SetRTO(50 * time.Millisecond)
+	_, err = sc.Bind(sconn.LocalAddr())
+	assert.NoError(t, err)
+
+	cr, _ := NewCaptureReader(buf)
+	cp, err := cr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, SMRequest, cp.Packet.GetStunMessageType())
+	assert.Equal(t, sconn.LocalAddr().String(), cp.Dst.String())
+	cp, err = cr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, SMSuccess, cp.Packet.GetStunMessageType())
+	assert.Equal(t, sconn.LocalAddr().String(), cp.Src.String())
+}
@@ -0,0 +1,332 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+const (
+	DefaultRTO          = 500 * time.Millisecond
+	DefaultRetransmits  = 6
+	DefaultMaxRedirects = 3
+	//Rm from rfc5389, how many RTOs to wait after the last retransmit
+	lastWaitRTOs = 16
+)
+
+var (
+	ErrTimeout      = errors.New("Stun Transaction Timeout!")
+	ErrRedirectLoop = errors.New("AlternateServer Redirect Loop!")
+	ErrMaxRedirects = errors.New("Too Many AlternateServer Redirects!")
+	ErrNoAlternate  = errors.New("Try Alternate without AlternateServer!")
+	ErrBadIntegrity = errors.New("Response MessageIntegrity is invalid!")
+	ErrNoServer     = errors.New("No Stun Server to send to!")
+)
+
+//StunError is returned when a server answers with an SMFailure
+type StunError struct {
+	Code   int
+	Reason string
+}
+
+func (se *StunError) Error() string {
+	return fmt.Sprintf("Stun Error %d: %s", se.Code, se.Reason)
+}
+
+//BindResult is the outcome of a Binding transaction
+type BindResult struct {
+	//Server is the server that finally answered the request
+	Server net.Addr
+	//Redirects are the servers that sent us somewhere else, in order
+	Redirects []net.Addr
+	Response  *StunPacket
+	//Address is the mapped address the server saw us as
+	Address     *net.UDPAddr
+	RTT         time.Duration
+	Retransmits int
+}
+
+//StunClient sends stun Binding requests over a net.PacketConn
+type StunClient struct {
+	conn         net.PacketConn
+	rto          time.Duration
+	retransmits  int
+	maxRedirects int
+	username     string
+	password     string
+	hooks        Hooks
+	logger       *slog.Logger
+	tm           *TransactionManager
+	tidGen       TIDGenerator
+	server       net.Addr
+}
+
+//NewStunClient creates a StunClient that will send requests on the provided net.PacketConn
+func NewStunClient(conn net.PacketConn) *StunClient {
+	return &StunClient{
+		conn:         conn,
+		rto:          DefaultRTO,
+		retransmits:  DefaultRetransmits,
+		maxRedirects: DefaultMaxRedirects,
+		hooks:        NoopHooks{},
+	}
+}
+
+//Close closes the net.PacketConn the StunClient sends on
+func (sc *StunClient) Close() error {
+	return sc.conn.Close()
+}
+
+//SetRTO sets the initial retransmission timeout, it doubles after every retransmit
+func (sc *StunClient) SetRTO(rto time.Duration) *StunClient {
+	sc.rto = rto
+	return sc
+}
+
+//SetRetransmits sets how many times a request is resent before giving up
+func (sc *StunClient) SetRetransmits(count int) *StunClient {
+	sc.retransmits = count
+	return sc
+}
+
+//SetMaxRedirects sets how many 300 Try Alternate responses will be followed
+func (sc *StunClient) SetMaxRedirects(count int) *StunClient {
+	sc.maxRedirects = count
+	return sc
+}
+
+//SetHooks sets the Hooks called as requests are sent, nil removes them
+func (sc *StunClient) SetHooks(hooks Hooks) *StunClient {
+	if hooks == nil {
+		hooks = NoopHooks{}
+	}
+	sc.hooks = hooks
+	return sc
+}
+
+//SetLogger sets the slog.Logger used to log retransmits, timeouts and failed requests, nil disables logging
+func (sc *StunClient) SetLogger(logger *slog.Logger) *StunClient {
+	sc.logger = logger
+	return sc
+}
+
+//SetTIDGenerator sets the TIDGenerator used for new requests, nil uses CreateTID
+func (sc *StunClient) SetTIDGenerator(tg TIDGenerator) *StunClient {
+	sc.tidGen = tg
+	return sc
+}
+
+//SetServer sets the server requests are sent to when Bind, BindContext or Do are
+//given a nil server. StunClients from NewStunClientURI and URIDialer have it set
+func (sc *StunClient) SetServer(server net.Addr) *StunClient {
+	sc.server = server
+	return sc
+}
+
+//Server returns the server set with SetServer
+func (sc *StunClient) Server() net.Addr {
+	return sc.server
+}
+
+//Bind sends a Binding request to the server and waits for the response,
+//following any AlternateServer redirects and long-term credential challenges.
+//A nil server uses the one from SetServer
+func (sc *StunClient) Bind(server net.Addr) (*BindResult, error) {
+	return sc.bind(context.Background(), server, 0)
+}
+
+//BindContext is Bind that gives up with the ctx error once ctx is done
+func (sc *StunClient) BindContext(ctx context.Context, server net.Addr) (*BindResult, error) {
+	return sc.bind(ctx, server, 0)
+}
+
+func (sc *StunClient) bind(ctx context.Context, server net.Addr, change uint32) (*BindResult, error) {
+	if server == nil {
+		if server = sc.server; server == nil {
+			return nil, ErrNoServer
+		}
+	}
+	br, err := sc.follow(ctx, server, change)
+	if err != nil && err != ErrTimeout && ctx.Err() == nil {
+		sc.hooks.OnError(err, server)
+		logPacket(sc.logger, slog.LevelWarn, "Binding failed", nil, server, errAttr(err))
+	}
+	return br, err
+}
+
+func (sc *StunClient) follow(ctx context.Context, server net.Addr, change uint32) (*BindResult, error) {
+	visited := make(map[string]bool)
+	redirects := make([]net.Addr, 0)
+	auth := &clientAuth{}
+	for {
+		visited[server.String()] = true
+		spb := NewStunPacketBuilder().SetStunMessage(SMRequest).SetTIDGenerator(sc.tidGen)
+		if change != 0 {
+			spb.SetValue(SAChangeRequest, change)
+		}
+		if err := auth.sign(sc, spb); err != nil {
+			return nil, err
+		}
+		resp, rtt, rt, err := sc.transact(ctx, server, spb.Build())
+		if err != nil {
+			return nil, err
+		}
+		if resp.GetStunMessageType() == SMFailure {
+			code, reason, err := resp.GetErrorCode()
+			if err != nil {
+				return nil, err
+			}
+			logPacket(sc.logger, slog.LevelDebug, "Got error response", resp, server)
+			switch {
+			case code == ECTryAlternate:
+				alt, err := resp.GetAlternateServer()
+				if err != nil {
+					return nil, ErrNoAlternate
+				}
+				if visited[alt.String()] {
+					return nil, ErrRedirectLoop
+				}
+				if len(redirects) >= sc.maxRedirects {
+					return nil, ErrMaxRedirects
+				}
+				redirects = append(redirects, server)
+				server = alt
+				auth = &clientAuth{}
+				continue
+			case (code == ECUnauthorized || code == ECStaleNonce) && auth.challenged(sc, resp, code):
+				continue
+			}
+			return nil, &StunError{Code: code, Reason: reason}
+		}
+		if auth.key != nil && !resp.VerifyMessageIntegrity(auth.key) {
+			logPacket(sc.logger, slog.LevelWarn, "Dropped response", resp, server, errAttr(ErrBadIntegrity))
+			return nil, ErrBadIntegrity
+		}
+		addr, err := resp.GetAddressPreferXOR()
+		if err != nil {
+			return nil, err
+		}
+		return &BindResult{
+			Server:      server,
+			Redirects:   redirects,
+			Response:    resp,
+			Address:     addr,
+			RTT:         rtt,
+			Retransmits: rt,
+		}, nil
+	}
+}
+
+//SetTransactionManager makes the StunClient send its requests through a
+//TransactionManager instead of reading the net.PacketConn itself, so the
+//net.PacketConn can be shared. Serve must be running on the TransactionManager
+func (sc *StunClient) SetTransactionManager(tm *TransactionManager) *StunClient {
+	sc.tm = tm
+	return sc
+}
+
+//Do sends any request to the server with retransmissions and returns the
+//response, which can be an SMFailure. A nil server uses the one from SetServer
+func (sc *StunClient) Do(server net.Addr, req *StunPacket) (*StunPacket, error) {
+	if server == nil {
+		if server = sc.server; server == nil {
+			return nil, ErrNoServer
+		}
+	}
+	resp, _, _, err := sc.transact(context.Background(), server, req)
+	if err != nil && err != ErrTimeout {
+		sc.hooks.OnError(err, server)
+	}
+	return resp, err
+}
+
+//transact sends the request with rfc5389 retransmissions until a response
+//with a matching TransactionID shows up or ctx is done
+func (sc *StunClient) transact(ctx context.Context, server net.Addr, req *StunPacket) (*StunPacket, time.Duration, int, error) {
+	if sc.tm != nil {
+		wait := sc.tm.expect(ctx, req)
+		defer sc.tm.forget(req)
+		return sc.retransmit(server, req, wait)
+	}
+	defer sc.conn.SetReadDeadline(time.Time{})
+	//a read that is already blocked is woken up by moving its deadline
+	stop := context.AfterFunc(ctx, func() { sc.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	return sc.retransmit(server, req, sc.readResponse(ctx, req))
+}
+
+//readResponse returns a wait func for retransmit that reads the net.PacketConn
+//until the response to req shows up, from any address
+func (sc *StunClient) readResponse(ctx context.Context, req *StunPacket) func(time.Time) (*StunPacket, error) {
+	tid := req.GetTxID().GetTID()
+	ba := make([]byte, 65536)
+	return func(deadline time.Time) (*StunPacket, error) {
+		sc.conn.SetReadDeadline(deadline)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for {
+			n, from, err := sc.conn.ReadFrom(ba)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					return nil, ctx.Err()
+				}
+				return nil, err
+			}
+			sp, err := NewStunPacket(ba[:n])
+			if err != nil {
+				logPacket(sc.logger, slog.LevelDebug, "Dropped invalid packet", nil, from, errAttr(err))
+				continue
+			}
+			if !bytes.Equal(sp.GetTxID().GetTID(), tid) {
+				logPacket(sc.logger, slog.LevelDebug, "Dropped unexpected response", sp, from)
+				continue
+			}
+			return NewStunPacket(append([]byte(nil), ba[:n]...))
+		}
+	}
+}
+
+//retransmit does the rfc5389 retransmission schedule, wait returns a nil
+//StunPacket when the deadline passes without a response
+func (sc *StunClient) retransmit(server net.Addr, req *StunPacket, wait func(time.Time) (*StunPacket, error)) (*StunPacket, time.Duration, int, error) {
+	conn := sc.conn
+	if sc.tm != nil {
+		conn = sc.tm.conn
+	}
+	rto := sc.rto
+	for sent := 0; sent <= sc.retransmits; sent++ {
+		if sent == 0 {
+			sc.hooks.OnRequest(req, server)
+		} else {
+			sc.hooks.OnRetransmit(req, server, sent)
+			logPacket(sc.logger, slog.LevelDebug, "Retransmitting request", req, server, slog.Int("attempt", sent))
+		}
+		start := time.Now()
+		_, err := conn.WriteTo(req.GetBytes(), server)
+		if err != nil {
+			return nil, 0, sent, err
+		}
+		timeout := rto
+		if sent == sc.retransmits {
+			timeout = sc.rto * lastWaitRTOs
+		}
+		resp, err := wait(start.Add(timeout))
+		if err != nil {
+			return nil, 0, sent, err
+		}
+		if resp != nil {
+			rtt := time.Since(start)
+			sc.hooks.OnResponse(resp, server, rtt)
+			return resp, rtt, sent, nil
+		}
+		rto *= 2
+	}
+	sc.hooks.OnTimeout(req, server)
+	logPacket(sc.logger, slog.LevelWarn, "Request timed out", req, server, slog.Int("retransmits", sc.retransmits))
+	return nil, 0, sc.retransmits, ErrTimeout
+}
@@ -0,0 +1,205 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//startServer runs a StunServer on loopback, setup is called before Serve so the
+//server is never changed while it is answering requests
+func startServer(t *testing.T, setup ...func(ss *StunServer)) (*StunServer, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	ss := NewStunServer(conn)
+	for _, f := range setup {
+		f(ss)
+	}
+	go ss.Serve()
+	return ss, conn
+}
+
+func newClient(t *testing.T) (*StunClient, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	return NewStunClient(conn).SetRTO(50 * time.Millisecond).SetRetransmits(2), conn
+}
+
+func TestClientBind(t *testing.T) {
+	_, sconn := startServer(t)
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+
+	br, err := sc.Bind(sconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), br.Address.String())
+	assert.Equal(t, sconn.LocalAddr(), br.Server)
+	assert.Equal(t, 0, len(br.Redirects))
+}
+
+func TestClientPrefersXOR(t *testing.T) {
+	//a NAT that rewrites the SAMappedAddress does not change what Bind returns
+	_, sconn := startManager(t, func(sp *StunPacket, addr net.Addr) *StunPacket {
+		return NewStunPacketBuilder().SetStunMessage(SMSuccess).SetTXID(sp.GetTxID()).
+			SetAddress(&net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1}).SetXORAddress(addr.(*net.UDPAddr)).Build()
+	})
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+
+	br, err := sc.Bind(sconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), br.Address.String())
+}
+
+func TestServeMalformed(t *testing.T) {
+	_, sconn := startServer(t)
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+
+	//an attribute with a length of 200 in a 24 byte request
+	bad := NewStunPacketBuilder().Build().GetBytes()
+	bad = append(bad, 0x80, 0x22, 0, 200)
+	bad[3] = 4
+	_, err := cconn.WriteTo(bad, sconn.LocalAddr())
+	assert.NoError(t, err)
+	br, err := sc.Bind(sconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), br.Address.String())
+}
+
+func TestClientFollowsAlternate(t *testing.T) {
+	_, good := startServer(t)
+	defer good.Close()
+	_, bconn := startServer(t, func(ss *StunServer) {
+		ss.SetAlternateServer(good.LocalAddr().(*net.UDPAddr), "stun.example.com").SetMaxLoad(0)
+	})
+	defer bconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+
+	br, err := sc.Bind(bconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, good.LocalAddr().String(), br.Server.String())
+	assert.Equal(t, []net.Addr{bconn.LocalAddr()}, br.Redirects)
+	assert.Equal(t, cconn.LocalAddr().String(), br.Address.String())
+}
+
+func TestClientRedirectLoop(t *testing.T) {
+	c1, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer c1.Close()
+	_, c2 := startServer(t, func(ss *StunServer) {
+		ss.SetAlternateServer(c1.LocalAddr().(*net.UDPAddr), "").SetMaxLoad(0)
+	})
+	defer c2.Close()
+	s1 := NewStunServer(c1).SetAlternateServer(c2.LocalAddr().(*net.UDPAddr), "").SetMaxLoad(0)
+	go s1.Serve()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+
+	_, err = sc.Bind(c1.LocalAddr())
+	assert.Equal(t, ErrRedirectLoop, err)
+}
+
+func TestClientTimeout(t *testing.T) {
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer dead.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	sc.SetRTO(5 * time.Millisecond)
+
+	_, err = sc.Bind(dead.LocalAddr())
+	assert.Equal(t, ErrTimeout, err)
+}
+
+func TestServerMaxLoad(t *testing.T) {
+	alt := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 3478}
+	ss := NewStunServer(nil).SetAlternateServer(alt, "stun.example.com").SetMaxLoad(1)
+	from := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+	req := NewStunPacketBuilder().Build()
+
+	resp := ss.HandlePacket(req, from)
+	assert.Equal(t, SMSuccess, resp.GetStunMessageType())
+	resp = ss.HandlePacket(req, from)
+	assert.Equal(t, SMFailure, resp.GetStunMessageType())
+	code, _, err := resp.GetErrorCode()
+	assert.NoError(t, err)
+	assert.Equal(t, ECTryAlternate, code)
+	ra, err := resp.GetAlternateServer()
+	assert.NoError(t, err)
+	assert.Equal(t, alt.String(), ra.String())
+	domain, err := resp.GetAlternateDomain()
+	assert.NoError(t, err)
+	assert.Equal(t, "stun.example.com", domain)
+}
+
+func TestClientLongTermAuth(t *testing.T) {
+	_, sconn := startServer(t, func(ss *StunServer) {
+		ss.SetSoftware("stunlib").SetLongTermAuth("example.org", func(username string) ([]byte, bool) {
+			if username != "user" {
+				return nil, false
+			}
+			key, _ := LongTermKey("user", "example.org", "pass")
+			return key, true
+		})
+	})
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+
+	_, err := sc.Bind(sconn.LocalAddr())
+	assert.Equal(t, &StunError{Code: ECUnauthorized, Reason: "Unauthorized"}, err)
+	sc.SetCredentials("user", "wrong")
+	_, err = sc.Bind(sconn.LocalAddr())
+	assert.Equal(t, &StunError{Code: ECUnauthorized, Reason: "Unauthorized"}, err)
+	sc.SetCredentials("user", "pass")
+	br, err := sc.Bind(sconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), br.Address.String())
+	assert.True(t, br.Response.HasMessageIntegrity())
+	s, _ := br.Response.GetSoftware()
+	assert.Equal(t, "stunlib", s)
+}
+
+func TestStaleNonce(t *testing.T) {
+	ss := NewStunServer(nil).SetLongTermAuth("example.org", func(username string) ([]byte, bool) {
+		return []byte("key"), true
+	})
+	spb := NewStunPacketBuilder()
+	spb.SetUsername("user")
+	spb.SetRealm("example.org")
+	spb.SetNonce("5f5e1000-0123456789abcdef")
+	from := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+	resp := ss.HandlePacket(spb.SetMessageIntegrity([]byte("key")).Build(), from)
+	code, _, _ := resp.GetErrorCode()
+	assert.Equal(t, ECStaleNonce, code)
+	nonce, err := resp.GetNonce()
+	assert.NoError(t, err)
+	assert.True(t, ss.auth.validNonce(nonce))
+}
+
+func TestServeStream(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	go NewStunServer(nil).ServeStream(l)
+	c, err := net.Dial("tcp4", l.Addr().String())
+	assert.NoError(t, err)
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		req := NewStunPacketBuilder().Build()
+		_, err = c.Write(req.GetBytes())
+		assert.NoError(t, err)
+		resp, err := ReadStunPacket(c)
+		assert.NoError(t, err)
+		assert.Equal(t, req.GetTxID(), resp.GetTxID())
+		a, _ := resp.GetAddress()
+		assert.Equal(t, c.LocalAddr().String(), a.String())
+	}
+}
@@ -0,0 +1,100 @@
+package main // import "github.com/lwahlmeier/stunlib/cmd/stun"
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lwahlmeier/stunlib"
+)
+
+func decode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	format := fs.String("format", "hex", "input format: hex, base64 or pcap")
+	asJSON := fs.Bool("json", false, "print packets as JSON instead of a dump")
+	password := fs.String("password", "", "short-term password used to check MESSAGE-INTEGRITY")
+	fs.Parse(args)
+	var key []byte
+	if *password != "" {
+		k, err := stunlib.ShortTermKey(*password)
+		if err != nil {
+			return err
+		}
+		key = k
+	}
+	output := func(sp *stunlib.StunPacket) error {
+		if *asJSON {
+			ba, err := json.Marshal(sp)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(ba))
+			return nil
+		}
+		fmt.Print(sp.Dump(key))
+		return nil
+	}
+
+	if *format == "pcap" {
+		var r io.Reader = os.Stdin
+		if fs.NArg() > 0 {
+			f, err := os.Open(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		cr, err := stunlib.NewCaptureReader(r)
+		if err != nil {
+			return err
+		}
+		for {
+			cp, err := cr.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if !*asJSON {
+				fmt.Printf("%s %s %s => %s\n", cp.Time.Format("2006-01-02 15:04:05.000000"), cp.Protocol, cp.Src, cp.Dst)
+			}
+			if err := output(cp.Packet); err != nil {
+				return err
+			}
+		}
+	}
+
+	input := strings.Join(fs.Args(), "")
+	if fs.NArg() == 0 {
+		ba, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		input = string(ba)
+	}
+	input = strings.Join(strings.Fields(input), "")
+	var ba []byte
+	var err error
+	switch *format {
+	case "hex":
+		ba, err = hex.DecodeString(strings.TrimPrefix(input, "0x"))
+	case "base64":
+		ba, err = base64.StdEncoding.DecodeString(input)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+	sp, err := stunlib.NewStunPacket(ba)
+	if err != nil {
+		return err
+	}
+	return output(sp)
+}
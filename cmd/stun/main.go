@@ -0,0 +1,78 @@
+//stun is a command line tool for querying, serving and decoding stun packets
+package main // import "github.com/lwahlmeier/stunlib/cmd/stun"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/lwahlmeier/stunlib"
+)
+
+const defaultPort = "3478"
+
+var commands = map[string]func(args []string) error{
+	"query":  query,
+	"serve":  serve,
+	"decode": decode,
+	"nat":    nat,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "ERR:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: stun <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  query host[:port]  send a Binding request and show the mapped address")
+	fmt.Fprintln(os.Stderr, "  serve              run a stun server")
+	fmt.Fprintln(os.Stderr, "  decode [input]     dump hex, base64 or pcap stun packets")
+	fmt.Fprintln(os.Stderr, "  nat host[:port]    run rfc5780 NAT discovery against a server")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "run 'stun <command> -h' for the flags of a command")
+	os.Exit(2)
+}
+
+//withDefaultPort adds the default stun port to a host that does not have one
+func withDefaultPort(hostport string) string {
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		return net.JoinHostPort(hostport, defaultPort)
+	}
+	return hostport
+}
+
+//resolveServer finds the stun server for a host or a stun:/turn: URI, using
+//_stun._udp SRV records when it has no port
+func resolveServer(host string) (*net.UDPAddr, error) {
+	u, err := stunlib.ParseURI(host)
+	if err != nil {
+		u = &stunlib.URI{Scheme: "stun", Host: host}
+	}
+	if u.IsSecure() || u.Transport == "tcp" {
+		return nil, errors.New("only udp servers can be queried")
+	}
+	sts, err := u.Resolve(context.Background(), stunlib.NewServerResolver(nil))
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range sts {
+		if st.Transport == "udp" {
+			return net.ResolveUDPAddr("udp", st.Addr())
+		}
+	}
+	return nil, stunlib.ErrNoServers
+}
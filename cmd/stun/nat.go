@@ -0,0 +1,48 @@
+package main // import "github.com/lwahlmeier/stunlib/cmd/stun"
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/lwahlmeier/stunlib"
+)
+
+func nat(args []string) error {
+	fs := flag.NewFlagSet("nat", flag.ExitOnError)
+	local := fs.String("local", ":0", "local address to send from")
+	rto := fs.Duration("rto", 200*time.Millisecond, "initial retransmission timeout")
+	retransmits := fs.Int("retransmits", 2, "how many times to resend each request")
+	user := fs.String("user", "", "username for long-term credentials")
+	pass := fs.String("pass", "", "password for long-term credentials")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: stun nat [flags] host[:port]")
+	}
+	server, err := resolveServer(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", *local)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	sc := stunlib.NewStunClient(conn).SetRTO(*rto).SetRetransmits(*retransmits).SetCredentials(*user, *pass)
+	nr, err := sc.DiscoverNAT(server)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Mapped Address: %s\n", nr.MappedAddress)
+	fmt.Printf("Behind NAT: %t\n", nr.NAT)
+	if nr.OtherAddress == nil {
+		fmt.Println("Server does not support rfc5780, NAT behavior is unknown")
+		return nil
+	}
+	fmt.Printf("Other Address: %s\n", nr.OtherAddress)
+	fmt.Printf("Mapping: %s\n", nr.Mapping)
+	fmt.Printf("Filtering: %s\n", nr.Filtering)
+	return nil
+}
@@ -0,0 +1,57 @@
+package main // import "github.com/lwahlmeier/stunlib/cmd/stun"
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/lwahlmeier/stunlib"
+)
+
+func query(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	local := fs.String("local", ":0", "local address to send from")
+	rto := fs.Duration("rto", stunlib.DefaultRTO, "initial retransmission timeout")
+	retransmits := fs.Int("retransmits", stunlib.DefaultRetransmits, "how many times to resend the request")
+	user := fs.String("user", "", "username for long-term credentials")
+	pass := fs.String("pass", "", "password for long-term credentials")
+	verbose := fs.Bool("v", false, "dump the response packet and log retransmits")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: stun query [flags] host[:port]")
+	}
+	server, err := resolveServer(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", *local)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	sc := stunlib.NewStunClient(conn).SetRTO(*rto).SetRetransmits(*retransmits).SetCredentials(*user, *pass)
+	if *verbose {
+		sc.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	}
+	br, err := sc.Bind(server)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Mapped Address: %s\n", br.Address)
+	fmt.Printf("Server: %s\n", br.Server)
+	for _, r := range br.Redirects {
+		fmt.Printf("Redirected By: %s\n", r)
+	}
+	fmt.Printf("RTT: %s\n", br.RTT)
+	fmt.Printf("Retransmits: %d\n", br.Retransmits)
+	if software, err := br.Response.GetSoftware(); err == nil {
+		fmt.Printf("Software: %s\n", software)
+	}
+	if *verbose {
+		fmt.Print(br.Response)
+	}
+	return nil
+}
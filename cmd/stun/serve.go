@@ -0,0 +1,185 @@
+package main // import "github.com/lwahlmeier/stunlib/cmd/stun"
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lwahlmeier/stunlib"
+)
+
+//userFlag collects repeated -user user:pass flags
+type userFlag map[string]string
+
+func (uf userFlag) String() string {
+	return fmt.Sprintf("%d users", len(uf))
+}
+
+func (uf userFlag) Set(s string) error {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return errors.New("users must be user:pass")
+	}
+	uf[parts[0]] = parts[1]
+	return nil
+}
+
+func serve(args []string) error {
+	users := make(userFlag)
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	ip := fs.String("ip", "0.0.0.0", "ip to listen on")
+	port := fs.Int("port", 3478, "udp (and tcp) port to listen on")
+	altIP := fs.String("alt-ip", "", "alternate ip for rfc5780 CHANGE-REQUEST and OTHER-ADDRESS")
+	altPort := fs.Int("alt-port", 0, "alternate port for rfc5780 CHANGE-REQUEST")
+	useTCP := fs.Bool("tcp", false, "also listen for tcp on the port")
+	tlsPort := fs.Int("tls-port", 5349, "tls port, used when -cert and -key are set")
+	cert := fs.String("cert", "", "tls certificate file")
+	keyFile := fs.String("key", "", "tls key file")
+	realm := fs.String("realm", "", "realm for long-term credentials, requires -user")
+	fs.Var(users, "user", "user:pass allowed to use the server, can be repeated")
+	software := fs.String("software", "stunlib", "SOFTWARE to send in responses")
+	alternate := fs.String("alternate", "", "host:port of the server to redirect to when over -max-load")
+	maxLoad := fs.Int("max-load", -1, "requests per second before redirecting to -alternate")
+	rate := fs.Float64("rate", 0, "requests per second allowed from each source ip, 0 is unlimited")
+	burst := fs.Int("burst", 10, "burst of requests allowed from each source ip with -rate")
+	deny := fs.String("deny", "", "comma separated CIDRs to never answer")
+	noAmplify := fs.Bool("no-amplification", false, "drop responses larger than their request")
+	metricsAddr := fs.String("metrics", "", "address to serve prometheus metrics on at /metrics, ie :9090")
+	verbose := fs.Bool("v", false, "log every dropped packet and error response")
+	fs.Parse(args)
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	listen := func(ip string, port int) (net.PacketConn, error) {
+		return net.ListenPacket("udp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	}
+	primary, err := listen(*ip, *port)
+	if err != nil {
+		return err
+	}
+	servers := []*stunlib.StunServer{stunlib.NewStunServer(primary)}
+	if *altIP != "" || *altPort != 0 {
+		var ip1port2, ip2port1, ip2port2 net.PacketConn
+		if *altPort != 0 {
+			if ip1port2, err = listen(*ip, *altPort); err != nil {
+				return err
+			}
+		}
+		if *altIP != "" {
+			if ip2port1, err = listen(*altIP, *port); err != nil {
+				return err
+			}
+			if *altPort != 0 {
+				if ip2port2, err = listen(*altIP, *altPort); err != nil {
+					return err
+				}
+			}
+		}
+		servers = stunlib.NewStunServers(primary, ip1port2, ip2port1, ip2port2)
+	}
+
+	var keys func(string) ([]byte, bool)
+	if len(users) > 0 {
+		if *realm == "" {
+			return errors.New("-realm is required with -user")
+		}
+		keys = func(username string) ([]byte, bool) {
+			pass, ok := users[username]
+			if !ok {
+				return nil, false
+			}
+			key, err := stunlib.LongTermKey(username, *realm, pass)
+			return key, err == nil
+		}
+	}
+	var altAddr *net.UDPAddr
+	if *alternate != "" {
+		if altAddr, err = net.ResolveUDPAddr("udp", withDefaultPort(*alternate)); err != nil {
+			return err
+		}
+	}
+	var limiter stunlib.Limiter
+	if *rate > 0 || *deny != "" {
+		rl := stunlib.NewRateLimiter(*rate, *burst)
+		if *rate <= 0 {
+			//only the deny list, every other address is on the allow list
+			rl.AllowCIDR("0.0.0.0/0")
+			rl.AllowCIDR("::/0")
+		}
+		for _, cidr := range strings.Split(*deny, ",") {
+			if cidr == "" {
+				continue
+			}
+			if err := rl.DenyCIDR(cidr); err != nil {
+				return err
+			}
+		}
+		limiter = rl
+	}
+	metrics := stunlib.NewMetrics()
+	for _, ss := range servers {
+		ss.SetSoftware(*software).SetMaxLoad(*maxLoad).SetLimiter(limiter).SetNoAmplification(*noAmplify).SetLogger(logger)
+		if *metricsAddr != "" {
+			ss.SetHooks(metrics.ServerHooks())
+		}
+		if keys != nil {
+			ss.SetLongTermAuth(*realm, keys)
+		}
+		if altAddr != nil {
+			ss.SetAlternateServer(altAddr, "")
+		}
+	}
+
+	errs := make(chan error, len(servers)+3)
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		fmt.Printf("Serving metrics on %s/metrics\n", *metricsAddr)
+		go func() {
+			errs <- http.ListenAndServe(*metricsAddr, mux)
+		}()
+	}
+	for _, ss := range servers {
+		go func(ss *stunlib.StunServer) {
+			errs <- ss.Serve()
+		}(ss)
+	}
+	addr := net.JoinHostPort(*ip, strconv.Itoa(*port))
+	fmt.Printf("Serving udp on %s\n", addr)
+	if *useTCP {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Serving tcp on %s\n", addr)
+		go func() {
+			errs <- servers[0].ServeStream(l)
+		}()
+	}
+	if *cert != "" && *keyFile != "" {
+		pair, err := tls.LoadX509KeyPair(*cert, *keyFile)
+		if err != nil {
+			return err
+		}
+		taddr := net.JoinHostPort(*ip, strconv.Itoa(*tlsPort))
+		l, err := tls.Listen("tcp", taddr, &tls.Config{Certificates: []tls.Certificate{pair}})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Serving tls on %s\n", taddr)
+		go func() {
+			errs <- servers[0].ServeStream(l)
+		}()
+	}
+	return <-errs
+}
@@ -0,0 +1,115 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"log/slog"
+	"net"
+	"sync/atomic"
+)
+
+//DefaultTLSPort is the rfc7350 port for STUN over TLS and DTLS
+const DefaultTLSPort = 5349
+
+//DTLSTransport lets a DTLS implementation be plugged in, since there is none in
+//the standard library. The net.Conns it returns must keep datagram boundaries, each
+//Write is sent as one DTLS record and each Read returns one
+type DTLSTransport interface {
+	//Client does a DTLS handshake with the server over conn
+	Client(conn net.PacketConn, server net.Addr) (net.Conn, error)
+	//Server accepts DTLS sessions from any client on conn
+	Server(conn net.PacketConn) (net.Listener, error)
+}
+
+//DialDTLS does a DTLS handshake with the server on conn and returns a StunClient
+//that sends its requests over the DTLS session
+func DialDTLS(dt DTLSTransport, conn net.PacketConn, server net.Addr) (*StunClient, error) {
+	c, err := dt.Client(conn, server)
+	if err != nil {
+		return nil, err
+	}
+	return NewStunClient(NewConnPacketConn(c)), nil
+}
+
+type connPacketConn struct {
+	net.Conn
+}
+
+//NewConnPacketConn makes a connected net.Conn that keeps datagram boundaries, like
+//a DTLS session or a connected UDP socket, usable as a net.PacketConn. ReadFrom
+//always returns the remote address and WriteTo ignores the address it is given
+func NewConnPacketConn(c net.Conn) net.PacketConn {
+	return &connPacketConn{Conn: c}
+}
+
+func (cpc *connPacketConn) ReadFrom(ba []byte) (int, net.Addr, error) {
+	n, err := cpc.Conn.Read(ba)
+	return n, cpc.Conn.RemoteAddr(), err
+}
+
+func (cpc *connPacketConn) WriteTo(ba []byte, addr net.Addr) (int, error) {
+	return cpc.Conn.Write(ba)
+}
+
+type streamPacketConn struct {
+	net.Conn
+}
+
+//NewStreamPacketConn makes a stream net.Conn, like TCP or TLS, usable as a
+//net.PacketConn. Each ReadFrom returns one STUN message using the length in its
+//header for framing, and always the remote address
+func NewStreamPacketConn(c net.Conn) net.PacketConn {
+	return &streamPacketConn{Conn: c}
+}
+
+func (spc *streamPacketConn) ReadFrom(ba []byte) (int, net.Addr, error) {
+	frame, err := readFrame(spc.Conn)
+	if err != nil {
+		return 0, spc.Conn.RemoteAddr(), err
+	}
+	return copy(ba, frame), spc.Conn.RemoteAddr(), nil
+}
+
+func (spc *streamPacketConn) WriteTo(ba []byte, addr net.Addr) (int, error) {
+	return spc.Conn.Write(ba)
+}
+
+//ServeDTLS accepts DTLS sessions from a net.Listener made by a DTLSTransport and
+//answers the StunPackets sent on them until Accept fails. Unlike ServeStream
+//each DTLS record is one StunPacket, so no framing is used
+func (ss *StunServer) ServeDTLS(l net.Listener) error {
+	if ss.err != nil {
+		return ss.err
+	}
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go ss.serveDatagramConn(c)
+	}
+}
+
+func (ss *StunServer) serveDatagramConn(c net.Conn) {
+	defer c.Close()
+	ba := make([]byte, 65536)
+	for {
+		c.SetReadDeadline(ss.idleDeadline())
+		n, err := c.Read(ba)
+		if err != nil {
+			return
+		}
+		atomic.AddUint64(&ss.stats.Received, 1)
+		sp, err := NewStunPacket(ba[:n])
+		if err != nil {
+			atomic.AddUint64(&ss.stats.Invalid, 1)
+			ss.hooks.OnError(err, c.RemoteAddr())
+			logPacket(ss.logger, slog.LevelDebug, "Dropped invalid packet", nil, c.RemoteAddr(), errAttr(err))
+			continue
+		}
+		resp := ss.HandlePacket(sp, c.RemoteAddr())
+		if resp != nil {
+			if _, err := c.Write(resp.GetBytes()); err != nil {
+				return
+			}
+		}
+	}
+}
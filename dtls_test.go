@@ -0,0 +1,259 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//fakeDTLS stands in for a real DTLS implementation. It does a one message
+//handshake and sends records with a DTLS application data header and the
+//payload xor'd with a key, so nothing gets through without it
+type fakeDTLS struct {
+	key byte
+}
+
+var fakeHello = []byte{22, 0xfe, 0xfd, 'h', 'i'}
+
+func (fd *fakeDTLS) seal(ba []byte) []byte {
+	out := append([]byte{23, 0xfe, 0xfd}, ba...)
+	for i := 3; i < len(out); i++ {
+		out[i] ^= fd.key
+	}
+	return out
+}
+
+func (fd *fakeDTLS) open(ba []byte) ([]byte, error) {
+	if len(ba) < 3 || ClassifyPacket(ba) != PacketDTLS || ba[0] != 23 {
+		return nil, errors.New("not a record")
+	}
+	out := append([]byte(nil), ba[3:]...)
+	for i := range out {
+		out[i] ^= fd.key
+	}
+	return out, nil
+}
+
+type fakeDTLSConn struct {
+	fd       *fakeDTLS
+	conn     net.PacketConn
+	remote   net.Addr
+	in       chan []byte
+	done     chan struct{}
+	once     sync.Once
+	lock     sync.Mutex
+	deadline time.Time
+	//moved is closed when the deadline changes, to wake a blocked Read
+	moved chan struct{}
+}
+
+func (c *fakeDTLSConn) Read(ba []byte) (int, error) {
+	for {
+		c.lock.Lock()
+		deadline, moved := c.deadline, c.moved
+		c.lock.Unlock()
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			expired = time.After(time.Until(deadline))
+		}
+		select {
+		case rec := <-c.in:
+			return copy(ba, rec), nil
+		case <-c.done:
+			return 0, net.ErrClosed
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		case <-moved:
+		}
+	}
+}
+
+func (c *fakeDTLSConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.deadline = t
+	close(c.moved)
+	c.moved = make(chan struct{})
+	return nil
+}
+
+func (c *fakeDTLSConn) Write(ba []byte) (int, error) {
+	_, err := c.conn.WriteTo(c.fd.seal(ba), c.remote)
+	return len(ba), err
+}
+
+func (c *fakeDTLSConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *fakeDTLSConn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *fakeDTLSConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *fakeDTLSConn) SetDeadline(t time.Time) error      { return c.SetReadDeadline(t) }
+func (c *fakeDTLSConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (fd *fakeDTLS) newConn(conn net.PacketConn, remote net.Addr) *fakeDTLSConn {
+	return &fakeDTLSConn{fd: fd, conn: conn, remote: remote, in: make(chan []byte, 16), done: make(chan struct{}), moved: make(chan struct{})}
+}
+
+func (fd *fakeDTLS) Client(conn net.PacketConn, server net.Addr) (net.Conn, error) {
+	conn.WriteTo(fakeHello, server)
+	ba := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(ba)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil || !bytes.Equal(ba[:n], fakeHello) {
+		return nil, errors.New("handshake failed")
+	}
+	c := fd.newConn(conn, server)
+	go func() {
+		ba := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(ba)
+			if err != nil {
+				c.Close()
+				return
+			}
+			if rec, err := fd.open(ba[:n]); err == nil {
+				c.in <- rec
+			}
+		}
+	}()
+	return c, nil
+}
+
+type fakeDTLSListener struct {
+	conn   net.PacketConn
+	accept chan net.Conn
+}
+
+func (l *fakeDTLSListener) Accept() (net.Conn, error) {
+	c, ok := <-l.accept
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return c, nil
+}
+
+func (l *fakeDTLSListener) Close() error   { return l.conn.Close() }
+func (l *fakeDTLSListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+func (fd *fakeDTLS) Server(conn net.PacketConn) (net.Listener, error) {
+	l := &fakeDTLSListener{conn: conn, accept: make(chan net.Conn, 16)}
+	go func() {
+		defer close(l.accept)
+		sessions := make(map[string]*fakeDTLSConn)
+		ba := make([]byte, 65536)
+		for {
+			n, addr, err := conn.ReadFrom(ba)
+			if err != nil {
+				for _, c := range sessions {
+					c.Close()
+				}
+				return
+			}
+			if bytes.Equal(ba[:n], fakeHello) {
+				conn.WriteTo(fakeHello, addr)
+				c := fd.newConn(conn, addr)
+				sessions[addr.String()] = c
+				l.accept <- c
+				continue
+			}
+			c, ok := sessions[addr.String()]
+			if !ok {
+				continue
+			}
+			if rec, err := fd.open(ba[:n]); err == nil {
+				c.in <- rec
+			}
+		}
+	}()
+	return l, nil
+}
+
+func TestStunOverDTLS(t *testing.T) {
+	fd := &fakeDTLS{key: 0x5a}
+	sconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	l, err := fd.Server(sconn)
+	assert.NoError(t, err)
+	defer l.Close()
+	go NewStunServer(nil).SetSoftware("dtls").ServeDTLS(l)
+
+	cconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer cconn.Close()
+	sc, err := DialDTLS(fd, cconn, sconn.LocalAddr())
+	assert.NoError(t, err)
+	br, err := sc.SetRTO(50 * time.Millisecond).Bind(sconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), br.Address.String())
+	s, _ := br.Response.GetSoftware()
+	assert.Equal(t, "dtls", s)
+
+	//a plain request on the DTLS port is not answered
+	plain, _ := newClient(t)
+	plain.SetRTO(5 * time.Millisecond).SetRetransmits(0)
+	_, err = plain.Bind(sconn.LocalAddr())
+	assert.Equal(t, ErrTimeout, err)
+}
+
+func TestConnPacketConn(t *testing.T) {
+	_, sconn := startServer(t)
+	defer sconn.Close()
+	c, err := net.DialUDP("udp4", nil, sconn.LocalAddr().(*net.UDPAddr))
+	assert.NoError(t, err)
+	defer c.Close()
+	br, err := NewStunClient(NewConnPacketConn(c)).Bind(sconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, c.LocalAddr().String(), br.Address.String())
+}
+
+//acceptedListener passes on the conns it accepts so a test can look at them
+type acceptedListener struct {
+	net.Listener
+	accepted chan net.Conn
+}
+
+func (al *acceptedListener) Accept() (net.Conn, error) {
+	c, err := al.Listener.Accept()
+	if err == nil {
+		al.accepted <- c
+	}
+	return c, err
+}
+
+func TestDTLSTimeouts(t *testing.T) {
+	fd := &fakeDTLS{key: 0x5a}
+	sconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	dl, err := fd.Server(sconn)
+	assert.NoError(t, err)
+	l := &acceptedListener{Listener: dl, accepted: make(chan net.Conn, 1)}
+	defer l.Close()
+
+	//the session is set up but nothing answers on it yet
+	cconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer cconn.Close()
+	sc, err := DialDTLS(fd, cconn, sconn.LocalAddr())
+	assert.NoError(t, err)
+	sc.SetRTO(10 * time.Millisecond).SetRetransmits(1)
+	_, err = sc.Bind(sconn.LocalAddr())
+	assert.Equal(t, ErrTimeout, err)
+
+	//the server closes a session that goes quiet
+	go NewStunServer(nil).SetIdleTimeout(50 * time.Millisecond).ServeDTLS(l)
+	c := (<-l.accepted).(*fakeDTLSConn)
+	select {
+	case <-c.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle DTLS session was not closed")
+	}
+}
@@ -0,0 +1,228 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var methodNames = map[StunMethod]string{
+	MethodBinding:           "Binding",
+	MethodAllocate:          "Allocate",
+	MethodRefresh:           "Refresh",
+	MethodSend:              "Send",
+	MethodData:              "Data",
+	MethodCreatePermission:  "CreatePermission",
+	MethodChannelBind:       "ChannelBind",
+	MethodConnect:           "Connect",
+	MethodConnectionBind:    "ConnectionBind",
+	MethodConnectionAttempt: "ConnectionAttempt",
+}
+
+var classNames = []string{"Request", "Indication", "Success Response", "Error Response"}
+
+//String returns the method and class of this StunMessage, ie "Binding Success Response"
+func (sm StunMessage) String() string {
+	method := sm.Method()
+	c := uint16(sm.Class())
+	class := (c >> 7) | ((c & 0x0010) >> 4)
+	name, ok := methodNames[method]
+	if !ok {
+		name = fmt.Sprintf("0x%03X", method)
+	}
+	return name + " " + classNames[class]
+}
+
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return fmt.Sprintf("%X", val)
+	case string:
+		return fmt.Sprintf("%q", val)
+	}
+	return fmt.Sprint(v)
+}
+
+//String returns a multi-line dump of this StunPacket, see Dump
+func (sp *StunPacket) String() string {
+	return sp.Dump(nil)
+}
+
+//Dump returns a multi-line, human readable dump of this StunPacket with every
+//attribute decoded. The key is used to check the SAMessageIntegrity, nil skips it
+func (sp *StunPacket) Dump(key []byte) string {
+	if sp == nil || len(sp.buffer) < 20 {
+		//a nil or zero StunPacket has no header to dump
+		return "STUN <empty>\n"
+	}
+	var sb strings.Builder
+	mt := sp.GetStunMessageType()
+	fmt.Fprintf(&sb, "STUN %s\n", mt)
+	fmt.Fprintf(&sb, "  Message Type: 0x%04X (%s)\n", uint16(mt), mt)
+	fmt.Fprintf(&sb, "  Message Length: %d\n", binary.BigEndian.Uint16(sp.buffer[2:4]))
+	fmt.Fprintf(&sb, "  Message Cookie: %08X\n", binary.BigEndian.Uint32(sp.buffer[4:8]))
+	fmt.Fprintf(&sb, "  Message Transaction ID: %s\n", sp.GetTxID())
+	attrs, err := sp.Decode()
+	fmt.Fprintf(&sb, "  Attributes: %d\n", len(attrs))
+	for i, a := range attrs {
+		switch a.Type {
+		case SAFingerPrint:
+			status := "invalid"
+			if i == len(attrs)-1 && VerifyFingerPrint(*sp) {
+				status = "valid"
+			}
+			fmt.Fprintf(&sb, "    %s: %X (%s)\n", a.Name(), a.Raw, status)
+		case SAMessageIntegrity:
+			status := "unchecked"
+			if key != nil {
+				status = "invalid"
+				if sp.VerifyMessageIntegrity(key) {
+					status = "valid"
+				}
+			}
+			fmt.Fprintf(&sb, "    %s: %X (%s)\n", a.Name(), a.Raw, status)
+		default:
+			fmt.Fprintf(&sb, "    %s\n", a)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(&sb, "  Error: %s\n", err)
+	}
+	return sb.String()
+}
+
+type jsonAttribute struct {
+	Type    string `json:"type,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Raw     string `json:"raw"`
+	Padding string `json:"padding,omitempty"`
+}
+
+type jsonPacket struct {
+	Type          string          `json:"type"`
+	Name          string          `json:"name,omitempty"`
+	Length        int             `json:"length"`
+	TransactionID string          `json:"transactionId"`
+	Attributes    []jsonAttribute `json:"attributes"`
+}
+
+//MarshalJSON writes this StunPacket as JSON with each attribute decoded.
+//The raw bytes and padding are kept so UnmarshalJSON can recreate the exact packet
+func (sp *StunPacket) MarshalJSON() ([]byte, error) {
+	if len(sp.buffer) < 20 {
+		return nil, ErrNotStunPacket
+	}
+	mt := sp.GetStunMessageType()
+	jp := jsonPacket{
+		Type:          fmt.Sprintf("0x%04X", uint16(mt)),
+		Name:          mt.String(),
+		Length:        len(sp.buffer) - 20,
+		TransactionID: sp.GetTxID().String(),
+		Attributes:    make([]jsonAttribute, 0),
+	}
+	attrs, _ := sp.Decode()
+	pos := 20
+	for _, a := range attrs {
+		ja := jsonAttribute{
+			Type: fmt.Sprintf("0x%04X", uint16(a.Type)),
+			Name: a.Name(),
+			Raw:  fmt.Sprintf("%X", a.Raw),
+		}
+		if s, ok := a.Value.(string); ok {
+			ja.Value = s
+		} else if _, ok := a.Value.([]byte); !ok {
+			ja.Value = formatValue(a.Value)
+		}
+		pos += 4 + len(a.Raw)
+		end := (pos + 3) & ^3
+		if end > len(sp.buffer) {
+			end = len(sp.buffer)
+		}
+		if pad := sp.buffer[pos:end]; !bytes.Equal(pad, make([]byte, len(pad))) {
+			ja.Padding = fmt.Sprintf("%X", pad)
+		}
+		pos = end
+		jp.Attributes = append(jp.Attributes, ja)
+	}
+	return json.Marshal(jp)
+}
+
+//UnmarshalJSON recreates a StunPacket from the JSON made by MarshalJSON.
+//Only type, transactionId and each attributes raw are required, an attribute
+//type can also be given by its registered name
+func (sp *StunPacket) UnmarshalJSON(ba []byte) error {
+	jp := jsonPacket{}
+	if err := json.Unmarshal(ba, &jp); err != nil {
+		return err
+	}
+	mt, err := strconv.ParseUint(jp.Type, 0, 16)
+	if err != nil {
+		return err
+	}
+	tid, err := hex.DecodeString(jp.TransactionID)
+	if err != nil {
+		return err
+	}
+	if len(tid) != 12 {
+		return errors.New("Invalid TransactionID!")
+	}
+	buf := make([]byte, 20)
+	binary.BigEndian.PutUint16(buf[:2], uint16(mt))
+	binary.BigEndian.PutUint32(buf[4:8], stunMagic)
+	copy(buf[8:20], tid)
+	for _, ja := range jp.Attributes {
+		sa, err := parseAttributeType(ja.Type, ja.Name)
+		if err != nil {
+			return err
+		}
+		raw, err := hex.DecodeString(ja.Raw)
+		if err != nil {
+			return err
+		}
+		pad, err := hex.DecodeString(ja.Padding)
+		if err != nil {
+			return err
+		}
+		ab := make([]byte, 4, 4+len(raw)+3)
+		binary.BigEndian.PutUint16(ab[:2], uint16(sa))
+		binary.BigEndian.PutUint16(ab[2:4], uint16(len(raw)))
+		ab = append(ab, raw...)
+		for i := 0; len(ab)&3 != 0; i++ {
+			if i < len(pad) {
+				ab = append(ab, pad[i])
+			} else {
+				ab = append(ab, 0)
+			}
+		}
+		buf = append(buf, ab...)
+	}
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)-20))
+	if !IsStunPacket(buf) {
+		return ErrNotStunPacket
+	}
+	sp.buffer = buf
+	return nil
+}
+
+func parseAttributeType(t string, name string) (StunAttribute, error) {
+	if t != "" {
+		v, err := strconv.ParseUint(t, 0, 16)
+		return StunAttribute(v), err
+	}
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	for sa, codec := range codecs {
+		if codec.Name() == name {
+			return sa, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown Attribute %q!", name)
+}
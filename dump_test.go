@@ -0,0 +1,74 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStunMessageString(t *testing.T) {
+	assert.Equal(t, "Binding Request", SMRequest.String())
+	assert.Equal(t, "Binding Success Response", SMSuccess.String())
+	assert.Equal(t, "Binding Error Response", SMFailure.String())
+	assert.Equal(t, "Binding Indication", SMIndication.String())
+	assert.Equal(t, "0x002 Request", StunMessage(0x0002).String())
+}
+
+func TestDump(t *testing.T) {
+	ba, _ := hex.DecodeString(SPRESP1)
+	sp, _ := NewStunPacket(ba)
+	key, _ := ShortTermKey("VOkJxbRl1RmTxUk/WvJxBt")
+	expected := `STUN Binding Success Response
+  Message Type: 0x0101 (Binding Success Response)
+  Message Length: 60
+  Message Cookie: 2112A442
+  Message Transaction ID: B7E7A701BC34D686FA87DFAE
+  Attributes: 4
+    SOFTWARE: "test vector"
+    XOR-MAPPED-ADDRESS: 192.0.2.1:32853
+    MESSAGE-INTEGRITY: 2B91F599FD9E90C38C7489F92AF9BA53F06BE7D7 (valid)
+    FINGERPRINT: C07D4C96 (valid)
+`
+	assert.Equal(t, expected, sp.Dump(key))
+	assert.Contains(t, sp.String(), "MESSAGE-INTEGRITY: 2B91F599FD9E90C38C7489F92AF9BA53F06BE7D7 (unchecked)")
+
+	assert.Equal(t, "STUN <empty>\n", (&StunPacket{}).String())
+	var nilPacket *StunPacket
+	assert.Equal(t, "STUN <empty>\n", nilPacket.String())
+	_, err := json.Marshal(&StunPacket{})
+	assert.Error(t, err)
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, v := range []string{SPREQ1, SPRESP1} {
+		ba, _ := hex.DecodeString(v)
+		sp, _ := NewStunPacket(ba)
+		js, err := json.Marshal(sp)
+		assert.NoError(t, err)
+		sp2 := &StunPacket{}
+		assert.NoError(t, json.Unmarshal(js, sp2))
+		assert.Equal(t, sp.GetBytes(), sp2.GetBytes())
+		assert.True(t, VerifyFingerPrint(*sp2))
+	}
+}
+
+func TestJSONFixture(t *testing.T) {
+	fixture := `{
+		"type": "0x0101",
+		"transactionId": "B7E7A701BC34D686FA87DFAE",
+		"attributes": [
+			{"name": "SOFTWARE", "raw": "74657374"},
+			{"type": "0x0020", "raw": "0001A147E112A643"}
+		]
+	}`
+	sp := &StunPacket{}
+	assert.NoError(t, json.Unmarshal([]byte(fixture), sp))
+	assert.Equal(t, SMSuccess, sp.GetStunMessageType())
+	s, _ := sp.GetSoftware()
+	assert.Equal(t, "test", s)
+	a, _ := sp.GetAddress()
+	assert.Equal(t, "192.0.2.1:32853", a.String())
+	assert.Error(t, json.Unmarshal([]byte(`{"type":"0x0101","transactionId":"00","attributes":[]}`), sp))
+}
@@ -39,8 +39,9 @@ func UDPRead(conn *net.UDPConn) {
 		ma, err := sp2.GetAddress()
 		checkError(err)
 		count++
-		for _, v := range sp2.GetAllAttributes() {
-			fmt.Printf("%X\n", v)
+		attrs, _ := sp2.Decode()
+		for _, v := range attrs {
+			fmt.Println(v)
 		}
 		fmt.Printf("%s\n", sp2.GetTxID().String())
 		fmt.Printf("%X\n", sp2.GetStunMessageType())
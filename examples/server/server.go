@@ -58,7 +58,7 @@ func UDPRead(conn *net.UDPConn, writer chan func()) {
 
 func SendResp(conn *net.UDPConn, uaddr *net.UDPAddr, sp *stunlib.StunPacket) {
 	spb := sp.ToBuilder()
-	spb.ClearAttribues()
+	spb.ClearAttributes()
 	spb.SetXORAddress(uaddr)
 	conn.WriteToUDP(spb.Build().GetBytes(), uaddr)
 }
@@ -0,0 +1,141 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrNoFingerPrint       = errors.New("FingerPrint Not found!")
+	ErrFingerPrintNotLast  = errors.New("FingerPrint is not the last Attribute!")
+	ErrFingerPrintLength   = errors.New("Invalid FingerPrint Length!")
+	ErrFingerPrintMismatch = errors.New("FingerPrint does not match!")
+	ErrFingerPrintRequired = errors.New("FingerPrint is Required!")
+)
+
+//FingerPrintPolicy is how a StunServer checks the SAFingerPrint of requests
+type FingerPrintPolicy int
+
+const (
+	//FingerPrintVerify drops requests with an invalid SAFingerPrint, requests without one are still answered
+	FingerPrintVerify FingerPrintPolicy = iota
+	//FingerPrintRequire drops requests without a valid SAFingerPrint
+	FingerPrintRequire
+	//FingerPrintIgnore never checks the SAFingerPrint
+	FingerPrintIgnore
+)
+
+//CheckFingerPrint verifies the SAFingerPrint of this StunPacket, returning
+//ErrNoFingerPrint, ErrFingerPrintNotLast, ErrFingerPrintLength or
+//ErrFingerPrintMismatch to say what is wrong with it
+func (sp *StunPacket) CheckFingerPrint() error {
+	return checkFingerPrint(sp.buffer)
+}
+
+func checkFingerPrint(ba []byte) error {
+	if err := checkAttributes(ba); err != nil {
+		return err
+	}
+	found, last := -1, -1
+	pos := 20
+	for pos+4 <= len(ba) {
+		sa := StunAttribute(binary.BigEndian.Uint16(ba[pos : pos+2]))
+		s := int(binary.BigEndian.Uint16(ba[pos+2 : pos+4]))
+		if sa == SAFingerPrint && found < 0 {
+			found = pos
+		}
+		last = pos
+		pos = (pos + s + 4 + 3) & ^3
+	}
+	if found < 0 {
+		return ErrNoFingerPrint
+	}
+	if found != last {
+		return ErrFingerPrintNotLast
+	}
+	if binary.BigEndian.Uint16(ba[found+2:found+4]) != 4 || found+8 != len(ba) {
+		return ErrFingerPrintLength
+	}
+	if CreateStunFingerPrint(ba[:found]) != binary.BigEndian.Uint32(ba[found+4:found+8]) {
+		return ErrFingerPrintMismatch
+	}
+	return nil
+}
+
+//checkRequest applies the FingerPrintPolicy to a request
+func (fpp FingerPrintPolicy) checkRequest(sp *StunPacket) error {
+	if fpp == FingerPrintIgnore {
+		return nil
+	}
+	//CheckFingerPrint checks the attribute framing, so a malformed packet is
+	//dropped with ErrInvalidAttributeSize under every other policy
+	err := sp.CheckFingerPrint()
+	if err == ErrNoFingerPrint {
+		if fpp == FingerPrintVerify {
+			return nil
+		}
+		return ErrFingerPrintRequired
+	}
+	return err
+}
+
+//PacketClass is what kind of packet was received on a socket shared by several protocols
+type PacketClass int
+
+const (
+	PacketUnknown PacketClass = iota
+	PacketStun
+	PacketZRTP
+	PacketDTLS
+	PacketTURNChannel
+	PacketRTP
+)
+
+func (pc PacketClass) String() string {
+	switch pc {
+	case PacketStun:
+		return "STUN"
+	case PacketZRTP:
+		return "ZRTP"
+	case PacketDTLS:
+		return "DTLS"
+	case PacketTURNChannel:
+		return "TURN Channel"
+	case PacketRTP:
+		return "RTP/RTCP"
+	}
+	return "Unknown"
+}
+
+//ClassifyPacket sorts a packet by its first byte using the rfc7983 ranges, for
+//sockets shared by STUN, DTLS, RTP and TURN channels. A PacketStun still needs
+//IsStunMessage to be sure it is really STUN
+func ClassifyPacket(ba []byte) PacketClass {
+	if len(ba) == 0 {
+		return PacketUnknown
+	}
+	switch b := ba[0]; {
+	case b <= 3:
+		return PacketStun
+	case b >= 16 && b <= 19:
+		return PacketZRTP
+	case b >= 20 && b <= 63:
+		return PacketDTLS
+	case b >= 64 && b <= 79:
+		return PacketTURNChannel
+	case b >= 128 && b <= 191:
+		return PacketRTP
+	}
+	return PacketUnknown
+}
+
+//IsStunMessage is IsStunPacket that also requires the length to be a multiple of 4,
+//as rfc5389 does. When requireFingerPrint is set there must also be a valid
+//SAFingerPrint, which is how rfc5389 says to tell STUN apart from other protocols
+//on the same socket
+func IsStunMessage(ba []byte, requireFingerPrint bool) bool {
+	if !IsStunPacket(ba) || len(ba)&3 != 0 {
+		return false
+	}
+	return !requireFingerPrint || checkFingerPrint(ba) == nil
+}
@@ -0,0 +1,114 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckFingerPrint(t *testing.T) {
+	ba, _ := hex.DecodeString(SPREQ1)
+	sp, _ := NewStunPacket(ba)
+	assert.NoError(t, sp.CheckFingerPrint())
+	assert.True(t, VerifyFingerPrint(*sp))
+
+	sp = NewStunPacketBuilder().Build()
+	assert.Equal(t, ErrNoFingerPrint, sp.CheckFingerPrint())
+	assert.False(t, VerifyFingerPrint(*sp))
+
+	sp = NewStunPacketBuilder().AddFingerprint(true).Build()
+	bad := append([]byte(nil), sp.GetBytes()...)
+	bad[len(bad)-1] ^= 0xff
+	sp, _ = NewStunPacket(bad)
+	assert.Equal(t, ErrFingerPrintMismatch, sp.CheckFingerPrint())
+
+	//the builder always makes a correct FingerPrint, so one 8 bytes long is made by hand
+	long := NewStunPacketBuilder().SetAttribue(SAPadding, []byte{1, 2, 3, 4, 5, 6, 7, 8}).Build().GetBytes()
+	binary.BigEndian.PutUint16(long[20:22], uint16(SAFingerPrint))
+	sp, _ = NewStunPacket(long)
+	assert.Equal(t, ErrFingerPrintLength, sp.CheckFingerPrint())
+
+	sp = NewStunPacketBuilder().AddFingerprint(true).Build()
+	notLast := append(append([]byte(nil), sp.GetBytes()...), 0x80, 0x22, 0, 0)
+	binary.BigEndian.PutUint16(notLast[2:4], uint16(len(notLast)-20))
+	sp, _ = NewStunPacket(notLast)
+	assert.Equal(t, ErrFingerPrintNotLast, sp.CheckFingerPrint())
+
+	short := NewStunPacketBuilder().Build().GetBytes()
+	short = append(short, 0x80, 0x28, 0, 100)
+	binary.BigEndian.PutUint16(short[2:4], 4)
+	_, err := NewStunPacket(short)
+	assert.Equal(t, ErrInvalidAttributeSize, err)
+	sp = &StunPacket{buffer: short}
+	assert.Equal(t, ErrInvalidAttributeSize, sp.CheckFingerPrint())
+	assert.False(t, VerifyFingerPrint(*sp))
+}
+
+func TestServerFingerPrintPolicy(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+	plain := NewStunPacketBuilder().Build()
+	good := NewStunPacketBuilder().AddFingerprint(true).Build()
+	badBytes := append([]byte(nil), good.GetBytes()...)
+	badBytes[len(badBytes)-1] ^= 0xff
+	bad, _ := NewStunPacket(badBytes)
+
+	ss := NewStunServer(nil)
+	assert.NotNil(t, ss.HandlePacket(plain, addr))
+	resp := ss.HandlePacket(good, addr)
+	assert.True(t, VerifyFingerPrint(*resp))
+	assert.Nil(t, ss.HandlePacket(bad, addr))
+	assert.Equal(t, uint64(1), ss.Stats().Invalid)
+
+	ss.SetFingerPrintPolicy(FingerPrintRequire)
+	assert.Nil(t, ss.HandlePacket(plain, addr))
+	assert.Nil(t, ss.HandlePacket(bad, addr))
+	assert.NotNil(t, ss.HandlePacket(good, addr))
+
+	ss.SetFingerPrintPolicy(FingerPrintIgnore)
+	assert.NotNil(t, ss.HandlePacket(plain, addr))
+	assert.NotNil(t, ss.HandlePacket(bad, addr))
+	assert.Equal(t, uint64(3), ss.Stats().Invalid)
+}
+
+func TestServerFingerPrintMalformed(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+	//22 bytes, a cut off attribute header that NewStunPacket would not allow
+	short := append(NewStunPacketBuilder().Build().GetBytes(), 0x80, 0x28)
+	binary.BigEndian.PutUint16(short[2:4], 2)
+	ss := NewStunServer(nil)
+	assert.Nil(t, ss.HandlePacket(&StunPacket{buffer: short}, addr))
+	ss.SetFingerPrintPolicy(FingerPrintRequire)
+	assert.Nil(t, ss.HandlePacket(&StunPacket{buffer: short}, addr))
+	assert.Equal(t, uint64(2), ss.Stats().Invalid)
+}
+
+func TestMultiplexing(t *testing.T) {
+	//an Allocate request
+	stun := NewStunPacketBuilder().AddFingerprint(true).Build().GetBytes()
+	binary.BigEndian.PutUint16(stun[0:2], 0x0003)
+	binary.BigEndian.PutUint32(stun[len(stun)-4:], CreateStunFingerPrint(stun[:len(stun)-8]))
+	assert.Equal(t, PacketStun, ClassifyPacket(stun))
+	assert.True(t, IsStunMessage(stun, true))
+	assert.True(t, IsStunMessage(NewStunPacketBuilder().Build().GetBytes(), false))
+	assert.False(t, IsStunMessage(NewStunPacketBuilder().Build().GetBytes(), true))
+	odd := append(append([]byte(nil), stun...), 1)
+	assert.False(t, IsStunMessage(odd, false))
+	assert.False(t, IsStunPacket(odd))
+	//an unpadded attribute can be read, but is not an rfc5389 message
+	unpadded := NewStunPacketBuilder().SetAttribue(SASoftware, []byte("a")).Build().GetBytes()[:25]
+	binary.BigEndian.PutUint16(unpadded[2:4], 5)
+	assert.True(t, IsStunPacket(unpadded))
+	assert.False(t, IsStunMessage(unpadded, false))
+
+	assert.Equal(t, PacketDTLS, ClassifyPacket([]byte{22, 0xfe, 0xfd}))
+	assert.Equal(t, PacketRTP, ClassifyPacket([]byte{0x80, 0x00}))
+	assert.Equal(t, PacketTURNChannel, ClassifyPacket([]byte{0x40, 0x00}))
+	assert.Equal(t, PacketZRTP, ClassifyPacket([]byte{0x10}))
+	assert.Equal(t, PacketUnknown, ClassifyPacket([]byte{200}))
+	assert.Equal(t, PacketUnknown, ClassifyPacket(nil))
+	assert.Equal(t, "DTLS", PacketDTLS.String())
+	assert.False(t, IsStunMessage([]byte{0x80, 0x00}, false))
+}
@@ -0,0 +1,31 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"net"
+	"time"
+)
+
+//Hooks are called by a StunClient or StunServer as it handles packets, they
+//must be safe to call from multiple goroutines
+type Hooks interface {
+	//OnRequest is called when a client sends a new request or a server receives one
+	OnRequest(sp *StunPacket, addr net.Addr)
+	//OnResponse is called when a client gets a response, with the RTT, or when a
+	//server sends one, with how long it took to process the request
+	OnResponse(sp *StunPacket, addr net.Addr, d time.Duration)
+	//OnError is called when a packet is dropped or a transaction fails
+	OnError(err error, addr net.Addr)
+	//OnRetransmit is called each time a client resends a request
+	OnRetransmit(sp *StunPacket, addr net.Addr, attempt int)
+	//OnTimeout is called when a client gives up waiting for a response
+	OnTimeout(sp *StunPacket, addr net.Addr)
+}
+
+//NoopHooks does nothing, it can be embedded to only implement some of the Hooks
+type NoopHooks struct{}
+
+func (NoopHooks) OnRequest(sp *StunPacket, addr net.Addr)                   {}
+func (NoopHooks) OnResponse(sp *StunPacket, addr net.Addr, d time.Duration) {}
+func (NoopHooks) OnError(err error, addr net.Addr)                          {}
+func (NoopHooks) OnRetransmit(sp *StunPacket, addr net.Addr, attempt int)   {}
+func (NoopHooks) OnTimeout(sp *StunPacket, addr net.Addr)                   {}
@@ -0,0 +1,62 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+)
+
+//CreateMessageIntegrity makes the HMAC-SHA1 for an SAMessageIntegrity.
+//Like CreateStunFingerPrint the []byte must stop right before the attribute
+//and its header length must already include the 24 bytes of the attribute
+func CreateMessageIntegrity(sp []byte, key []byte) []byte {
+	mac := hmac.New(sha1.New, key)
+	mac.Write(sp)
+	return mac.Sum(nil)
+}
+
+//ShortTermKey makes the MessageIntegrity key for short-term credentials
+func ShortTermKey(password string) ([]byte, error) {
+	p, err := PreparePassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(p), nil
+}
+
+//LongTermKey makes the MessageIntegrity key for long-term credentials,
+//MD5(username ":" realm ":" PreparePassword(password))
+func LongTermKey(username string, realm string, password string) ([]byte, error) {
+	p, err := PreparePassword(password)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + p))
+	return sum[:], nil
+}
+
+//HasMessageIntegrity returns true if this StunPacket has an SAMessageIntegrity
+func (sp *StunPacket) HasMessageIntegrity() bool {
+	return sp.GetAttribute(SAMessageIntegrity) != nil
+}
+
+//VerifyMessageIntegrity checks the SAMessageIntegrity in this StunPacket with the key
+func (sp *StunPacket) VerifyMessageIntegrity(key []byte) bool {
+	pos := 20
+	for pos+4 <= len(sp.buffer) {
+		t := StunAttribute(binary.BigEndian.Uint16(sp.buffer[pos : pos+2]))
+		s := int(binary.BigEndian.Uint16(sp.buffer[pos+2 : pos+4]))
+		if t == SAMessageIntegrity {
+			if s != 20 || pos+24 > len(sp.buffer) {
+				return false
+			}
+			ba := make([]byte, pos)
+			copy(ba, sp.buffer[:pos])
+			binary.BigEndian.PutUint16(ba[2:4], uint16(pos+24-20))
+			return hmac.Equal(CreateMessageIntegrity(ba, key), sp.buffer[pos+4:pos+24])
+		}
+		pos = ((pos + s + 4 + 3) & ^3)
+	}
+	return false
+}
@@ -0,0 +1,353 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//DefaultUDPKeepalive and DefaultStreamKeepalive are the rfc5626 keepalive
+	//intervals when the registrar does not send a Flow-Timer
+	DefaultUDPKeepalive    = 30 * time.Second
+	DefaultStreamKeepalive = 120 * time.Second
+	//DefaultKeepaliveTimeout is how long a stream keepalive waits for its pong
+	DefaultKeepaliveTimeout = 10 * time.Second
+)
+
+var ErrKeepaliveStopped = errors.New("Keepalive Stopped!")
+
+var (
+	crlfPing = []byte("\r\n\r\n")
+	crlfPong = []byte("\r\n")
+)
+
+type keepaliveMode int
+
+const (
+	keepaliveUDP keepaliveMode = iota
+	keepaliveStreamStun
+	keepaliveCRLF
+)
+
+//Keepalive sends rfc5626 SIP Outbound keepalives on an existing flow. UDP flows and
+//optionally stream flows use stun Binding requests, so the reflexive address can be
+//watched for NAT rebinding, other stream flows use CRLF pings. The Keepalive does not
+//read from the flow, whatever reads it must pass packets to Receive, or read a stream
+//through Reader
+type Keepalive struct {
+	mode     keepaliveMode
+	sc       *StunClient
+	tm       *TransactionManager
+	server   net.Addr
+	w        io.Writer
+	interval time.Duration
+	timeout  time.Duration
+	onChange func(old *net.UDPAddr, current *net.UDPAddr)
+	lock     sync.Mutex
+	pong     chan struct{}
+	last     *net.UDPAddr
+	ctx      context.Context
+	stop     context.CancelFunc
+}
+
+func newKeepalive(mode keepaliveMode, interval time.Duration, conn net.PacketConn) *Keepalive {
+	k := &Keepalive{
+		mode:     mode,
+		interval: interval,
+		timeout:  DefaultKeepaliveTimeout,
+		pong:     make(chan struct{}, 1),
+	}
+	//the TransactionManager only matches responses to requests, Receive feeds
+	//it instead of Serve
+	k.tm = NewTransactionManager(conn)
+	k.ctx, k.stop = context.WithCancel(context.Background())
+	return k
+}
+
+//NewKeepalive creates a Keepalive that sends stun Binding requests to the server on a
+//UDP flow. The requests are retransmitted with the RTO settings of a StunClient
+func NewKeepalive(conn net.PacketConn, server net.Addr) *Keepalive {
+	k := newKeepalive(keepaliveUDP, DefaultUDPKeepalive, conn)
+	k.sc = NewStunClient(conn)
+	k.server = server
+	return k
+}
+
+//NewStreamKeepalive creates a Keepalive for a TCP or TLS flow. When useStun is set
+//stun Binding requests are sent, otherwise a CRLF-CRLF ping is sent and a CRLF
+//pong is expected
+func NewStreamKeepalive(w io.Writer, useStun bool) *Keepalive {
+	mode := keepaliveCRLF
+	if useStun {
+		mode = keepaliveStreamStun
+	}
+	k := newKeepalive(mode, DefaultStreamKeepalive, nil)
+	k.w = w
+	return k
+}
+
+//SetInterval sets how often Run sends a keepalive, each wait is randomly
+//between 80% and 100% of it as rfc5626 asks
+func (k *Keepalive) SetInterval(d time.Duration) *Keepalive {
+	k.interval = d
+	return k
+}
+
+//SetTimeout sets how long a stream keepalive waits for a response
+func (k *Keepalive) SetTimeout(d time.Duration) *Keepalive {
+	k.timeout = d
+	return k
+}
+
+//SetStunClient replaces the StunClient used for UDP keepalives, to change its RTO or Hooks
+func (k *Keepalive) SetStunClient(sc *StunClient) *Keepalive {
+	k.sc = sc
+	return k
+}
+
+//SetOnChange sets the func called when the reflexive address is not the same as
+//the one from the last keepalive, which means the NAT binding changed
+func (k *Keepalive) SetOnChange(onChange func(old *net.UDPAddr, current *net.UDPAddr)) *Keepalive {
+	k.onChange = onChange
+	return k
+}
+
+//Receive must be called with each packet from a UDP flow, or each whole message
+//from a stream flow, that could be a keepalive response. It returns true when it
+//was one and should not be used by anything else. Use Reader for a stream that is
+//not already split into messages
+func (k *Keepalive) Receive(ba []byte) bool {
+	if k.mode == keepaliveCRLF {
+		if !bytes.Equal(ba, crlfPong) {
+			return false
+		}
+		k.receivePong()
+		return true
+	}
+	if !IsStunMessage(ba, false) {
+		return false
+	}
+	sp, err := NewStunPacket(append([]byte(nil), ba...))
+	if err != nil {
+		return false
+	}
+	class := sp.GetStunMessageType().Class()
+	return (class == ClassSuccess || class == ClassFailure) && k.tm.dispatch(sp, nil)
+}
+
+func (k *Keepalive) receivePong() {
+	select {
+	case k.pong <- struct{}{}:
+	default:
+	}
+}
+
+//Reader returns an io.Reader for a TCP or TLS flow that takes the keepalive
+//responses out of r and returns everything else. The stream is split into SIP
+//messages with their Content-Length and rfc5389 framed stun messages, so a
+//response is found however the reads are split or joined. A CRLF pong is only
+//taken between messages, as rfc5626 sends it
+func (k *Keepalive) Reader(r io.Reader) io.Reader {
+	return &keepaliveReader{k: k, br: bufio.NewReader(r)}
+}
+
+//Ping sends one keepalive and waits for its response. The reflexive address is
+//returned for stun keepalives, and nil for CRLF ones
+func (k *Keepalive) Ping() (*net.UDPAddr, error) {
+	if k.mode == keepaliveCRLF {
+		return nil, k.pingCRLF()
+	}
+	spb := NewStunPacketBuilder().SetStunMessage(SMRequest)
+	if k.sc != nil {
+		spb.SetTIDGenerator(k.sc.tidGen)
+	}
+	req := spb.Build()
+	wait := k.tm.expect(k.ctx, req)
+	defer k.tm.forget(req)
+	var resp *StunPacket
+	var err error
+	if k.mode == keepaliveUDP {
+		resp, _, _, err = k.sc.retransmit(k.server, req, wait)
+	} else if _, err = k.w.Write(req.GetBytes()); err == nil {
+		resp, err = wait(time.Now().Add(k.timeout))
+		if resp == nil && err == nil {
+			err = ErrTimeout
+		}
+	}
+	if err == context.Canceled {
+		return nil, ErrKeepaliveStopped
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetStunMessageType() == SMFailure {
+		code, reason, _ := resp.GetErrorCode()
+		return nil, &StunError{Code: code, Reason: reason}
+	}
+	addr, err := resp.GetAddressPreferXOR()
+	if err != nil {
+		return nil, err
+	}
+	k.lock.Lock()
+	old := k.last
+	k.last = addr
+	k.lock.Unlock()
+	if old != nil && !sameUDPAddr(old, addr) && k.onChange != nil {
+		k.onChange(old, addr)
+	}
+	return addr, nil
+}
+
+func (k *Keepalive) pingCRLF() error {
+	select {
+	case <-k.pong:
+	default:
+	}
+	if _, err := k.w.Write(crlfPing); err != nil {
+		return err
+	}
+	timer := time.NewTimer(k.timeout)
+	defer timer.Stop()
+	select {
+	case <-k.pong:
+		return nil
+	case <-timer.C:
+		return ErrTimeout
+	case <-k.ctx.Done():
+		return ErrKeepaliveStopped
+	}
+}
+
+//Run sends a keepalive right away and then every interval until Stop is called,
+//which returns nil, or until a keepalive fails, which returns its error. rfc5626
+//says the flow should be treated as failed at that point
+func (k *Keepalive) Run() error {
+	for {
+		if _, err := k.Ping(); err != nil {
+			if err == ErrKeepaliveStopped {
+				return nil
+			}
+			return err
+		}
+		wait := k.interval - time.Duration(rand.Int63n(int64(k.interval/5)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-k.ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+//Stop makes Run return and any Ping waiting for a response fail
+func (k *Keepalive) Stop() {
+	k.stop()
+}
+
+//keepaliveReader is the io.Reader from Keepalive.Reader. Between messages it
+//looks at the first byte, a CRLF is a pong in CRLF mode and any other CR or LF is
+//passed on, 0x00 to 0x03 is a stun message and anything else starts a SIP message
+type keepaliveReader struct {
+	k       *Keepalive
+	br      *bufio.Reader
+	pending []byte
+	headers bool
+	midLine bool
+	length  int
+	body    int
+}
+
+func (kr *keepaliveReader) Read(ba []byte) (int, error) {
+	for len(kr.pending) == 0 && kr.body == 0 {
+		if err := kr.next(); err != nil {
+			return 0, err
+		}
+	}
+	if len(kr.pending) > 0 {
+		n := copy(ba, kr.pending)
+		kr.pending = kr.pending[n:]
+		return n, nil
+	}
+	if len(ba) > kr.body {
+		ba = ba[:kr.body]
+	}
+	n, err := kr.br.Read(ba)
+	kr.body -= n
+	return n, err
+}
+
+//next reads the next part of the stream, leaving what should be returned in
+//pending or body
+func (kr *keepaliveReader) next() error {
+	if kr.headers {
+		return kr.nextHeader()
+	}
+	b, err := kr.br.Peek(1)
+	if err != nil {
+		return err
+	}
+	switch {
+	case b[0] == '\r' || b[0] == '\n':
+		if kr.k.mode == keepaliveCRLF {
+			if b, err = kr.br.Peek(2); err == nil && bytes.Equal(b, crlfPong) {
+				kr.br.Discard(2)
+				kr.k.receivePong()
+				return nil
+			}
+		}
+		kr.pending = []byte{b[0]}
+		kr.br.Discard(1)
+	case b[0] <= 0x03:
+		frame, err := readFrame(kr.br)
+		if err != nil {
+			return err
+		}
+		if !kr.k.Receive(frame) {
+			kr.pending = frame
+		}
+	default:
+		kr.headers = true
+		kr.length = 0
+	}
+	return nil
+}
+
+//nextHeader passes on one header line of a SIP message, after the empty line
+//the body is passed on
+func (kr *keepaliveReader) nextHeader() error {
+	line, err := kr.br.ReadSlice('\n')
+	if len(line) == 0 {
+		return err
+	}
+	kr.pending = append([]byte(nil), line...)
+	if kr.midLine {
+		kr.midLine = err == bufio.ErrBufferFull
+		return nil
+	}
+	kr.midLine = err == bufio.ErrBufferFull
+	text := strings.TrimRight(string(line), "\r\n")
+	if !kr.midLine && text == "" {
+		kr.headers = false
+		kr.body = kr.length
+		return nil
+	}
+	if name, value, ok := strings.Cut(text, ":"); ok {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "content-length" || name == "l" {
+			if kr.length, _ = strconv.Atoi(strings.TrimSpace(value)); kr.length < 0 {
+				kr.length = 0
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//readFlow stands in for a SIP stack reading the flow and passing keepalive responses on
+func readFlow(conn net.PacketConn, k *Keepalive) {
+	ba := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(ba)
+		if err != nil {
+			return
+		}
+		k.Receive(ba[:n])
+	}
+}
+
+func TestKeepaliveRebinding(t *testing.T) {
+	var count int32
+	ss := NewStunServer(nil)
+	_, sconn := startManager(t, func(sp *StunPacket, addr net.Addr) *StunPacket {
+		ua := toUDPAddr(addr)
+		if atomic.AddInt32(&count, 1) > 2 {
+			ua = &net.UDPAddr{IP: ua.IP, Port: ua.Port + 1}
+		}
+		return ss.HandlePacket(sp, ua)
+	})
+	defer sconn.Close()
+	cconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer cconn.Close()
+
+	changes := make(chan [2]*net.UDPAddr, 1)
+	k := NewKeepalive(cconn, sconn.LocalAddr()).SetInterval(10 * time.Millisecond).
+		SetOnChange(func(old *net.UDPAddr, current *net.UDPAddr) {
+			changes <- [2]*net.UDPAddr{old, current}
+		})
+	go readFlow(cconn, k)
+	assert.False(t, k.Receive([]byte("SIP/2.0 200 OK\r\n\r\n")))
+
+	addr, err := k.Ping()
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), addr.String())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- k.Run()
+	}()
+	select {
+	case c := <-changes:
+		assert.Equal(t, cconn.LocalAddr().String(), c[0].String())
+		assert.Equal(t, cconn.LocalAddr().(*net.UDPAddr).Port+1, c[1].Port)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no rebinding seen")
+	}
+	k.Stop()
+	assert.NoError(t, <-done)
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer dead.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	k := NewKeepalive(cconn, dead.LocalAddr()).SetStunClient(sc.SetRTO(5 * time.Millisecond))
+	assert.Equal(t, ErrTimeout, k.Run())
+}
+
+func TestStreamKeepalive(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	crlf := NewStreamKeepalive(client, false).SetTimeout(time.Second)
+	stun := NewStreamKeepalive(client, true).SetTimeout(time.Second)
+	ss := NewStunServer(nil)
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5060}
+	go func() {
+		ba := make([]byte, 4)
+		for {
+			if _, err := server.Read(ba[:1]); err != nil {
+				return
+			}
+			if ba[0] == '\r' {
+				server.Read(ba[1:4])
+				if bytes.Equal(ba, crlfPing) {
+					server.Write(crlfPong)
+				}
+				continue
+			}
+			sp, err := ReadStunPacket(&prefixReader{b: ba[:1], r: server})
+			if err != nil {
+				return
+			}
+			server.Write(ss.HandlePacket(sp, remote).GetBytes())
+		}
+	}()
+	go io.Copy(io.Discard, stun.Reader(crlf.Reader(client)))
+
+	addr, err := crlf.Ping()
+	assert.NoError(t, err)
+	assert.Nil(t, addr)
+	addr, err = stun.Ping()
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:5060", addr.String())
+}
+
+func TestKeepaliveReader(t *testing.T) {
+	ss := NewStunServer(nil)
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5060}
+	sip := "OPTIONS sip:a@example.org SIP/2.0\r\nl: 4\r\n\r\n\r\n\r\n"
+	for _, split := range []bool{false, true} {
+		crlf := NewStreamKeepalive(io.Discard, false)
+		stun := NewStreamKeepalive(io.Discard, true)
+		req := NewStunPacketBuilder().Build()
+		wait := stun.tm.expect(context.Background(), req)
+		resp := ss.HandlePacket(req, remote).GetBytes()
+		other := ss.HandlePacket(NewStunPacketBuilder().Build(), remote).GetBytes()
+
+		//a pong and the response joined with SIP messages, or split into single bytes
+		stream := sip + "\r\n" + string(resp) + sip + string(other)
+		var r io.Reader = strings.NewReader(stream)
+		if split {
+			r = iotest.OneByteReader(r)
+		}
+		out, err := io.ReadAll(stun.Reader(crlf.Reader(r)))
+		assert.NoError(t, err)
+		assert.Equal(t, sip+sip+string(other), string(out))
+		sp, err := wait(time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		assert.Equal(t, resp, sp.GetBytes())
+		assert.Equal(t, 1, len(crlf.pong))
+		stun.tm.forget(req)
+	}
+}
+
+func TestKeepaliveReaderLineBreaks(t *testing.T) {
+	ss := NewStunServer(nil)
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5060}
+	sip := "OPTIONS sip:a@example.org SIP/2.0\r\nl: 0\r\n\r\n"
+
+	//CR and LF between messages are passed on in stun mode, not read as a stun frame
+	stun := NewStreamKeepalive(io.Discard, true)
+	req := NewStunPacketBuilder().Build()
+	wait := stun.tm.expect(context.Background(), req)
+	defer stun.tm.forget(req)
+	resp := ss.HandlePacket(req, remote).GetBytes()
+	out, err := io.ReadAll(stun.Reader(strings.NewReader("\r\n" + sip + "\r\n" + string(resp) + "\n" + sip)))
+	assert.NoError(t, err)
+	assert.Equal(t, "\r\n"+sip+"\r\n\n"+sip, string(out))
+	_, err = wait(time.Now().Add(time.Second))
+	assert.NoError(t, err)
+
+	//a lone LF is not a pong
+	crlf := NewStreamKeepalive(io.Discard, false)
+	out, err = io.ReadAll(crlf.Reader(strings.NewReader("\n" + sip + "\r")))
+	assert.NoError(t, err)
+	assert.Equal(t, "\n"+sip+"\r", string(out))
+	assert.Equal(t, 0, len(crlf.pong))
+}
+
+type prefixReader struct {
+	b []byte
+	r net.Conn
+}
+
+func (pr *prefixReader) Read(ba []byte) (int, error) {
+	if len(pr.b) > 0 {
+		n := copy(ba, pr.b)
+		pr.b = pr.b[n:]
+		return n, nil
+	}
+	return pr.r.Read(ba)
+}
@@ -0,0 +1,68 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+var ErrNoBinding = errors.New("Binding expired before the minimum lifetime!")
+
+//DiscoverBindingLifetime measures how long a NAT keeps the UDP binding of this
+//StunClient open with no traffic, using the rfc5780 binding lifetime test. Each
+//try makes a binding, waits, and then asks the server from the probe
+//net.PacketConn, with an SAResponsePort, to answer to the first binding. If the
+//answer gets through the binding was still open. A binary search between lo and
+//hi is done until it is within precision, and the longest time the binding was
+//seen open is returned. The server must support SAResponsePort, and each try that
+//fails takes the full retransmission timeout of the StunClient
+func (sc *StunClient) DiscoverBindingLifetime(server net.Addr, probe net.PacketConn, lo, hi, precision time.Duration) (time.Duration, error) {
+	open, err := sc.bindingOpenAfter(server, probe, lo)
+	if err != nil {
+		return 0, err
+	}
+	if !open {
+		return 0, ErrNoBinding
+	}
+	if open, err = sc.bindingOpenAfter(server, probe, hi); err != nil || open {
+		return hi, err
+	}
+	for hi-lo > precision {
+		mid := lo + (hi-lo)/2
+		open, err := sc.bindingOpenAfter(server, probe, mid)
+		if err != nil {
+			return lo, err
+		}
+		if open {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+//bindingOpenAfter makes a binding and checks if it is still open after wait
+func (sc *StunClient) bindingOpenAfter(server net.Addr, probe net.PacketConn, wait time.Duration) (bool, error) {
+	br, err := sc.Bind(server)
+	if err != nil {
+		return false, err
+	}
+	time.Sleep(wait)
+	req := NewStunPacketBuilder().SetStunMessage(SMRequest).SetTIDGenerator(sc.tidGen).
+		SetResponsePort(br.Address.Port).Build()
+	psc := NewStunClient(probe).SetRTO(sc.rto).SetRetransmits(sc.retransmits).SetLogger(sc.logger)
+	defer sc.conn.SetReadDeadline(time.Time{})
+	resp, _, _, err := psc.retransmit(br.Server, req, sc.readResponse(context.Background(), req))
+	if err == ErrTimeout {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if resp.GetStunMessageType() == SMFailure {
+		code, reason, _ := resp.GetErrorCode()
+		return false, &StunError{Code: code, Reason: reason}
+	}
+	return true, nil
+}
@@ -0,0 +1,88 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponsePortAndPadding(t *testing.T) {
+	_, sconn := startServer(t)
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer probe.Close()
+
+	req := NewStunPacketBuilder().SetResponsePort(cconn.LocalAddr().(*net.UDPAddr).Port).SetPadding(400).Build()
+	port, err := req.GetResponsePort()
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().(*net.UDPAddr).Port, port)
+	probe.WriteTo(req.GetBytes(), sconn.LocalAddr())
+	resp, err := sc.readResponse(context.Background(), req)(time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	ua, _ := resp.GetAddress()
+	assert.Equal(t, probe.LocalAddr().String(), ua.String())
+	assert.True(t, len(resp.GetBytes()) <= len(req.GetBytes()))
+	assert.True(t, len(resp.GetBytes()) > len(req.GetBytes())-8)
+	assert.True(t, resp.GetAttribute(SAPadding) != nil)
+}
+
+func TestDiscoverBindingLifetime(t *testing.T) {
+	//the handler acts like a NAT that closes bindings after natTimeout
+	natTimeout := 60 * time.Millisecond
+	var lock sync.Mutex
+	lastSeen := make(map[string]time.Time)
+	ss := NewStunServer(nil)
+	sconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer sconn.Close()
+	tm := NewTransactionManager(sconn).SetHandler(func(sp *StunPacket, addr net.Addr) *StunPacket {
+		lock.Lock()
+		defer lock.Unlock()
+		port, err := sp.GetResponsePort()
+		if err != nil {
+			lastSeen[addr.String()] = time.Now()
+			return ss.HandlePacket(sp, addr)
+		}
+		dst := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+		if time.Since(lastSeen[dst.String()]) < natTimeout {
+			sconn.WriteTo(ss.HandlePacket(sp, addr).GetBytes(), dst)
+		}
+		return nil
+	})
+	go tm.Serve()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	sc.SetRTO(5 * time.Millisecond).SetRetransmits(1)
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer probe.Close()
+
+	lifetime, err := sc.DiscoverBindingLifetime(sconn.LocalAddr(), probe, 10*time.Millisecond, 200*time.Millisecond, 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, lifetime >= 30*time.Millisecond && lifetime <= natTimeout, lifetime.String())
+
+	_, err = sc.DiscoverBindingLifetime(sconn.LocalAddr(), probe, 100*time.Millisecond, 200*time.Millisecond, 10*time.Millisecond)
+	assert.Equal(t, ErrNoBinding, err)
+}
+
+func TestBindingLifetimeNoNAT(t *testing.T) {
+	_, sconn := startServer(t)
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer probe.Close()
+
+	lifetime, err := sc.DiscoverBindingLifetime(sconn.LocalAddr(), probe, time.Millisecond, 20*time.Millisecond, time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 20*time.Millisecond, lifetime)
+}
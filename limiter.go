@@ -0,0 +1,172 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Limiter decides if a request from an address should be answered by a StunServer
+type Limiter interface {
+	Allow(addr net.Addr) bool
+}
+
+//LimiterStats are the counters of a RateLimiter
+type LimiterStats struct {
+	//Denied is how many packets were dropped by the deny list
+	Denied uint64
+	//Limited is how many packets were dropped because their bucket was empty
+	Limited uint64
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+	elem   *list.Element
+}
+
+//RateLimiter is a Limiter using a token bucket per source ip prefix, with
+//allow and deny lists. Addresses on the deny list are always dropped and
+//addresses on the allow list are never rate limited
+type RateLimiter struct {
+	denied     uint64
+	limited    uint64
+	lock       sync.Mutex
+	rate       float64
+	burst      float64
+	v4Mask     net.IPMask
+	v6Mask     net.IPMask
+	allow      []*net.IPNet
+	deny       []*net.IPNet
+	buckets    map[string]*tokenBucket
+	lru        *list.List
+	maxBuckets int
+	now        func() time.Time
+}
+
+//NewRateLimiter creates a RateLimiter that allows rate packets per second from each
+//source ip, with bursts of up to burst packets
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:       rate,
+		burst:      float64(burst),
+		v4Mask:     net.CIDRMask(32, 32),
+		v6Mask:     net.CIDRMask(64, 128),
+		buckets:    make(map[string]*tokenBucket),
+		lru:        list.New(),
+		maxBuckets: 65536,
+		now:        time.Now,
+	}
+}
+
+//SetPrefix sets how many bits of an ipv4 and ipv6 address share a bucket,
+//the defaults are 32 and 64
+func (rl *RateLimiter) SetPrefix(v4Bits int, v6Bits int) *RateLimiter {
+	rl.v4Mask = net.CIDRMask(v4Bits, 32)
+	rl.v6Mask = net.CIDRMask(v6Bits, 128)
+	return rl
+}
+
+//SetClock replaces time.Now, mostly for tests
+func (rl *RateLimiter) SetClock(now func() time.Time) *RateLimiter {
+	rl.now = now
+	return rl
+}
+
+//SetMaxBuckets sets how many buckets are kept, past it the least recently used
+//bucket is dropped for a new one
+func (rl *RateLimiter) SetMaxBuckets(count int) *RateLimiter {
+	rl.maxBuckets = count
+	return rl
+}
+
+//AllowCIDR adds a network that is never rate limited, ie "10.0.0.0/8"
+func (rl *RateLimiter) AllowCIDR(cidr string) error {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	rl.allow = append(rl.allow, n)
+	return nil
+}
+
+//DenyCIDR adds a network that is never answered
+func (rl *RateLimiter) DenyCIDR(cidr string) error {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	rl.deny = append(rl.deny, n)
+	return nil
+}
+
+//Stats returns the current drop counters
+func (rl *RateLimiter) Stats() LimiterStats {
+	return LimiterStats{
+		Denied:  atomic.LoadUint64(&rl.denied),
+		Limited: atomic.LoadUint64(&rl.limited),
+	}
+}
+
+//Allow takes a token from the bucket for the address, false means the packet should be dropped
+func (rl *RateLimiter) Allow(addr net.Addr) bool {
+	ua := toUDPAddr(addr)
+	if ua == nil {
+		return true
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if containsIP(rl.deny, ua.IP) {
+		atomic.AddUint64(&rl.denied, 1)
+		return false
+	}
+	if containsIP(rl.allow, ua.IP) {
+		return true
+	}
+	var key string
+	if ip4 := ua.IP.To4(); ip4 != nil {
+		key = ip4.Mask(rl.v4Mask).String()
+	} else {
+		key = ua.IP.Mask(rl.v6Mask).String()
+	}
+	now := rl.now()
+	tb, ok := rl.buckets[key]
+	if ok {
+		rl.lru.MoveToFront(tb.elem)
+	} else {
+		for len(rl.buckets) >= rl.maxBuckets && rl.lru.Len() > 0 {
+			old := rl.lru.Remove(rl.lru.Back()).(*tokenBucket)
+			delete(rl.buckets, old.key)
+		}
+		tb = &tokenBucket{key: key, tokens: rl.burst, last: now}
+		tb.elem = rl.lru.PushFront(tb)
+		rl.buckets[key] = tb
+	}
+	tb.tokens += now.Sub(tb.last).Seconds() * rl.rate
+	if tb.tokens > rl.burst {
+		tb.tokens = rl.burst
+	}
+	tb.last = now
+	if tb.tokens < 1 {
+		atomic.AddUint64(&rl.limited, 1)
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
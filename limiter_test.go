@@ -0,0 +1,89 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+func TestRateLimiter(t *testing.T) {
+	fc := &fakeClock{now: time.Unix(1000, 0)}
+	rl := NewRateLimiter(2, 3).SetClock(fc.Now)
+	a := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1000}
+	b := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 1000}
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, rl.Allow(a))
+	}
+	assert.False(t, rl.Allow(a))
+	assert.True(t, rl.Allow(b))
+	fc.now = fc.now.Add(500 * time.Millisecond)
+	assert.True(t, rl.Allow(a))
+	assert.False(t, rl.Allow(a))
+	fc.now = fc.now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, rl.Allow(a))
+	}
+	assert.Equal(t, LimiterStats{Limited: 2}, rl.Stats())
+}
+
+func TestRateLimiterPrefix(t *testing.T) {
+	fc := &fakeClock{now: time.Unix(1000, 0)}
+	rl := NewRateLimiter(1, 1).SetClock(fc.Now).SetPrefix(24, 48)
+	assert.True(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}))
+	assert.False(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 200)}))
+	assert.True(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(192, 0, 3, 1)}))
+	assert.True(t, rl.Allow(&net.UDPAddr{IP: net.ParseIP("2001:db8:1::1")}))
+	assert.False(t, rl.Allow(&net.UDPAddr{IP: net.ParseIP("2001:db8:1:ffff::1")}))
+}
+
+func TestRateLimiterLists(t *testing.T) {
+	rl := NewRateLimiter(0, 0)
+	assert.NoError(t, rl.AllowCIDR("10.0.0.0/8"))
+	assert.NoError(t, rl.DenyCIDR("10.1.0.0/16"))
+	assert.Error(t, rl.DenyCIDR("bad"))
+	assert.True(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(10, 2, 0, 1)}))
+	assert.False(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(10, 1, 0, 1)}))
+	assert.False(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}))
+	assert.Equal(t, LimiterStats{Denied: 1, Limited: 1}, rl.Stats())
+}
+
+func TestRateLimiterMaxBuckets(t *testing.T) {
+	fc := &fakeClock{now: time.Unix(1000, 0)}
+	rl := NewRateLimiter(1, 1).SetClock(fc.Now).SetMaxBuckets(2)
+	assert.True(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}))
+	assert.True(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 2)}))
+	assert.False(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}))
+	//.2 is the least recently used, so it is dropped for .3
+	assert.True(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 3)}))
+	assert.Equal(t, 2, len(rl.buckets))
+	assert.False(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}))
+	assert.True(t, rl.Allow(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 2)}))
+	assert.Equal(t, 2, len(rl.buckets))
+	assert.Equal(t, 2, rl.lru.Len())
+}
+
+func TestServerLimiterAndAmplification(t *testing.T) {
+	fc := &fakeClock{now: time.Unix(1000, 0)}
+	ss := NewStunServer(nil).SetLimiter(NewRateLimiter(1, 1).SetClock(fc.Now)).SetNoAmplification(true)
+	from := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+
+	assert.Nil(t, ss.HandlePacket(NewStunPacketBuilder().Build(), from))
+	assert.Nil(t, ss.HandlePacket(NewStunPacketBuilder().Build(), from))
+	fc.now = fc.now.Add(time.Second)
+	padded := NewStunPacketBuilder().SetAttribue(SASoftware, make([]byte, 32)).Build()
+	assert.NotNil(t, ss.HandlePacket(padded, from))
+	tcp := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 9), Port: 5000}
+	assert.NotNil(t, ss.HandlePacket(NewStunPacketBuilder().Build(), tcp))
+	assert.Equal(t, ServerStats{Answered: 2, Limited: 1, Amplification: 1}, ss.Stats())
+}
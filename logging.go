@@ -0,0 +1,37 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"net"
+)
+
+//logPacket logs an event about a StunPacket with the remote address, TID, method
+//and error code so it can be matched up with other logs. sp can be nil when the
+//packet could not be parsed, and nothing is done when l is nil
+func logPacket(l *slog.Logger, level slog.Level, msg string, sp *StunPacket, addr net.Addr, attrs ...slog.Attr) {
+	if l == nil || !l.Enabled(context.Background(), level) {
+		return
+	}
+	all := make([]slog.Attr, 0, len(attrs)+4)
+	if addr != nil {
+		all = append(all, slog.String("remote", addr.String()))
+	}
+	if sp != nil {
+		all = append(all,
+			slog.String("tid", hex.EncodeToString(sp.GetTxID().GetTID())),
+			slog.String("method", sp.GetStunMessageType().String()))
+		if sp.GetStunMessageType() == SMFailure {
+			if code, _, err := sp.GetErrorCode(); err == nil {
+				all = append(all, slog.Int("code", code))
+			}
+		}
+	}
+	all = append(all, attrs...)
+	l.LogAttrs(context.Background(), level, msg, all...)
+}
+
+func errAttr(err error) slog.Attr {
+	return slog.String("error", err.Error())
+}
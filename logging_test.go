@@ -0,0 +1,99 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type logBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (lb *logBuffer) Write(ba []byte) (int, error) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	return lb.buf.Write(ba)
+}
+
+func (lb *logBuffer) records(t *testing.T) []map[string]interface{} {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	recs := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(lb.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		rec := make(map[string]interface{})
+		assert.NoError(t, json.Unmarshal([]byte(line), &rec))
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func newTestLogger(lb *logBuffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(lb, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func TestClientLogger(t *testing.T) {
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer dead.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	lb := &logBuffer{}
+	sc.SetRTO(5 * time.Millisecond).SetRetransmits(1).SetLogger(newTestLogger(lb))
+
+	_, err = sc.Bind(dead.LocalAddr())
+	assert.Equal(t, ErrTimeout, err)
+	recs := lb.records(t)
+	assert.Equal(t, 2, len(recs))
+	assert.Equal(t, "Retransmitting request", recs[0]["msg"])
+	assert.Equal(t, "DEBUG", recs[0]["level"])
+	assert.Equal(t, float64(1), recs[0]["attempt"])
+	assert.Equal(t, "Request timed out", recs[1]["msg"])
+	assert.Equal(t, "WARN", recs[1]["level"])
+	assert.Equal(t, dead.LocalAddr().String(), recs[1]["remote"])
+	assert.Equal(t, "Binding Request", recs[1]["method"])
+	assert.Equal(t, recs[0]["tid"], recs[1]["tid"])
+	assert.Equal(t, 24, len(recs[1]["tid"].(string)))
+}
+
+func TestServerLogger(t *testing.T) {
+	lb := &logBuffer{}
+	ss := NewStunServer(nil).SetLogger(newTestLogger(lb)).SetLongTermAuth("example.org", func(username string) ([]byte, bool) {
+		key, _ := LongTermKey(username, "example.org", "pass")
+		return key, true
+	})
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+	resp := ss.HandlePacket(NewStunPacketBuilder().SetStunMessage(SMRequest).Build(), addr)
+	nonce, _ := resp.GetNonce()
+
+	key, _ := LongTermKey("user", "example.org", "wrong")
+	spb := NewStunPacketBuilder().SetStunMessage(SMRequest).SetMessageIntegrity(key)
+	spb.SetUsername("user")
+	spb.SetRealm("example.org")
+	spb.SetNonce(nonce)
+	req := spb.Build()
+	resp = ss.HandlePacket(req, addr)
+	assert.Equal(t, SMFailure, resp.GetStunMessageType())
+
+	recs := lb.records(t)
+	assert.Equal(t, 3, len(recs))
+	assert.Equal(t, "Sent error response", recs[0]["msg"])
+	assert.Equal(t, "Authentication failed", recs[1]["msg"])
+	assert.Equal(t, "INFO", recs[1]["level"])
+	assert.Equal(t, addr.String(), recs[1]["remote"])
+	assert.Equal(t, hex.EncodeToString(req.GetTxID().GetTID()), recs[1]["tid"])
+	assert.Equal(t, "Binding Error Response", recs[1]["method"])
+	assert.Equal(t, float64(ECUnauthorized), recs[1]["code"])
+}
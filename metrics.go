@@ -0,0 +1,245 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DefaultBuckets are the histogram buckets, in seconds, used by Metrics
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricInfo struct {
+	help  string
+	mtype string
+}
+
+var metricInfos = map[string]metricInfo{
+	"stun_messages_total":        {"StunPackets sent and received by message type", "counter"},
+	"stun_error_responses_total": {"Error responses sent and received by error code", "counter"},
+	"stun_errors_total":          {"Dropped packets and failed transactions", "counter"},
+	"stun_retransmits_total":     {"Requests resent by a client", "counter"},
+	"stun_timeouts_total":        {"Transactions that got no response", "counter"},
+	"stun_rtt_seconds":           {"Client round trip time", "histogram"},
+	"stun_processing_seconds":    {"Server time spent processing a request", "histogram"},
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+//Metrics collects counters and histograms from Hooks and serves them in the
+//Prometheus text exposition format
+type Metrics struct {
+	lock       sync.Mutex
+	buckets    []float64
+	counters   map[string]map[string]uint64
+	histograms map[string]map[string]*histogram
+}
+
+//NewMetrics creates an empty Metrics using the DefaultBuckets
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets:    DefaultBuckets,
+		counters:   make(map[string]map[string]uint64),
+		histograms: make(map[string]map[string]*histogram),
+	}
+}
+
+//SetBuckets replaces the histogram buckets, it must be called before any Hooks are used
+func (m *Metrics) SetBuckets(buckets []float64) *Metrics {
+	m.buckets = append([]float64(nil), buckets...)
+	sort.Float64s(m.buckets)
+	return m
+}
+
+//ClientHooks returns Hooks that record into these Metrics with role="client"
+func (m *Metrics) ClientHooks() Hooks {
+	return &metricsHooks{m: m, role: "client", reqDir: "out", respDir: "in", latency: "stun_rtt_seconds"}
+}
+
+//ServerHooks returns Hooks that record into these Metrics with role="server"
+func (m *Metrics) ServerHooks() Hooks {
+	return &metricsHooks{m: m, role: "server", reqDir: "in", respDir: "out", latency: "stun_processing_seconds"}
+}
+
+//labelEscaper escapes a label value as the text exposition format wants, which
+//is not the same as a go quoted string
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labels(kv ...string) string {
+	parts := make([]string, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		parts = append(parts, kv[i]+`="`+labelEscaper.Replace(kv[i+1])+`"`)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (m *Metrics) inc(name string, lbls string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	c, ok := m.counters[name]
+	if !ok {
+		c = make(map[string]uint64)
+		m.counters[name] = c
+	}
+	c[lbls]++
+}
+
+func (m *Metrics) observe(name string, lbls string, v float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	hs, ok := m.histograms[name]
+	if !ok {
+		hs = make(map[string]*histogram)
+		m.histograms[name] = hs
+	}
+	h, ok := hs[lbls]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		hs[lbls] = h
+	}
+	for i, b := range m.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := make([]string, 0)
+	switch v := m.(type) {
+	case map[string]uint64:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]*histogram:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeHeader(w io.Writer, name string) {
+	mi := metricInfos[name]
+	fmt.Fprintf(w, "# HELP %s %s\n", name, mi.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, mi.mtype)
+}
+
+//WriteText writes all the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteText(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	names := make([]string, 0, len(metricInfos))
+	for name := range metricInfos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if c, ok := m.counters[name]; ok {
+			writeHeader(w, name)
+			for _, l := range sortedKeys(c) {
+				fmt.Fprintf(w, "%s{%s} %d\n", name, l, c[l])
+			}
+		}
+		if hs, ok := m.histograms[name]; ok {
+			writeHeader(w, name)
+			for _, l := range sortedKeys(hs) {
+				h := hs[l]
+				for i, b := range m.buckets {
+					fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, l, strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+				}
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, l, h.count)
+				fmt.Fprintf(w, "%s_sum{%s} %s\n", name, l, strconv.FormatFloat(h.sum, 'g', -1, 64))
+				fmt.Fprintf(w, "%s_count{%s} %d\n", name, l, h.count)
+			}
+		}
+	}
+}
+
+//ServeHTTP serves the metrics so Metrics can be used as a /metrics http.Handler
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteText(w)
+}
+
+type metricsHooks struct {
+	m       *Metrics
+	role    string
+	reqDir  string
+	respDir string
+	latency string
+}
+
+func (mh *metricsHooks) OnRequest(sp *StunPacket, addr net.Addr) {
+	mh.m.inc("stun_messages_total", labels("role", mh.role, "direction", mh.reqDir, "type", sp.GetStunMessageType().String()))
+}
+
+func (mh *metricsHooks) OnResponse(sp *StunPacket, addr net.Addr, d time.Duration) {
+	mh.m.inc("stun_messages_total", labels("role", mh.role, "direction", mh.respDir, "type", sp.GetStunMessageType().String()))
+	if code, _, err := sp.GetErrorCode(); err == nil {
+		mh.m.inc("stun_error_responses_total", labels("role", mh.role, "code", strconv.Itoa(code)))
+	}
+	mh.m.observe(mh.latency, labels("role", mh.role), d.Seconds())
+}
+
+func (mh *metricsHooks) OnError(err error, addr net.Addr) {
+	mh.m.inc("stun_errors_total", labels("role", mh.role, "reason", errorReason(err)))
+}
+
+//errorReason maps an error to one of a fixed set of label values, so a label
+//does not get a new value for every error message
+func errorReason(err error) string {
+	var se *StunError
+	switch {
+	case err == ErrRateLimited:
+		return "rate_limited"
+	case err == ErrAmplification:
+		return "amplification"
+	case err == ErrNotStunPacket || err == ErrInvalidAttributeSize:
+		return "invalid_packet"
+	case err == ErrNoFingerPrint || err == ErrFingerPrintNotLast || err == ErrFingerPrintLength ||
+		err == ErrFingerPrintMismatch || err == ErrFingerPrintRequired:
+		return "fingerprint"
+	case err == ErrBadIntegrity:
+		return "integrity"
+	case err == ErrRedirectLoop || err == ErrMaxRedirects || err == ErrNoAlternate:
+		return "redirect"
+	case err == ErrTimeout || errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &se):
+		return "error_response"
+	case errors.Is(err, net.ErrClosed) || err == ErrStopped:
+		return "closed"
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return "network"
+	}
+	return "other"
+}
+
+func (mh *metricsHooks) OnRetransmit(sp *StunPacket, addr net.Addr, attempt int) {
+	mh.m.inc("stun_retransmits_total", labels("role", mh.role))
+}
+
+func (mh *metricsHooks) OnTimeout(sp *StunPacket, addr net.Addr) {
+	mh.m.inc("stun_timeouts_total", labels("role", mh.role))
+}
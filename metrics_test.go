@@ -0,0 +1,105 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordHooks struct {
+	NoopHooks
+	lock   sync.Mutex
+	events []string
+}
+
+func (rh *recordHooks) add(e string) {
+	rh.lock.Lock()
+	defer rh.lock.Unlock()
+	rh.events = append(rh.events, e)
+}
+
+func (rh *recordHooks) OnRequest(sp *StunPacket, addr net.Addr) {
+	rh.add("request")
+}
+
+func (rh *recordHooks) OnRetransmit(sp *StunPacket, addr net.Addr, attempt int) {
+	rh.add("retransmit")
+}
+
+func (rh *recordHooks) OnTimeout(sp *StunPacket, addr net.Addr) {
+	rh.add("timeout")
+}
+
+func TestClientHooks(t *testing.T) {
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer dead.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	rh := &recordHooks{}
+	sc.SetRTO(5 * time.Millisecond).SetHooks(rh)
+
+	_, err = sc.Bind(dead.LocalAddr())
+	assert.Equal(t, ErrTimeout, err)
+	assert.Equal(t, []string{"request", "retransmit", "retransmit", "timeout"}, rh.events)
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics().SetBuckets([]float64{1, 0.5})
+	_, sconn := startServer(t, func(ss *StunServer) {
+		//the first request is answered and the second one redirected
+		ss.SetHooks(m.ServerHooks()).SetAlternateServer(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, "").SetMaxLoad(1)
+	})
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	sc.SetHooks(m.ClientHooks())
+
+	_, err := sc.Bind(sconn.LocalAddr())
+	assert.NoError(t, err)
+	sc.SetMaxRedirects(0)
+	_, err = sc.Bind(sconn.LocalAddr())
+	assert.Equal(t, ErrMaxRedirects, err)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`# TYPE stun_messages_total counter`,
+		`stun_messages_total{direction="out",role="client",type="Binding Request"} 2`,
+		`stun_messages_total{direction="in",role="client",type="Binding Success Response"} 1`,
+		`stun_messages_total{direction="in",role="server",type="Binding Request"} 2`,
+		`stun_messages_total{direction="out",role="server",type="Binding Error Response"} 1`,
+		`stun_error_responses_total{code="300",role="client"} 1`,
+		`stun_error_responses_total{code="300",role="server"} 1`,
+		`stun_errors_total{reason="redirect",role="client"} 1`,
+		`# TYPE stun_rtt_seconds histogram`,
+		`stun_rtt_seconds_bucket{role="client",le="0.5"} 2`,
+		`stun_rtt_seconds_bucket{role="client",le="+Inf"} 2`,
+		`stun_rtt_seconds_count{role="client"} 2`,
+		`stun_processing_seconds_count{role="server"} 2`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	buf := &bytes.Buffer{}
+	m.WriteText(buf)
+	assert.Equal(t, body, buf.String())
+	assert.True(t, strings.Index(body, "stun_error_responses_total") < strings.Index(body, "stun_messages_total"))
+}
+
+func TestMetricsLabels(t *testing.T) {
+	assert.Equal(t, `a="x\\y\"z\n",b="c"`, labels("b", "c", "a", "x\\y\"z\n"))
+	assert.Equal(t, "invalid_packet", errorReason(ErrNotStunPacket))
+	assert.Equal(t, "fingerprint", errorReason(ErrFingerPrintMismatch))
+	assert.Equal(t, "error_response", errorReason(&StunError{Code: ECBadRequest, Reason: "any reason"}))
+	assert.Equal(t, "closed", errorReason(fmt.Errorf("read: %w", net.ErrClosed)))
+	assert.Equal(t, "other", errorReason(errors.New("Something new!")))
+}
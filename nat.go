@@ -0,0 +1,119 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"net"
+)
+
+//NATBehavior is an rfc5780 mapping or filtering behavior
+type NATBehavior int
+
+const (
+	NATUnknown NATBehavior = iota
+	NATEndpointIndependent
+	NATAddressDependent
+	NATAddressAndPortDependent
+)
+
+func (nb NATBehavior) String() string {
+	switch nb {
+	case NATEndpointIndependent:
+		return "Endpoint-Independent"
+	case NATAddressDependent:
+		return "Address-Dependent"
+	case NATAddressAndPortDependent:
+		return "Address and Port-Dependent"
+	}
+	return "Unknown"
+}
+
+//NATResult is the outcome of DiscoverNAT
+type NATResult struct {
+	MappedAddress *net.UDPAddr
+	//OtherAddress is the servers alternate address, nil if it does not support rfc5780
+	OtherAddress *net.UDPAddr
+	//NAT is true when the MappedAddress is not one of our local addresses
+	NAT       bool
+	Mapping   NATBehavior
+	Filtering NATBehavior
+}
+
+//DiscoverNAT runs the rfc5780 mapping and filtering behavior tests against the server.
+//Mapping and Filtering are NATUnknown when the server does not send an SAOtherAddress.
+//The filtering tests expect some requests to time out, so a short RTO and few
+//retransmits should be set on the StunClient first
+func (sc *StunClient) DiscoverNAT(server net.Addr) (*NATResult, error) {
+	br, err := sc.Bind(server)
+	if err != nil {
+		return nil, err
+	}
+	nr := &NATResult{MappedAddress: br.Address, NAT: !isLocalAddress(sc.conn.LocalAddr(), br.Address)}
+	if br.Response.GetAttribute(SAOtherAddress) == nil {
+		return nr, nil
+	}
+	other, err := br.Response.GetAddressAttribute(SAOtherAddress)
+	if err != nil {
+		return nr, err
+	}
+	nr.OtherAddress = other
+	primary := toUDPAddr(br.Server)
+
+	br2, err := sc.Bind(&net.UDPAddr{IP: other.IP, Port: primary.Port})
+	if err != nil {
+		return nr, err
+	}
+	if sameUDPAddr(br.Address, br2.Address) {
+		nr.Mapping = NATEndpointIndependent
+	} else {
+		br3, err := sc.Bind(other)
+		if err != nil {
+			return nr, err
+		}
+		if sameUDPAddr(br2.Address, br3.Address) {
+			nr.Mapping = NATAddressDependent
+		} else {
+			nr.Mapping = NATAddressAndPortDependent
+		}
+	}
+
+	_, err = sc.bind(context.Background(), primary, ChangeIP|ChangePort)
+	if err == nil {
+		nr.Filtering = NATEndpointIndependent
+		return nr, nil
+	} else if err != ErrTimeout {
+		return nr, err
+	}
+	_, err = sc.bind(context.Background(), primary, ChangePort)
+	if err == nil {
+		nr.Filtering = NATAddressDependent
+	} else if err == ErrTimeout {
+		nr.Filtering = NATAddressAndPortDependent
+	} else {
+		return nr, err
+	}
+	return nr, nil
+}
+
+func sameUDPAddr(a *net.UDPAddr, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
+
+func isLocalAddress(local net.Addr, mapped *net.UDPAddr) bool {
+	la := toUDPAddr(local)
+	if la == nil || la.Port != mapped.Port {
+		return false
+	}
+	if !la.IP.IsUnspecified() {
+		return la.IP.Equal(mapped.IP)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && ipn.IP.Equal(mapped.IP) {
+			return true
+		}
+	}
+	return false
+}
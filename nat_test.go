@@ -0,0 +1,78 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listenPair(t *testing.T, ip net.IP) (*net.UDPConn, *net.UDPConn) {
+	c1, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+	assert.NoError(t, err)
+	c2, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+	assert.NoError(t, err)
+	return c1, c2
+}
+
+func TestDiscoverNAT(t *testing.T) {
+	a1p1, a1p2 := listenPair(t, net.IPv4(127, 0, 0, 1))
+	p1 := a1p1.LocalAddr().(*net.UDPAddr).Port
+	p2 := a1p2.LocalAddr().(*net.UDPAddr).Port
+	a2p1, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: p1})
+	if err != nil {
+		t.Skip("127.0.0.2 is not usable: ", err)
+	}
+	a2p2, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: p2})
+	if err != nil {
+		t.Skip("127.0.0.2 is not usable: ", err)
+	}
+	for _, c := range []*net.UDPConn{a1p1, a1p2, a2p1, a2p2} {
+		defer c.Close()
+	}
+	for _, ss := range NewStunServers(a1p1, a1p2, a2p1, a2p2) {
+		go ss.Serve()
+	}
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+
+	nr, err := sc.DiscoverNAT(a1p1.LocalAddr())
+	assert.NoError(t, err)
+	assert.False(t, nr.NAT)
+	assert.Equal(t, a2p2.LocalAddr().String(), nr.OtherAddress.String())
+	assert.Equal(t, NATEndpointIndependent, nr.Mapping)
+	assert.Equal(t, NATEndpointIndependent, nr.Filtering)
+}
+
+func TestDiscoverNATNoOtherAddress(t *testing.T) {
+	_, sconn := startServer(t)
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+
+	nr, err := sc.DiscoverNAT(sconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Nil(t, nr.OtherAddress)
+	assert.Equal(t, NATUnknown, nr.Mapping)
+	assert.Equal(t, NATUnknown, nr.Filtering)
+}
+
+func TestChangeRequestWithoutConns(t *testing.T) {
+	a1p1, a1p2 := listenPair(t, net.IPv4(127, 0, 0, 1))
+	defer a1p1.Close()
+	defer a1p2.Close()
+	for _, ss := range NewStunServers(a1p1, a1p2, nil, nil) {
+		go ss.Serve()
+	}
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	sc.SetRTO(20 * time.Millisecond)
+
+	br, err := sc.bind(context.Background(), a1p1.LocalAddr(), ChangePort)
+	assert.NoError(t, err)
+	assert.Equal(t, a1p1.LocalAddr().String(), br.Server.String())
+	_, err = sc.bind(context.Background(), a1p1.LocalAddr(), ChangeIP)
+	assert.Equal(t, &StunError{Code: ECUnknownAttribute, Reason: "Unknown Attribute"}, err)
+}
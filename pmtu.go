@@ -0,0 +1,110 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+const (
+	//MinIPv4MTU and MinIPv6MTU are the smallest packets every path must carry,
+	//rfc5389 says STUN messages should fit in them
+	MinIPv4MTU = 576
+	MinIPv6MTU = 1280
+	//DefaultMaxMTU is the largest size DiscoverPathMTU tries by default
+	DefaultMaxMTU = 1500
+	ipv4Overhead  = 20 + 8
+	ipv6Overhead  = 40 + 8
+)
+
+var (
+	ErrNoPathMTU    = errors.New("No response at the minimum MTU!")
+	ErrDontFragment = errors.New("Can not set Don't Fragment on this net.PacketConn!")
+)
+
+//PMTUResult is the outcome of DiscoverPathMTU
+type PMTUResult struct {
+	//MTU is the largest IP packet that got a response
+	MTU int
+	//Probes are the IP packet sizes that were tried and if they got a response
+	Probes map[int]bool
+}
+
+//DiscoverPathMTU finds the largest packet that gets to the server and back by
+//sending Binding requests padded with an SAPadding to different sizes. Sizes
+//are IP packet sizes, including the IP and UDP headers, and go from the IPv4 576
+//or IPv6 1280 minimum to max, 0 uses DefaultMaxMTU. The server pads its responses
+//to the size of the request so both directions are tested, a StunServer does this.
+//The net.PacketConn should have SetDontFragment set, otherwise large packets are
+//fragmented instead of dropped. Each size that does not get through takes the full
+//retransmission timeout of the StunClient
+func (sc *StunClient) DiscoverPathMTU(server net.Addr, max int) (*PMTUResult, error) {
+	min, overhead := MinIPv4MTU, ipv4Overhead
+	if ua := toUDPAddr(server); ua != nil && ua.IP.To4() == nil {
+		min, overhead = MinIPv6MTU, ipv6Overhead
+	}
+	if max <= 0 {
+		max = DefaultMaxMTU
+	}
+	//probes are a multiple of 4 bytes, like every STUN message
+	max &^= 3
+	pr := &PMTUResult{Probes: make(map[int]bool)}
+	probe := func(size int) (bool, error) {
+		ok, err := sc.probeSize(server, size-overhead)
+		if err == nil {
+			pr.Probes[size] = ok
+		}
+		return ok, err
+	}
+	ok, err := probe(min)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return pr, ErrNoPathMTU
+	}
+	pr.MTU = min
+	if max <= min {
+		return pr, nil
+	}
+	if ok, err = probe(max); err != nil || ok {
+		if ok {
+			pr.MTU = max
+		}
+		return pr, err
+	}
+	lo, hi := min, max
+	for {
+		mid := (lo + (hi-lo)/2) &^ 3
+		if mid <= lo {
+			break
+		}
+		ok, err := probe(mid)
+		if err != nil {
+			return pr, err
+		}
+		if ok {
+			lo = mid
+			pr.MTU = mid
+		} else {
+			hi = mid
+		}
+	}
+	return pr, nil
+}
+
+//probeSize sends a Binding request that is size bytes long, size must be a
+//multiple of 4
+func (sc *StunClient) probeSize(server net.Addr, size int) (bool, error) {
+	padding := size - 20 - 4
+	if padding < 0 {
+		padding = 0
+	}
+	req := NewStunPacketBuilder().SetStunMessage(SMRequest).SetTIDGenerator(sc.tidGen).
+		SetPadding(padding).Build()
+	_, _, _, err := sc.transact(context.Background(), server, req)
+	if err == ErrTimeout || isMsgSize(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,41 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+//SetDontFragment sets the IP don't fragment bit on packets sent from a UDP
+//net.PacketConn, which DiscoverPathMTU needs to find the real path MTU
+func SetDontFragment(conn net.PacketConn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return ErrDontFragment
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	v6 := false
+	if ua := toUDPAddr(conn.LocalAddr()); ua != nil && ua.IP.To4() == nil && !ua.IP.IsUnspecified() {
+		v6 = true
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if v6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+//isMsgSize is true when a write failed because the packet was larger than the local MTU
+func isMsgSize(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
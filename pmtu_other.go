@@ -0,0 +1,16 @@
+//go:build !linux
+
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"net"
+)
+
+//SetDontFragment is only supported on linux, it returns ErrDontFragment everywhere else
+func SetDontFragment(conn net.PacketConn) error {
+	return ErrDontFragment
+}
+
+func isMsgSize(err error) bool {
+	return false
+}
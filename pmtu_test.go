@@ -0,0 +1,66 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverPathMTU(t *testing.T) {
+	//the handler acts like a path that drops IP packets over 1100 bytes
+	ss := NewStunServer(nil).SetNoAmplification(true)
+	_, sconn := startManager(t, func(sp *StunPacket, addr net.Addr) *StunPacket {
+		if len(sp.GetBytes())+ipv4Overhead > 1100 {
+			return nil
+		}
+		resp := ss.HandlePacket(sp, addr)
+		assert.NotNil(t, resp)
+		return resp
+	})
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	sc.SetRTO(5 * time.Millisecond).SetRetransmits(1)
+
+	pr, err := sc.DiscoverPathMTU(sconn.LocalAddr(), 0)
+	assert.NoError(t, err)
+	assert.True(t, pr.MTU > 1100-8 && pr.MTU <= 1100, pr.MTU)
+	assert.True(t, pr.Probes[MinIPv4MTU])
+	assert.False(t, pr.Probes[DefaultMaxMTU])
+	for size, ok := range pr.Probes {
+		assert.Equal(t, size <= pr.MTU, ok)
+	}
+
+	pr, err = sc.DiscoverPathMTU(sconn.LocalAddr(), 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, pr.MTU)
+
+	//an odd max is rounded down to the size that is really sent
+	pr, err = sc.DiscoverPathMTU(sconn.LocalAddr(), 1003)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, pr.MTU)
+	pr, err = sc.DiscoverPathMTU(sconn.LocalAddr(), 1203)
+	assert.NoError(t, err)
+	assert.True(t, pr.MTU > 1100-8 && pr.MTU <= 1100, pr.MTU)
+	assert.False(t, pr.Probes[1200])
+	pr, err = sc.DiscoverPathMTU(sconn.LocalAddr(), 500)
+	assert.NoError(t, err)
+	assert.Equal(t, MinIPv4MTU, pr.MTU)
+}
+
+func TestPathMTUDontFragment(t *testing.T) {
+	_, sconn := startServer(t)
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	if err := SetDontFragment(cconn); err == ErrDontFragment {
+		t.Skip(err)
+	} else {
+		assert.NoError(t, err)
+	}
+	pr, err := sc.DiscoverPathMTU(sconn.LocalAddr(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultMaxMTU, pr.MTU)
+}
@@ -0,0 +1,195 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoBackends = errors.New("No Backend Servers!")
+
+type proxyTransaction struct {
+	client  net.Addr
+	server  net.Addr
+	key     []byte
+	expires time.Time
+}
+
+//StunProxy forwards stun requests from clients to backend StunServers and sends the
+//responses back, rewriting the mapped addresses so they show the original client
+//instead of the StunProxy. Transactions are tracked by TransactionID, so retransmits
+//go to the same backend
+type StunProxy struct {
+	//next is first so it is aligned for atomic on 32bit platforms
+	next      uint64
+	conn      net.PacketConn
+	backend   net.PacketConn
+	servers   []net.Addr
+	keys      func(req *StunPacket) ([]byte, bool)
+	timeout   time.Duration
+	logger    *slog.Logger
+	lock      sync.Mutex
+	pending   map[string]*proxyTransaction
+	nextSweep time.Time
+}
+
+//NewStunProxy creates a StunProxy that reads client requests from conn and forwards
+//them from backend to the servers, picking them round robin
+func NewStunProxy(conn net.PacketConn, backend net.PacketConn, servers ...net.Addr) *StunProxy {
+	return &StunProxy{
+		conn:    conn,
+		backend: backend,
+		servers: servers,
+		timeout: DefaultCacheTime,
+		pending: make(map[string]*proxyTransaction),
+	}
+}
+
+//SetKeys sets how the MessageIntegrity key for a request is found, ie with
+//ShortTermKey or LongTermKey from its SAUsername. Responses with a known key are
+//signed again after being rewritten. Responses with an SAMessageIntegrity and no
+//known key are forwarded unchanged, since rewriting them would break the integrity
+func (p *StunProxy) SetKeys(keys func(req *StunPacket) ([]byte, bool)) *StunProxy {
+	p.keys = keys
+	return p
+}
+
+//SetTimeout sets how long a transaction is remembered waiting for a response
+func (p *StunProxy) SetTimeout(d time.Duration) *StunProxy {
+	p.timeout = d
+	return p
+}
+
+//SetLogger sets the slog.Logger used to log dropped packets, nil disables logging
+func (p *StunProxy) SetLogger(logger *slog.Logger) *StunProxy {
+	p.logger = logger
+	return p
+}
+
+//Serve forwards packets until a read fails on either net.PacketConn
+func (p *StunProxy) Serve() error {
+	if len(p.servers) == 0 {
+		return ErrNoBackends
+	}
+	errs := make(chan error, 2)
+	go func() {
+		errs <- p.serveClients()
+	}()
+	go func() {
+		errs <- p.serveBackends()
+	}()
+	return <-errs
+}
+
+func (p *StunProxy) serveClients() error {
+	ba := make([]byte, 65536)
+	for {
+		n, addr, err := p.conn.ReadFrom(ba)
+		if err != nil {
+			return err
+		}
+		sp, err := NewStunPacket(ba[:n])
+		if err != nil {
+			logPacket(p.logger, slog.LevelDebug, "Dropped invalid packet", nil, addr, errAttr(err))
+			continue
+		}
+		switch sp.GetStunMessageType().Class() {
+		case ClassSuccess, ClassFailure:
+			logPacket(p.logger, slog.LevelDebug, "Dropped response from client", sp, addr)
+			continue
+		}
+		server := p.track(sp, addr)
+		p.backend.WriteTo(sp.GetBytes(), server)
+	}
+}
+
+//track remembers which client sent a request and picks its backend server
+func (p *StunProxy) track(sp *StunPacket, client net.Addr) net.Addr {
+	now := time.Now()
+	tid := string(sp.GetTxID().GetTID())
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if now.After(p.nextSweep) {
+		for k, pt := range p.pending {
+			if now.After(pt.expires) {
+				delete(p.pending, k)
+			}
+		}
+		p.nextSweep = now.Add(p.timeout)
+	}
+	if pt, ok := p.pending[tid]; ok && pt.client.String() == client.String() {
+		return pt.server
+	}
+	server := p.servers[atomic.AddUint64(&p.next, 1)%uint64(len(p.servers))]
+	if sp.GetStunMessageType() != SMRequest {
+		return server
+	}
+	pt := &proxyTransaction{client: client, server: server, expires: now.Add(p.timeout)}
+	if p.keys != nil {
+		if key, ok := p.keys(sp); ok {
+			pt.key = key
+		}
+	}
+	p.pending[tid] = pt
+	return server
+}
+
+func (p *StunProxy) serveBackends() error {
+	ba := make([]byte, 65536)
+	for {
+		n, addr, err := p.backend.ReadFrom(ba)
+		if err != nil {
+			return err
+		}
+		sp, err := NewStunPacket(append([]byte(nil), ba[:n]...))
+		if err != nil {
+			logPacket(p.logger, slog.LevelDebug, "Dropped invalid packet", nil, addr, errAttr(err))
+			continue
+		}
+		//only the backend the request went to can answer it, and only once
+		tid := string(sp.GetTxID().GetTID())
+		p.lock.Lock()
+		pt, ok := p.pending[tid]
+		ok = ok && pt.server.String() == addr.String()
+		if ok {
+			delete(p.pending, tid)
+		}
+		p.lock.Unlock()
+		if !ok {
+			logPacket(p.logger, slog.LevelDebug, "Dropped unexpected response", sp, addr)
+			continue
+		}
+		if sp.HasMessageIntegrity() && pt.key == nil {
+			logPacket(p.logger, slog.LevelDebug, "Forwarding response without rewriting", sp, addr)
+		} else if ua := toUDPAddr(pt.client); ua != nil {
+			sp = RewriteResponse(sp, ua, toUDPAddr(p.conn.LocalAddr()), pt.key)
+		}
+		p.conn.WriteTo(sp.GetBytes(), pt.client)
+	}
+}
+
+//RewriteResponse changes the SAXORMappedAddress and SAMappedAddress in a response to
+//the client address and the SAResponseOrigin to origin, which can be nil. SAOtherAddress
+//is removed since rfc5780 tests can not work through a proxy. Every other attribute is
+//kept byte for byte. The SAFingerPrint is created again if there was one, and the
+//SAMessageIntegrity is created again when the key is not nil or dropped otherwise
+func RewriteResponse(resp *StunPacket, client *net.UDPAddr, origin *net.UDPAddr, key []byte) *StunPacket {
+	spb := resp.ToBuilder()
+	if spb.HasAttribute(SAXORMappedAddress) {
+		spb.ReplaceAttribute(SAXORMappedAddress, CreateMaskedAddress(*resp.GetTxID(), client))
+	}
+	if spb.HasAttribute(SAMappedAddress) {
+		spb.ReplaceAttribute(SAMappedAddress, CreateAddress(client))
+	}
+	if origin != nil && spb.HasAttribute(SAResponseOrigin) {
+		spb.ReplaceAttribute(SAResponseOrigin, CreateAddress(origin))
+	}
+	spb.RemoveAttribute(SAOtherAddress)
+	if key != nil && resp.HasMessageIntegrity() {
+		spb.SetMessageIntegrity(key)
+	}
+	return spb.AddFingerprint(resp.HasFingerPrint()).Build()
+}
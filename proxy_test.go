@@ -0,0 +1,140 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startProxy(t *testing.T, servers ...net.Addr) (*StunProxy, *net.UDPConn, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	backend, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	return NewStunProxy(conn, backend, servers...), conn, backend
+}
+
+func TestProxyRewritesAddress(t *testing.T) {
+	vendor := []byte{1, 2, 3, 4, 5}
+	bconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer bconn.Close()
+	ss := NewStunServer(bconn)
+	tm := NewTransactionManager(bconn).SetHandler(func(sp *StunPacket, addr net.Addr) *StunPacket {
+		resp := ss.HandlePacket(sp, addr)
+		return resp.ToBuilder().InsertAttribute(0, StunAttribute(0xC057), vendor).
+			SetAddress(toUDPAddr(addr)).AddFingerprint(true).Build()
+	})
+	go tm.Serve()
+
+	p, pconn, backend := startProxy(t, bconn.LocalAddr())
+	defer pconn.Close()
+	defer backend.Close()
+	go p.Serve()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+
+	br, err := sc.Bind(pconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), br.Address.String())
+	ua, err := br.Response.GetAddressPreferXOR()
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), ua.String())
+	assert.Equal(t, vendor, br.Response.GetAttribute(StunAttribute(0xC057)))
+	assert.Equal(t, StunAttribute(0xC057), br.Response.GetAllAttributes()[0])
+	assert.True(t, VerifyFingerPrint(*br.Response))
+}
+
+func TestProxyIntegrity(t *testing.T) {
+	keys := func(username string) ([]byte, bool) {
+		key, _ := LongTermKey(username, "example.org", "pass")
+		return key, true
+	}
+	sconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer sconn.Close()
+	go NewStunServer(sconn).SetLongTermAuth("example.org", keys).Serve()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	sc.SetCredentials("user", "pass")
+
+	//without the keys the signed response can not be rewritten
+	p, pconn, backend := startProxy(t, sconn.LocalAddr())
+	defer pconn.Close()
+	defer backend.Close()
+	go p.Serve()
+	br, err := sc.Bind(pconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, backend.LocalAddr().String(), br.Address.String())
+
+	p, pconn, backend = startProxy(t, sconn.LocalAddr())
+	defer pconn.Close()
+	defer backend.Close()
+	p.SetKeys(func(req *StunPacket) ([]byte, bool) {
+		username, err := req.GetUsername()
+		if err != nil {
+			return nil, false
+		}
+		return keys(username)
+	})
+	go p.Serve()
+	br, err = sc.Bind(pconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), br.Address.String())
+	assert.True(t, br.Response.HasMessageIntegrity())
+}
+
+func TestProxyDropsUnexpectedResponses(t *testing.T) {
+	bconn := listenLoopback(t)
+	defer bconn.Close()
+	p, pconn, backend := startProxy(t, bconn.LocalAddr())
+	defer pconn.Close()
+	defer backend.Close()
+	go p.Serve()
+	cconn := listenLoopback(t)
+	defer cconn.Close()
+	spoof := listenLoopback(t)
+	defer spoof.Close()
+
+	req := NewStunPacketBuilder().Build()
+	cconn.WriteTo(req.GetBytes(), pconn.LocalAddr())
+	ba := make([]byte, 1500)
+	bconn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, from, err := bconn.ReadFrom(ba)
+	assert.NoError(t, err)
+	resp := NewStunServer(nil).HandlePacket(req, cconn.LocalAddr()).GetBytes()
+
+	//a response from anything but the backend, and a second response, are dropped
+	spoof.WriteTo(resp, from)
+	bconn.WriteTo(resp, from)
+	bconn.WriteTo(resp, from)
+	cconn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = cconn.Read(ba)
+	assert.NoError(t, err)
+	cconn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = cconn.Read(ba)
+	assert.Error(t, err)
+}
+
+func TestRewriteResponse(t *testing.T) {
+	client := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 1000}
+	origin := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2).To4(), Port: 3478}
+	spb := NewStunPacketBuilder().SetStunMessage(SMSuccess).
+		SetXORAddress(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5})
+	spb.SetSoftware("backend")
+	spb.SetValue(SAResponseOrigin, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 3478})
+	spb.SetValue(SAOtherAddress, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 3479})
+	resp := spb.SetMessageIntegrity([]byte("old")).Build()
+
+	rw := RewriteResponse(resp, client, origin, []byte("new"))
+	assert.Equal(t, resp.GetTxID(), rw.GetTxID())
+	assert.Equal(t, []StunAttribute{SAXORMappedAddress, SASoftware, SAResponseOrigin, SAMessageIntegrity}, rw.GetAllAttributes())
+	ua, _ := rw.GetAddress()
+	assert.Equal(t, client, ua)
+	ua, _ = rw.GetAddressAttribute(SAResponseOrigin)
+	assert.Equal(t, origin, ua)
+	assert.True(t, rw.VerifyMessageIntegrity([]byte("new")))
+	assert.False(t, rw.HasFingerPrint())
+}
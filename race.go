@@ -0,0 +1,180 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+//DefaultRaceDelay is the rfc8305 Connection Attempt Delay, how long to wait for
+//a server before also trying the next one
+const DefaultRaceDelay = 250 * time.Millisecond
+
+//RaceResult is how the Binding request to one server in a race went, Result is
+//set when Err is nil
+type RaceResult struct {
+	Server net.Addr
+	Result *BindResult
+	Err    error
+}
+
+//BindRacer sends Binding requests to a list of servers in rfc8305 happy eyeballs
+//style, to get a mapped address quickly even when some servers or an address
+//family are not working
+type BindRacer struct {
+	v4      *StunClient
+	v6      *StunClient
+	delay   time.Duration
+	collect time.Duration
+}
+
+//NewBindRacer creates a BindRacer sending from v4 to IPv4 servers and from v6 to
+//IPv6 servers. If one is nil the other is used for both, like with a dual stack
+//socket. Several requests wait on the same socket in a race, so a StunClient
+//without a TransactionManager gets one that reads its socket while Race runs
+func NewBindRacer(v4, v6 *StunClient) *BindRacer {
+	if v4 == nil {
+		v4 = v6
+	}
+	if v6 == nil {
+		v6 = v4
+	}
+	return &BindRacer{v4: v4, v6: v6, delay: DefaultRaceDelay}
+}
+
+//SetDelay sets how long to wait before starting the next server, a server that
+//fails starts the next one right away
+func (br *BindRacer) SetDelay(delay time.Duration) *BindRacer {
+	br.delay = delay
+	return br
+}
+
+//SetCollectWindow sets how long to keep waiting for requests that are already
+//sent once the first mapped address comes back. The default of 0 returns as soon
+//as there is one
+func (br *BindRacer) SetCollectWindow(window time.Duration) *BindRacer {
+	br.collect = window
+	return br
+}
+
+//Race starts Binding requests to the servers one after another, alternating
+//address families starting with the family of the first server but otherwise
+//in the order given, until one answers. Requests still waiting when the race
+//ends are cancelled and left out. The results are in the order they finished,
+//including failures. An error is only returned when no server gave a mapped address
+func (br *BindRacer) Race(ctx context.Context, servers []net.Addr) ([]RaceResult, error) {
+	if len(servers) == 0 {
+		return nil, ErrNoServers
+	}
+	v4, stop := withTransactionManager(br.v4)
+	defer stop()
+	v6 := v4
+	if br.v6 != br.v4 {
+		v6, stop = withTransactionManager(br.v6)
+		defer stop()
+	}
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	servers = interleaveFamilies(servers)
+	ch := make(chan RaceResult, len(servers))
+	start := time.NewTimer(0)
+	defer start.Stop()
+	done := ctx.Done()
+	var collect <-chan time.Time
+	results := make([]RaceResult, 0, len(servers))
+	next, running, won := 0, 0, false
+	for running > 0 || (!won && next < len(servers) && rctx.Err() == nil) {
+		select {
+		case <-start.C:
+			if won || next >= len(servers) || rctx.Err() != nil {
+				continue
+			}
+			sc := v4
+			if !isIPv4Addr(servers[next]) {
+				sc = v6
+			}
+			go attempt(rctx, sc, servers[next], ch)
+			next++
+			running++
+			start.Reset(br.delay)
+		case r := <-ch:
+			running--
+			if r.Err != nil && rctx.Err() != nil {
+				continue
+			}
+			results = append(results, r)
+			if r.Err == nil && !won {
+				won = true
+				if br.collect <= 0 {
+					cancel()
+				} else {
+					collect = time.After(br.collect)
+				}
+			} else if r.Err != nil && !won {
+				start.Reset(0)
+			}
+		case <-collect:
+			cancel()
+		case <-done:
+			done = nil
+			cancel()
+		}
+	}
+	if !won {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		return results, results[len(results)-1].Err
+	}
+	return results, nil
+}
+
+func attempt(ctx context.Context, sc *StunClient, server net.Addr, ch chan RaceResult) {
+	res, err := sc.BindContext(ctx, server)
+	ch <- RaceResult{Server: server, Result: res, Err: err}
+}
+
+//withTransactionManager returns sc, or a copy of it with a TransactionManager
+//when it has none. stop waits for the TransactionManager to stop reading so the
+//socket can be used by sc again
+func withTransactionManager(sc *StunClient) (*StunClient, func()) {
+	if sc.tm != nil {
+		return sc, func() {}
+	}
+	c := *sc
+	c.tm = NewTransactionManager(sc.conn).SetLogger(sc.logger)
+	go c.tm.Serve()
+	return &c, func() {
+		c.tm.Stop()
+		<-c.tm.done
+	}
+}
+
+//interleaveFamilies reorders servers to alternate IPv6 and IPv4, starting with
+//the family of the first server
+func interleaveFamilies(servers []net.Addr) []net.Addr {
+	var first, second []net.Addr
+	firstV4 := isIPv4Addr(servers[0])
+	for _, s := range servers {
+		if isIPv4Addr(s) == firstV4 {
+			first = append(first, s)
+		} else {
+			second = append(second, s)
+		}
+	}
+	out := make([]net.Addr, 0, len(servers))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+func isIPv4Addr(addr net.Addr) bool {
+	ua := toUDPAddr(addr)
+	return ua != nil && ua.IP.To4() != nil
+}
@@ -0,0 +1,125 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRaceClient(t *testing.T) (*StunClient, *net.UDPConn) {
+	tm, conn := startManager(t, nil)
+	return NewStunClient(conn).SetRTO(50 * time.Millisecond).SetRetransmits(2).SetTransactionManager(tm), conn
+}
+
+func TestBindRacer(t *testing.T) {
+	_, s1 := startServer(t)
+	defer s1.Close()
+	_, s2 := startServer(t)
+	defer s2.Close()
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer dead.Close()
+	sc, cconn := newRaceClient(t)
+	defer cconn.Close()
+
+	//the dead server is started first, but loses and is cancelled
+	start := time.Now()
+	results, err := NewBindRacer(sc, nil).SetDelay(20*time.Millisecond).Race(context.Background(), []net.Addr{dead.LocalAddr(), s1.LocalAddr(), s2.LocalAddr()})
+	assert.NoError(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, s1.LocalAddr(), results[0].Server)
+	assert.Equal(t, cconn.LocalAddr().String(), results[0].Result.Address.String())
+
+	//with a collect window the other servers already sent to get to answer
+	results, err = NewBindRacer(sc, nil).SetDelay(0).SetCollectWindow(200*time.Millisecond).Race(context.Background(), []net.Addr{s1.LocalAddr(), s2.LocalAddr(), dead.LocalAddr()})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	for _, r := range results {
+		assert.NoError(t, r.Err)
+		assert.Greater(t, int64(r.Result.RTT), int64(0))
+	}
+}
+
+func TestBindRacerFailures(t *testing.T) {
+	failing := func(sp *StunPacket, addr net.Addr) *StunPacket {
+		return NewStunPacketBuilder().SetStunMessage(SMFailure).SetTXID(sp.GetTxID()).SetErrorCode(ECServerError, "Server Error").Build()
+	}
+	_, f1 := startManager(t, failing)
+	defer f1.Close()
+	_, f2 := startManager(t, failing)
+	defer f2.Close()
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer dead.Close()
+	sc, cconn := newRaceClient(t)
+	defer cconn.Close()
+
+	//failures start the next server without waiting for the delay
+	start := time.Now()
+	results, err := NewBindRacer(sc, sc).SetDelay(time.Hour).Race(context.Background(), []net.Addr{f1.LocalAddr(), f2.LocalAddr()})
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, &StunError{Code: ECServerError, Reason: "Server Error"}, err)
+	assert.Equal(t, 2, len(results))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	results, err = NewBindRacer(sc, sc).Race(ctx, []net.Addr{dead.LocalAddr()})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, len(results))
+
+	_, err = NewBindRacer(sc, sc).Race(context.Background(), nil)
+	assert.Equal(t, ErrNoServers, err)
+}
+
+func TestBindRacerWithoutManager(t *testing.T) {
+	//the slow answers make both requests wait on the socket at once
+	ss := NewStunServer(nil)
+	slow := func(sp *StunPacket, addr net.Addr) *StunPacket {
+		time.Sleep(50 * time.Millisecond)
+		return ss.HandlePacket(sp, addr)
+	}
+	_, s1 := startManager(t, slow)
+	defer s1.Close()
+	_, s2 := startManager(t, slow)
+	defer s2.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+
+	//the socket is left to sc again after the race
+	results, err := NewBindRacer(sc, nil).SetDelay(0).SetCollectWindow(200*time.Millisecond).Race(context.Background(), []net.Addr{s1.LocalAddr(), s2.LocalAddr()})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Nil(t, sc.tm)
+	br, err := sc.Bind(s1.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), br.Address.String())
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	a := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}
+	b := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 1}
+	c := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}
+	d := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 1}
+	e := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 3), Port: 1}
+	assert.Equal(t, []net.Addr{a, c, b, d, e}, interleaveFamilies([]net.Addr{a, b, c, d, e}))
+	assert.Equal(t, []net.Addr{c, a, d, b, e}, interleaveFamilies([]net.Addr{c, d, e, a, b}))
+}
+
+func TestBindContext(t *testing.T) {
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer dead.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	sc.SetRTO(time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = sc.BindContext(ctx, dead.LocalAddr())
+	assert.Equal(t, context.Canceled, err)
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+}
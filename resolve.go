@@ -0,0 +1,246 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//DefaultPort is the rfc5389 port for STUN over UDP and TCP
+const DefaultPort = 3478
+
+//ErrNoServers is returned when DNS says the service is not offered for a domain
+var ErrNoServers = errors.New("No Servers for Service!")
+
+//ErrUnknownTransport is returned for a transport other than udp or tcp
+var ErrUnknownTransport = errors.New("Unknown Transport!")
+
+//DNSResolver is the part of a net.Resolver used for server discovery. A
+//net.Resolver with its own Dial can be used to ask a different DNS server
+type DNSResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+//NAPTR is an rfc3403 NAPTR record
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+}
+
+//NAPTRResolver can also be implemented by a DNSResolver to use rfc5928 NAPTR
+//lookups for TURN servers. net.Resolver can not look up NAPTR records so without
+//it TURN servers are only found with SRV
+type NAPTRResolver interface {
+	LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error)
+}
+
+//ServerTarget is one place to try to reach a STUN or TURN server, in the order
+//they should be tried
+type ServerTarget struct {
+	Host      string
+	Port      int
+	Transport string
+	Secure    bool
+}
+
+//Addr returns the host:port of the ServerTarget to dial
+func (st ServerTarget) Addr() string {
+	return net.JoinHostPort(st.Host, strconv.Itoa(st.Port))
+}
+
+//ServerResolver finds STUN and TURN servers for a domain using rfc5389 SRV and
+//rfc5928 NAPTR records, falling back to the default ports on the domain itself
+type ServerResolver struct {
+	resolver DNSResolver
+}
+
+//NewServerResolver creates a ServerResolver, a nil DNSResolver uses net.DefaultResolver
+func NewServerResolver(resolver DNSResolver) *ServerResolver {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &ServerResolver{resolver: resolver}
+}
+
+//LookupStun finds the STUN servers for host on the transport, "udp" or "tcp".
+//Secure looks up stuns servers, TLS for tcp or DTLS for udp. If host is an IP
+//address or already has a port no lookup is done
+func (sr *ServerResolver) LookupStun(ctx context.Context, host, transport string, secure bool) ([]ServerTarget, error) {
+	return sr.lookup(ctx, "stun", host, transport, secure)
+}
+
+//LookupTurn finds the TURN servers for host. With no transport, NAPTR records
+//pick the transports if the DNSResolver supports them, otherwise udp then tcp
+//are tried, or just tcp when secure
+func (sr *ServerResolver) LookupTurn(ctx context.Context, host, transport string, secure bool) ([]ServerTarget, error) {
+	if transport != "" {
+		return sr.lookup(ctx, "turn", host, transport, secure)
+	}
+	if st, ok := literalTarget(host, "udp", secure); ok {
+		if secure {
+			st.Transport = "tcp"
+		}
+		return []ServerTarget{st}, nil
+	}
+	if nr, ok := sr.resolver.(NAPTRResolver); ok {
+		if sts := sr.lookupNAPTR(ctx, nr, host, secure); len(sts) > 0 {
+			return sts, nil
+		}
+	}
+	transports := []string{"udp", "tcp"}
+	if secure {
+		transports = []string{"tcp"}
+	}
+	sts := make([]ServerTarget, 0)
+	for _, t := range transports {
+		tsts, err := sr.lookup(ctx, "turn", host, t, secure)
+		if err != nil && err != ErrNoServers {
+			return nil, err
+		}
+		sts = append(sts, tsts...)
+	}
+	if len(sts) == 0 {
+		return nil, ErrNoServers
+	}
+	return sts, nil
+}
+
+func (sr *ServerResolver) lookup(ctx context.Context, service, host, transport string, secure bool) ([]ServerTarget, error) {
+	if transport != "udp" && transport != "tcp" {
+		return nil, ErrUnknownTransport
+	}
+	if st, ok := literalTarget(host, transport, secure); ok {
+		return []ServerTarget{st}, nil
+	}
+	if secure {
+		service += "s"
+	}
+	_, srvs, err := sr.resolver.LookupSRV(ctx, service, transport, host)
+	if err != nil || len(srvs) == 0 {
+		//rfc5389 section 9, with no SRV records the domain is used on the default port
+		return []ServerTarget{defaultTarget(host, transport, secure)}, nil
+	}
+	sts := srvTargets(orderSRV(srvs), transport, secure)
+	if len(sts) == 0 {
+		return nil, ErrNoServers
+	}
+	return sts, nil
+}
+
+var naptrServices = map[string]struct {
+	transport string
+	secure    bool
+}{
+	"RELAY:turn.udp":  {"udp", false},
+	"RELAY:turn.tcp":  {"tcp", false},
+	"RELAYS:turn.tcp": {"tcp", true},
+	"RELAYS:turn.udp": {"udp", true},
+}
+
+func (sr *ServerResolver) lookupNAPTR(ctx context.Context, nr NAPTRResolver, host string, secure bool) []ServerTarget {
+	naptrs, err := nr.LookupNAPTR(ctx, host)
+	if err != nil {
+		return nil
+	}
+	naptrs = append([]*NAPTR(nil), naptrs...)
+	sort.SliceStable(naptrs, func(i, j int) bool {
+		if naptrs[i].Order != naptrs[j].Order {
+			return naptrs[i].Order < naptrs[j].Order
+		}
+		return naptrs[i].Preference < naptrs[j].Preference
+	})
+	sts := make([]ServerTarget, 0)
+	for _, n := range naptrs {
+		svc, ok := naptrServices[n.Service]
+		if !ok || svc.secure != secure || !strings.EqualFold(n.Flags, "s") {
+			continue
+		}
+		_, srvs, err := sr.resolver.LookupSRV(ctx, "", "", n.Replacement)
+		if err != nil {
+			continue
+		}
+		sts = append(sts, srvTargets(orderSRV(srvs), svc.transport, secure)...)
+	}
+	return sts
+}
+
+func literalTarget(host, transport string, secure bool) (ServerTarget, bool) {
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		if port, err := strconv.Atoi(p); err == nil {
+			return ServerTarget{Host: h, Port: port, Transport: transport, Secure: secure}, true
+		}
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return defaultTarget(strings.Trim(host, "[]"), transport, secure), true
+	}
+	return ServerTarget{}, false
+}
+
+func defaultTarget(host, transport string, secure bool) ServerTarget {
+	port := DefaultPort
+	if secure {
+		port = DefaultTLSPort
+	}
+	return ServerTarget{Host: host, Port: port, Transport: transport, Secure: secure}
+}
+
+func srvTargets(srvs []*net.SRV, transport string, secure bool) []ServerTarget {
+	sts := make([]ServerTarget, 0, len(srvs))
+	for _, srv := range srvs {
+		//rfc2782, a target of "." means the service is not offered
+		if srv.Target == "." || srv.Target == "" {
+			continue
+		}
+		sts = append(sts, ServerTarget{Host: strings.TrimSuffix(srv.Target, "."), Port: int(srv.Port), Transport: transport, Secure: secure})
+	}
+	return sts
+}
+
+//orderSRV sorts SRV records by priority and then picks the order of each
+//priority with rfc2782 weighted random selection
+func orderSRV(srvs []*net.SRV) []*net.SRV {
+	srvs = append([]*net.SRV(nil), srvs...)
+	sort.SliceStable(srvs, func(i, j int) bool {
+		if srvs[i].Priority != srvs[j].Priority {
+			return srvs[i].Priority < srvs[j].Priority
+		}
+		return srvs[i].Weight == 0 && srvs[j].Weight != 0
+	})
+	for i := 0; i < len(srvs); {
+		j := i
+		for j < len(srvs) && srvs[j].Priority == srvs[i].Priority {
+			j++
+		}
+		weightShuffle(srvs[i:j])
+		i = j
+	}
+	return srvs
+}
+
+func weightShuffle(srvs []*net.SRV) {
+	sum := 0
+	for _, srv := range srvs {
+		sum += int(srv.Weight)
+	}
+	for len(srvs) > 1 && sum > 0 {
+		pick := rand.Intn(sum + 1)
+		run := 0
+		for i, srv := range srvs {
+			run += int(srv.Weight)
+			if run >= pick {
+				srvs[0], srvs[i] = srvs[i], srvs[0]
+				break
+			}
+		}
+		sum -= int(srvs[0].Weight)
+		srvs = srvs[1:]
+	}
+}
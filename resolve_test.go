@@ -0,0 +1,198 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDNS struct {
+	srv   map[string][]*net.SRV
+	naptr map[string][]*NAPTR
+}
+
+func (fd *fakeDNS) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if service != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	srvs, ok := fd.srv[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, srvs, nil
+}
+
+func (fd *fakeDNS) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error) {
+	naptrs, ok := fd.naptr[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return naptrs, nil
+}
+
+//stubDNS answers SRV questions over a net.Pipe, which the go resolver treats
+//as a tcp connection
+func stubDNS(records map[string][]*net.SRV) *net.Resolver {
+	return &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			for {
+				var l [2]byte
+				if _, err := io.ReadFull(server, l[:]); err != nil {
+					return
+				}
+				q := make([]byte, binary.BigEndian.Uint16(l[:]))
+				if _, err := io.ReadFull(server, q); err != nil {
+					return
+				}
+				labels := make([]string, 0)
+				pos := 12
+				for q[pos] != 0 {
+					labels = append(labels, string(q[pos+1:pos+1+int(q[pos])]))
+					pos += int(q[pos]) + 1
+				}
+				qend := pos + 5
+				srvs := records[strings.Join(labels, ".")+"."]
+				if binary.BigEndian.Uint16(q[pos+1:]) != 33 {
+					srvs = nil
+				}
+				resp := append([]byte{q[0], q[1], 0x84, 0x00, 0, 1, 0, byte(len(srvs)), 0, 0, 0, 0}, q[12:qend]...)
+				if len(srvs) == 0 {
+					resp[3] = 3
+				}
+				for _, srv := range srvs {
+					rdata := make([]byte, 6)
+					binary.BigEndian.PutUint16(rdata[0:], srv.Priority)
+					binary.BigEndian.PutUint16(rdata[2:], srv.Weight)
+					binary.BigEndian.PutUint16(rdata[4:], srv.Port)
+					for _, label := range strings.Split(strings.TrimSuffix(srv.Target, "."), ".") {
+						rdata = append(append(rdata, byte(len(label))), label...)
+					}
+					rdata = append(rdata, 0)
+					resp = append(resp, 0xc0, 12, 0, 33, 0, 1, 0, 0, 0, 60, byte(len(rdata)>>8), byte(len(rdata)))
+					resp = append(resp, rdata...)
+				}
+				binary.BigEndian.PutUint16(l[:], uint16(len(resp)))
+				if _, err := server.Write(append(l[:], resp...)); err != nil {
+					return
+				}
+			}
+		}()
+		return client, nil
+	}}
+}
+
+func TestLookupStun(t *testing.T) {
+	sr := NewServerResolver(stubDNS(map[string][]*net.SRV{
+		"_stun._udp.example.com.": {
+			{Target: "backup.example.com.", Port: 3479, Priority: 20},
+			{Target: "stun1.example.com.", Port: 3478, Priority: 10, Weight: 100},
+			{Target: "stun2.example.com.", Port: 3480, Priority: 10},
+		},
+		"_stuns._tcp.example.com.":     {{Target: "tls.example.com.", Port: 443, Priority: 10}},
+		"_stun._udp.gone.example.com.": {{Target: ".", Port: 0}},
+	}))
+	ctx := context.Background()
+
+	sts, err := sr.LookupStun(ctx, "example.com", "udp", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(sts))
+	assert.Equal(t, "backup.example.com:3479", sts[2].Addr())
+	assert.ElementsMatch(t, []string{"stun1.example.com:3478", "stun2.example.com:3480"}, []string{sts[0].Addr(), sts[1].Addr()})
+
+	sts, err = sr.LookupStun(ctx, "example.com", "tcp", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []ServerTarget{{Host: "tls.example.com", Port: 443, Transport: "tcp", Secure: true}}, sts)
+
+	//no records falls back to the default ports
+	sts, err = sr.LookupStun(ctx, "other.example.com", "tcp", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []ServerTarget{{Host: "other.example.com", Port: DefaultPort, Transport: "tcp"}}, sts)
+	sts, err = sr.LookupStun(ctx, "other.example.com", "udp", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "other.example.com:5349", sts[0].Addr())
+
+	_, err = sr.LookupStun(ctx, "gone.example.com", "udp", false)
+	assert.Equal(t, ErrNoServers, err)
+	_, err = sr.LookupStun(ctx, "example.com", "sctp", false)
+	assert.Equal(t, ErrUnknownTransport, err)
+
+	//literals and explicit ports skip DNS
+	sts, _ = sr.LookupStun(ctx, "example.com:5000", "udp", false)
+	assert.Equal(t, "example.com:5000", sts[0].Addr())
+	sts, _ = sr.LookupStun(ctx, "2001:db8::1", "udp", false)
+	assert.Equal(t, "[2001:db8::1]:3478", sts[0].Addr())
+}
+
+func TestOrderSRV(t *testing.T) {
+	srvs := []*net.SRV{
+		{Target: "c", Priority: 2, Weight: 1},
+		{Target: "a", Priority: 1, Weight: 0},
+		{Target: "b", Priority: 1, Weight: 0},
+	}
+	for i := 0; i < 20; i++ {
+		o := orderSRV(srvs)
+		assert.Equal(t, "a", o[0].Target)
+		assert.Equal(t, "b", o[1].Target)
+		assert.Equal(t, "c", o[2].Target)
+	}
+	heavy := 0
+	for i := 0; i < 200; i++ {
+		o := orderSRV([]*net.SRV{{Target: "light", Weight: 1}, {Target: "heavy", Weight: 99}})
+		if o[0].Target == "heavy" {
+			heavy++
+		}
+	}
+	assert.Greater(t, heavy, 150)
+}
+
+func TestLookupTurn(t *testing.T) {
+	fd := &fakeDNS{
+		srv: map[string][]*net.SRV{
+			"_turn._udp.example.com":  {{Target: "udp.example.com.", Port: 3478}},
+			"_turn._tcp.example.com":  {{Target: "tcp.example.com.", Port: 3478}},
+			"_turns._tcp.example.com": {{Target: "tls.example.com.", Port: 5349}},
+			"_turn._tcp.naptr.test":   {{Target: "relay-tcp.naptr.test.", Port: 80}},
+			"_turns._tcp.naptr.test":  {{Target: "relay-tls.naptr.test.", Port: 443}},
+		},
+		naptr: map[string][]*NAPTR{
+			"naptr.test": {
+				{Order: 10, Preference: 20, Flags: "s", Service: "RELAYS:turn.tcp", Replacement: "_turns._tcp.naptr.test"},
+				{Order: 10, Preference: 10, Flags: "S", Service: "RELAY:turn.tcp", Replacement: "_turn._tcp.naptr.test"},
+				{Order: 5, Preference: 10, Flags: "s", Service: "SIP+D2U", Replacement: "_sip._udp.naptr.test"},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	//with NAPTR records the transport comes from the record
+	sts, err := NewServerResolver(fd).LookupTurn(ctx, "naptr.test", "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []ServerTarget{{Host: "relay-tcp.naptr.test", Port: 80, Transport: "tcp"}}, sts)
+	sts, err = NewServerResolver(fd).LookupTurn(ctx, "naptr.test", "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []ServerTarget{{Host: "relay-tls.naptr.test", Port: 443, Transport: "tcp", Secure: true}}, sts)
+
+	//without NAPTR udp then tcp SRV records are used
+	sts, err = NewServerResolver(fd).LookupTurn(ctx, "example.com", "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"udp.example.com:3478", "tcp.example.com:3478"}, []string{sts[0].Addr(), sts[1].Addr()})
+	sts, err = NewServerResolver(fd).LookupTurn(ctx, "example.com", "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []ServerTarget{{Host: "tls.example.com", Port: 5349, Transport: "tcp", Secure: true}}, sts)
+
+	sts, err = NewServerResolver(fd).LookupTurn(ctx, "example.com", "tcp", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(sts))
+	assert.Equal(t, "tcp.example.com:3478", sts[0].Addr())
+
+	sts, err = NewServerResolver(fd).LookupTurn(ctx, "192.0.2.1", "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []ServerTarget{{Host: "192.0.2.1", Port: 5349, Transport: "tcp", Secure: true}}, sts)
+}
@@ -0,0 +1,414 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//DefaultIdleTimeout is how long a StunServer keeps a TCP, TLS or DTLS connection
+//that sends nothing
+const DefaultIdleTimeout = 10 * time.Minute
+
+var (
+	ErrRateLimited   = errors.New("Rate Limited!")
+	ErrAmplification = errors.New("Response is larger than the Request!")
+)
+
+//ServerStats are the packet counters of a StunServer
+type ServerStats struct {
+	Received uint64
+	Answered uint64
+	//Invalid is how many packets were dropped because they were not StunPackets
+	Invalid uint64
+	//Limited is how many packets were dropped by the Limiter
+	Limited uint64
+	//Amplification is how many responses were dropped for being larger than the request
+	Amplification uint64
+}
+
+//StunServer answers stun Binding requests on a net.PacketConn
+type StunServer struct {
+	//stats is first so its uint64s are aligned for atomic on 32bit platforms
+	stats       ServerStats
+	conn        net.PacketConn
+	changePort  net.PacketConn
+	changeIP    net.PacketConn
+	changeBoth  net.PacketConn
+	software    string
+	alternate   *net.UDPAddr
+	altDomain   string
+	maxLoad     int
+	auth        *longTermAuth
+	limiter     Limiter
+	noAmplify   bool
+	fpPolicy    FingerPrintPolicy
+	hooks       Hooks
+	logger      *slog.Logger
+	lock        sync.Mutex
+	loadStart   time.Time
+	loadCount   int
+	idleTimeout time.Duration
+	//err is the first invalid setting, it is returned by the Serve methods
+	err error
+}
+
+//NewStunServer creates a StunServer that will answer requests on the provided net.PacketConn
+func NewStunServer(conn net.PacketConn) *StunServer {
+	return &StunServer{
+		conn:        conn,
+		maxLoad:     -1,
+		hooks:       NoopHooks{},
+		idleTimeout: DefaultIdleTimeout,
+	}
+}
+
+//NewStunServers creates the 4 StunServers needed for rfc5780 NAT discovery, one for
+//each ip/port combination, each set up to answer SAChangeRequests from the others.
+//The servers on the alternate ip can be nil when there is only one ip, in which case
+//only SAChangeRequests for the port can be answered. Serve must be called on each one
+func NewStunServers(ip1port1, ip1port2, ip2port1, ip2port2 net.PacketConn) []*StunServer {
+	conns := [2][2]net.PacketConn{{ip1port1, ip1port2}, {ip2port1, ip2port2}}
+	servers := make([]*StunServer, 0, 4)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			if conns[i][j] == nil {
+				continue
+			}
+			ss := NewStunServer(conns[i][j])
+			ss.SetChangeConns(conns[i][1-j], conns[1-i][j], conns[1-i][1-j])
+			servers = append(servers, ss)
+		}
+	}
+	return servers
+}
+
+//SetChangeConns sets the net.PacketConns used to answer rfc5780 SAChangeRequests.
+//port is on the same ip and an alternate port, ip is on the alternate ip and the
+//same port and both is the alternate ip and alternate port. When both is set
+//responses will include an SAOtherAddress and SAResponseOrigin
+func (ss *StunServer) SetChangeConns(port, ip, both net.PacketConn) *StunServer {
+	ss.changePort = port
+	ss.changeIP = ip
+	ss.changeBoth = both
+	return ss
+}
+
+//SetSoftware sets the SASoftware added to every response. It must be less than
+//128 characters, when it is not the Serve methods return the error
+func (ss *StunServer) SetSoftware(software string) *StunServer {
+	if err := checkText(software, maxTextChars, maxTextBytes); err != nil {
+		if ss.err == nil {
+			ss.err = err
+		}
+		return ss
+	}
+	ss.software = software
+	return ss
+}
+
+//SetAlternateServer sets where clients are sent with a 300 Try Alternate
+//once this server is over its max load. The domain is optional and is
+//only needed when the alternate server is using TLS/DTLS
+func (ss *StunServer) SetAlternateServer(ua *net.UDPAddr, domain string) *StunServer {
+	ss.alternate = ua
+	ss.altDomain = domain
+	return ss
+}
+
+//SetMaxLoad sets how many requests per second are answered before the rest are
+//redirected to the AlternateServer. 0 redirects every request, and a negative
+//value (the default) never redirects
+func (ss *StunServer) SetMaxLoad(rps int) *StunServer {
+	ss.maxLoad = rps
+	return ss
+}
+
+//SetLimiter sets the Limiter checked before answering any request, nil disables it
+func (ss *StunServer) SetLimiter(l Limiter) *StunServer {
+	ss.limiter = l
+	return ss
+}
+
+//SetNoAmplification drops any UDP response that would be larger than its request,
+//so the server can not be used to amplify reflection attacks. Clients then need
+//to pad their requests to get an answer
+func (ss *StunServer) SetNoAmplification(na bool) *StunServer {
+	ss.noAmplify = na
+	return ss
+}
+
+//SetFingerPrintPolicy sets how the SAFingerPrint of requests is checked, the
+//default is FingerPrintVerify. Requests that fail are dropped and counted as Invalid
+func (ss *StunServer) SetFingerPrintPolicy(fpp FingerPrintPolicy) *StunServer {
+	ss.fpPolicy = fpp
+	return ss
+}
+
+//SetHooks sets the Hooks called as requests are handled, nil removes them
+func (ss *StunServer) SetHooks(hooks Hooks) *StunServer {
+	if hooks == nil {
+		hooks = NoopHooks{}
+	}
+	ss.hooks = hooks
+	return ss
+}
+
+//SetLogger sets the slog.Logger used to log dropped packets and failed requests, nil disables logging
+func (ss *StunServer) SetLogger(logger *slog.Logger) *StunServer {
+	ss.logger = logger
+	return ss
+}
+
+//SetIdleTimeout sets how long a connection from ServeStream or ServeDTLS can go
+//without sending anything before it is closed, 0 never closes them. The default
+//is DefaultIdleTimeout
+func (ss *StunServer) SetIdleTimeout(d time.Duration) *StunServer {
+	ss.idleTimeout = d
+	return ss
+}
+
+//idleDeadline is the read deadline for a connection from ServeStream or ServeDTLS
+func (ss *StunServer) idleDeadline() time.Time {
+	if ss.idleTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ss.idleTimeout)
+}
+
+//Stats returns the current packet counters
+func (ss *StunServer) Stats() ServerStats {
+	return ServerStats{
+		Received:      atomic.LoadUint64(&ss.stats.Received),
+		Answered:      atomic.LoadUint64(&ss.stats.Answered),
+		Invalid:       atomic.LoadUint64(&ss.stats.Invalid),
+		Limited:       atomic.LoadUint64(&ss.stats.Limited),
+		Amplification: atomic.LoadUint64(&ss.stats.Amplification),
+	}
+}
+
+//Serve reads from the net.PacketConn and answers requests until a read fails
+func (ss *StunServer) Serve() error {
+	if ss.err != nil {
+		return ss.err
+	}
+	ba := make([]byte, 65536)
+	for {
+		n, addr, err := ss.conn.ReadFrom(ba)
+		if err != nil {
+			return err
+		}
+		atomic.AddUint64(&ss.stats.Received, 1)
+		sp, err := NewStunPacket(ba[:n])
+		if err != nil {
+			atomic.AddUint64(&ss.stats.Invalid, 1)
+			ss.hooks.OnError(err, addr)
+			logPacket(ss.logger, slog.LevelDebug, "Dropped invalid packet", nil, addr, errAttr(err))
+			continue
+		}
+		resp, conn := ss.handle(sp, addr)
+		if resp == nil {
+			continue
+		}
+		dst := addr
+		if port, err := sp.GetResponsePort(); err == nil {
+			if ua := toUDPAddr(addr); ua != nil {
+				dst = &net.UDPAddr{IP: ua.IP, Port: port, Zone: ua.Zone}
+			}
+		}
+		conn.WriteTo(resp.GetBytes(), dst)
+	}
+}
+
+//ServeStream accepts connections from a stream net.Listener, like TCP or TLS,
+//and answers the StunPackets framed on them until Accept fails
+func (ss *StunServer) ServeStream(l net.Listener) error {
+	if ss.err != nil {
+		return ss.err
+	}
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go ss.serveConn(c)
+	}
+}
+
+func (ss *StunServer) serveConn(c net.Conn) {
+	defer c.Close()
+	for {
+		c.SetReadDeadline(ss.idleDeadline())
+		sp, err := ReadStunPacket(c)
+		if err != nil {
+			return
+		}
+		atomic.AddUint64(&ss.stats.Received, 1)
+		resp := ss.HandlePacket(sp, c.RemoteAddr())
+		if resp != nil {
+			if _, err := c.Write(resp.GetBytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+//HandlePacket creates the response for a StunPacket received from addr.
+//nil is returned when nothing should be sent back. The response should be sent
+//to the port in any SAResponsePort of the request, which Serve does
+func (ss *StunServer) HandlePacket(sp *StunPacket, addr net.Addr) *StunPacket {
+	resp, _ := ss.handle(sp, addr)
+	return resp
+}
+
+func (ss *StunServer) handle(sp *StunPacket, addr net.Addr) (*StunPacket, net.PacketConn) {
+	if sp.GetStunMessageType() != SMRequest {
+		return nil, nil
+	}
+	ua := toUDPAddr(addr)
+	if ua == nil {
+		return nil, nil
+	}
+	start := time.Now()
+	ss.hooks.OnRequest(sp, addr)
+	if err := ss.fpPolicy.checkRequest(sp); err != nil {
+		atomic.AddUint64(&ss.stats.Invalid, 1)
+		ss.hooks.OnError(err, addr)
+		logPacket(ss.logger, slog.LevelDebug, "Dropped request", sp, addr, errAttr(err))
+		return nil, nil
+	}
+	if ss.limiter != nil && !ss.limiter.Allow(addr) {
+		atomic.AddUint64(&ss.stats.Limited, 1)
+		ss.hooks.OnError(ErrRateLimited, addr)
+		logPacket(ss.logger, slog.LevelDebug, "Dropped request", sp, addr, errAttr(ErrRateLimited))
+		return nil, nil
+	}
+	resp, conn := ss.respond(sp, ua)
+	if _, stream := addr.(*net.TCPAddr); ss.noAmplify && !stream && len(resp.GetBytes()) > len(sp.GetBytes()) {
+		atomic.AddUint64(&ss.stats.Amplification, 1)
+		ss.hooks.OnError(ErrAmplification, addr)
+		logPacket(ss.logger, slog.LevelDebug, "Dropped response", resp, addr, errAttr(ErrAmplification))
+		return nil, nil
+	}
+	atomic.AddUint64(&ss.stats.Answered, 1)
+	if resp.GetStunMessageType() == SMFailure {
+		logPacket(ss.logger, slog.LevelDebug, "Sent error response", resp, addr)
+	}
+	ss.hooks.OnResponse(resp, addr, time.Since(start))
+	return resp, conn
+}
+
+func (ss *StunServer) respond(sp *StunPacket, ua *net.UDPAddr) (*StunPacket, net.PacketConn) {
+	spb := sp.ToBuilder().ClearAttributes().AddFingerprint(sp.HasFingerPrint())
+	if ss.software != "" {
+		spb.SetSoftware(ss.software)
+	}
+	if ss.overLoad() {
+		spb.SetStunMessage(SMFailure).SetErrorCode(ECTryAlternate, "Try Alternate")
+		spb.SetAlternateServer(ss.alternate)
+		if ss.altDomain != "" {
+			spb.SetAlternateDomain(ss.altDomain)
+		}
+		return spb.Build(), ss.conn
+	}
+	if ss.auth != nil {
+		key, failed := ss.auth.check(sp, spb)
+		if failed {
+			resp := spb.Build()
+			if sp.HasMessageIntegrity() {
+				logPacket(ss.logger, slog.LevelInfo, "Authentication failed", resp, ua)
+			}
+			return resp, ss.conn
+		}
+		spb.SetMessageIntegrity(key)
+	}
+	conn := ss.conn
+	if ba := sp.GetAttribute(SAChangeRequest); len(ba) == 4 {
+		flags := binary.BigEndian.Uint32(ba)
+		switch flags & (ChangeIP | ChangePort) {
+		case ChangeIP | ChangePort:
+			conn = ss.changeBoth
+		case ChangeIP:
+			conn = ss.changeIP
+		case ChangePort:
+			conn = ss.changePort
+		}
+		if conn == nil {
+			spb.SetStunMessage(SMFailure).SetErrorCode(ECUnknownAttribute, "Unknown Attribute")
+			spb.SetValue(SAUnknownAttribute, []StunAttribute{SAChangeRequest})
+			return spb.Build(), ss.conn
+		}
+	}
+	spb.SetStunMessage(SMSuccess).SetXORAddress(ua)
+	if ss.changeBoth != nil {
+		if origin := toUDPAddr(conn.LocalAddr()); origin != nil {
+			spb.SetValue(SAResponseOrigin, origin)
+		}
+		if other := toUDPAddr(ss.changeBoth.LocalAddr()); other != nil {
+			spb.SetValue(SAOtherAddress, other)
+		}
+	}
+	resp := spb.Build()
+	if sp.GetAttribute(SAPadding) != nil {
+		//pad the response up to the size of the request, but never past it
+		if extra := len(sp.GetBytes()) - len(resp.GetBytes()) - 4; extra > 0 {
+			resp = spb.SetPadding(extra &^ 3).Build()
+		}
+	}
+	return resp, conn
+}
+
+func (ss *StunServer) overLoad() bool {
+	if ss.alternate == nil || ss.maxLoad < 0 {
+		return false
+	}
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	now := time.Now()
+	if now.Sub(ss.loadStart) >= time.Second {
+		ss.loadStart = now
+		ss.loadCount = 0
+	}
+	ss.loadCount++
+	return ss.loadCount > ss.maxLoad
+}
+
+//ReadStunPacket reads one StunPacket from a stream, like TCP or TLS, where
+//packets are sent back to back using the length in the header for framing
+func ReadStunPacket(r io.Reader) (*StunPacket, error) {
+	ba, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewStunPacket(ba)
+}
+
+//readFrame reads the bytes of one STUN message from a stream without parsing it
+func readFrame(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 20)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(hdr[2:4]))
+	ba := make([]byte, 20+size)
+	copy(ba, hdr)
+	if _, err := io.ReadFull(r, ba[20:]); err != nil {
+		return nil, err
+	}
+	return ba, nil
+}
+
+func toUDPAddr(addr net.Addr) *net.UDPAddr {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a
+	case *net.TCPAddr:
+		return &net.UDPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}
+	}
+	return nil
+}
@@ -2,23 +2,21 @@
 package stunlib // import "github.com/lwahlmeier/stunlib"
 
 import (
-	crypto_rand "crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 )
 
-func init() {
-	X := make([]byte, 8)
-	crypto_rand.Read(X)
-	rand.Seed(int64(binary.BigEndian.Uint64(X)))
-}
-
 type StunMessage uint16
 type StunAttribute uint16
 
+//StunMethod is the method part of a StunMessage, like Binding or Allocate
+type StunMethod uint16
+
+//StunClass is the class part of a StunMessage, request, indication, success or error
+type StunClass uint16
+
 const (
 	SMRequest    StunMessage = 0x0001
 	SMSuccess    StunMessage = 0x0101
@@ -30,6 +28,7 @@ const (
 	SAChangeRequest    StunAttribute = 0x0003
 	SASourceAddress    StunAttribute = 0x0004
 	SAChangedRequest   StunAttribute = 0x0005
+	SAChangedAddress   StunAttribute = 0x0005
 	SAUsername         StunAttribute = 0x0006
 	SAPassword         StunAttribute = 0x0007
 	SAMessageIntegrity StunAttribute = 0x0008
@@ -38,20 +37,95 @@ const (
 	SAUnknownAttribute StunAttribute = 0x000a
 	SAReflectedFrom    StunAttribute = 0x000b
 
-	SARealm StunAttribute = 0x0014
-	SANonce StunAttribute = 0x0015
+	SAChannelNumber      StunAttribute = 0x000c
+	SALifetime           StunAttribute = 0x000d
+	SAXORPeerAddress     StunAttribute = 0x0012
+	SAData               StunAttribute = 0x0013
+	SARealm              StunAttribute = 0x0014
+	SANonce              StunAttribute = 0x0015
+	SAXORRelayedAddress  StunAttribute = 0x0016
+	SAEvenPort           StunAttribute = 0x0018
+	SARequestedTransport StunAttribute = 0x0019
+	SADontFragment       StunAttribute = 0x001a
+	SAReservationToken   StunAttribute = 0x0022
 
 	SAXORMappedAddress StunAttribute = 0x0020
 	SAPriority         StunAttribute = 0x0024
 	SAUseCandidate     StunAttribute = 0x0025
+	SAPadding          StunAttribute = 0x0026
+	SAResponsePort     StunAttribute = 0x0027
+	SAConnectionID     StunAttribute = 0x002a
 
+	SAAlternateDomain StunAttribute = 0x8003
 	SASoftware        StunAttribute = 0x8022
 	SAAlternateServer StunAttribute = 0x8023
 	SAFingerPrint     StunAttribute = 0x8028
 	SAIceControlled   StunAttribute = 0x8029
 	SAIceControlling  StunAttribute = 0x802a
+	SAResponseOrigin  StunAttribute = 0x802b
+	SAOtherAddress    StunAttribute = 0x802c
+	SAMobilityTicket  StunAttribute = 0x8030
+)
+
+const (
+	MethodBinding           StunMethod = 0x001
+	MethodAllocate          StunMethod = 0x003
+	MethodRefresh           StunMethod = 0x004
+	MethodSend              StunMethod = 0x006
+	MethodData              StunMethod = 0x007
+	MethodCreatePermission  StunMethod = 0x008
+	MethodChannelBind       StunMethod = 0x009
+	MethodConnect           StunMethod = 0x00a
+	MethodConnectionBind    StunMethod = 0x00b
+	MethodConnectionAttempt StunMethod = 0x00c
+
+	ClassRequest    StunClass = 0x000
+	ClassIndication StunClass = 0x010
+	ClassSuccess    StunClass = 0x100
+	ClassFailure    StunClass = 0x110
+)
+
+const (
+	ECTryAlternate               = 300
+	ECBadRequest                 = 400
+	ECUnauthorized               = 401
+	ECForbidden                  = 403
+	ECMobilityForbidden          = 405
+	ECUnknownAttribute           = 420
+	ECAllocationMismatch         = 437
+	ECStaleNonce                 = 438
+	ECWrongCredentials           = 441
+	ECUnsupportedTransport       = 442
+	ECConnectionAlreadyExists    = 446
+	ECConnectionTimeoutOrFailure = 447
+	ECAllocationQuotaReached     = 486
+	ECServerError                = 500
+	ECInsufficientCapacity       = 508
+)
+
+const (
+	//SAChangeRequest flags from rfc5780
+	ChangeIP   uint32 = 0x04
+	ChangePort uint32 = 0x02
 )
 
+//NewStunMessage puts a StunMethod and StunClass together into a StunMessage
+func NewStunMessage(method StunMethod, class StunClass) StunMessage {
+	m := uint16(method)
+	return StunMessage((m & 0x000f) | ((m & 0x0070) << 1) | ((m & 0x0f80) << 2) | uint16(class))
+}
+
+//Method returns the StunMethod of this StunMessage
+func (sm StunMessage) Method() StunMethod {
+	m := uint16(sm)
+	return StunMethod((m & 0x000f) | ((m & 0x00e0) >> 1) | ((m & 0x3e00) >> 2))
+}
+
+//Class returns the StunClass of this StunMessage
+func (sm StunMessage) Class() StunClass {
+	return StunClass(uint16(sm) & 0x0110)
+}
+
 func SAOptional(sa StunAttribute) bool {
 	return sa&0x8000 == 0
 }
@@ -69,11 +143,9 @@ func NewTID(ba []byte) (*TransactionID, error) {
 	return &TransactionID{tid: ba}, nil
 }
 
-//CreateTID will create a new Random TransactionID
+//CreateTID will create a new Random TransactionID using the DefaultTIDGenerator
 func CreateTID() *TransactionID {
-	ba := make([]byte, 12)
-	rand.Read(ba)
-	return &TransactionID{tid: ba}
+	return DefaultTIDGenerator.CreateTID()
 }
 
 //UnMaskAddress uses this TransactionID to unmask an SAXORMappedAddress
@@ -96,6 +168,9 @@ func (tid *TransactionID) String() string {
 	return fmt.Sprintf("%X", tid.tid)
 }
 
+//ErrNotStunPacket is returned by NewStunPacket for data that is not a StunPacket
+var ErrNotStunPacket = errors.New("Not a valid stun packet!")
+
 type StunPacket struct {
 	buffer []byte
 }
@@ -103,11 +178,33 @@ type StunPacket struct {
 //NewStunPacket create a new StunPacket from the provided []byte
 func NewStunPacket(b []byte) (*StunPacket, error) {
 	if !IsStunPacket(b) {
-		return nil, errors.New("Not a valid stun packet!")
+		return nil, ErrNotStunPacket
+	}
+	if err := checkAttributes(b); err != nil {
+		return nil, err
 	}
 	return &StunPacket{buffer: b}, nil
 }
 
+//ErrInvalidAttributeSize is returned when an attribute runs past the end of the packet
+var ErrInvalidAttributeSize = errors.New("Attribute is larger than the Packet!")
+
+//checkAttributes makes sure every attribute header and value fits in the packet
+func checkAttributes(ba []byte) error {
+	pos := 20
+	for pos < len(ba) {
+		if pos+4 > len(ba) {
+			return ErrInvalidAttributeSize
+		}
+		s := int(binary.BigEndian.Uint16(ba[pos+2 : pos+4]))
+		if pos+4+s > len(ba) {
+			return ErrInvalidAttributeSize
+		}
+		pos = ((pos + s + 4 + 3) & ^3)
+	}
+	return nil
+}
+
 //GetStunMessageType will return the current StunMessage for this StunPacket
 func (sp *StunPacket) GetStunMessageType() StunMessage {
 	return StunMessage(binary.BigEndian.Uint16(sp.buffer[:2]))
@@ -117,9 +214,12 @@ func (sp *StunPacket) GetStunMessageType() StunMessage {
 func (sp *StunPacket) GetAllAttributes() []StunAttribute {
 	sas := make([]StunAttribute, 0)
 	pos := 20
-	for pos < len(sp.buffer) {
+	for pos+4 <= len(sp.buffer) {
 		t := binary.BigEndian.Uint16(sp.buffer[pos : pos+2])
 		s := int(binary.BigEndian.Uint16(sp.buffer[pos+2 : pos+4]))
+		if pos+4+s > len(sp.buffer) {
+			break
+		}
 		sas = append(sas, StunAttribute(t))
 		pos = ((pos + s + 4 + 3) & ^3)
 	}
@@ -129,9 +229,12 @@ func (sp *StunPacket) GetAllAttributes() []StunAttribute {
 //GetAttribute returns the []byte for a given StunAttribute
 func (sp *StunPacket) GetAttribute(sa StunAttribute) []byte {
 	pos := 20
-	for pos < len(sp.buffer) {
+	for pos+4 <= len(sp.buffer) {
 		t := StunAttribute(binary.BigEndian.Uint16(sp.buffer[pos : pos+2]))
 		s := int(binary.BigEndian.Uint16(sp.buffer[pos+2 : pos+4]))
+		if pos+4+s > len(sp.buffer) {
+			break
+		}
 		if t == sa {
 			return sp.buffer[pos+4 : pos+4+s]
 		}
@@ -178,23 +281,102 @@ func (sp *StunPacket) GetBytes() []byte {
 	return sp.buffer
 }
 
-//GetAddress returns the MappedAddress or XORMappedAddress in this StunPacket if its exits
+//GetAddress returns the MappedAddress or XORMappedAddress in this StunPacket if its exits.
+//SAMappedAddress is used first when both are there, GetAddressFrom can pick the order
 func (sp *StunPacket) GetAddress() (*net.UDPAddr, error) {
-	var ip net.IP
-	var port uint16
-	sas := sp.GetAttribute(SAMappedAddress)
-	if len(sas) == 0 {
-		sas = sp.GetAttribute(SAXORMappedAddress)
-		if len(sas) == 0 {
-			return nil, errors.New("MappedAddress Not found!")
+	ua, _, err := sp.GetAddressFrom(SAMappedAddress, SAXORMappedAddress)
+	if err == errNoAddress {
+		return nil, errors.New("MappedAddress Not found!")
+	}
+	return ua, err
+}
+
+//GetAddressPreferXOR is GetAddress but uses the SAXORMappedAddress first, which is
+//what rfc5389 clients should do since a NAT can rewrite an SAMappedAddress
+func (sp *StunPacket) GetAddressPreferXOR() (*net.UDPAddr, error) {
+	ua, _, err := sp.GetAddressFrom(SAXORMappedAddress, SAMappedAddress)
+	if err == errNoAddress {
+		return nil, errors.New("MappedAddress Not found!")
+	}
+	return ua, err
+}
+
+var errNoAddress = errors.New("Address Not found!")
+
+//GetAddressFrom returns the first of the address attributes found in this StunPacket,
+//in the order given, along with which one it was
+func (sp *StunPacket) GetAddressFrom(sas ...StunAttribute) (*net.UDPAddr, StunAttribute, error) {
+	for _, sa := range sas {
+		if sp.GetAttribute(sa) != nil {
+			ua, err := sp.GetAddressAttribute(sa)
+			return ua, sa, err
 		}
-		return UnMaskAddress(*sp.GetTxID(), sas), nil
-	} else {
-		ip = net.IP(sas[4:])
-		port = (binary.BigEndian.Uint16(sas[2:4]))
-		return &net.UDPAddr{IP: ip, Port: int(port)}, nil
 	}
+	return nil, 0, errNoAddress
+}
+
+//IsXORAddress returns true for address attributes that are xor'd with the
+//magic cookie and TransactionID
+func IsXORAddress(sa StunAttribute) bool {
+	switch sa {
+	case SAXORMappedAddress, SAXORPeerAddress, SAXORRelayedAddress:
+		return true
+	}
+	return false
+}
+
+//GetAddressAttribute decodes any address style attribute in this StunPacket, like
+//SAOtherAddress or SAXORPeerAddress, un-masking it when IsXORAddress is true
+func (sp *StunPacket) GetAddressAttribute(sa StunAttribute) (*net.UDPAddr, error) {
+	ba := sp.GetAttribute(sa)
+	if ba == nil {
+		return nil, fmt.Errorf("%s Not found!", sa)
+	}
+	if !IsXORAddress(sa) {
+		return ParseAddress(ba)
+	}
+	if err := checkAddress(ba); err != nil {
+		return nil, err
+	}
+	return UnMaskAddress(*sp.GetTxID(), ba), nil
+}
+
+//GetErrorCode returns the code and reason phrase of the SAErrorCode in this StunPacket
+func (sp *StunPacket) GetErrorCode() (int, string, error) {
+	ba := sp.GetAttribute(SAErrorCode)
+	if len(ba) < 4 {
+		return 0, "", errors.New("ErrorCode Not found!")
+	}
+	v, _ := decodeErrorCode(sp.GetTxID(), ba)
+	se := v.(*StunError)
+	return se.Code, se.Reason, nil
+}
+
+//GetAlternateServer returns the SAAlternateServer address in this StunPacket if it exists
+func (sp *StunPacket) GetAlternateServer() (*net.UDPAddr, error) {
+	ba := sp.GetAttribute(SAAlternateServer)
+	if len(ba) == 0 {
+		return nil, errors.New("AlternateServer Not found!")
+	}
+	return ParseAddress(ba)
+}
+
+//GetAlternateDomain returns the SAAlternateDomain in this StunPacket if it exists
+func (sp *StunPacket) GetAlternateDomain() (string, error) {
+	ba := sp.GetAttribute(SAAlternateDomain)
+	if len(ba) == 0 {
+		return "", errors.New("AlternateDomain Not found!")
+	}
+	return string(ba), nil
+}
 
+//GetResponsePort returns the port in the rfc5780 SAResponsePort of this StunPacket if it exists
+func (sp *StunPacket) GetResponsePort() (int, error) {
+	ba := sp.GetAttribute(SAResponsePort)
+	if len(ba) != 4 {
+		return 0, errors.New("ResponsePort Not found!")
+	}
+	return int(binary.BigEndian.Uint16(ba)), nil
 }
 
 type StunPacketBuilder struct {
@@ -205,15 +387,23 @@ type StunPacketBuilder struct {
 	padding       byte
 	fingerprint   bool
 	key           []byte
+	tidGen        TIDGenerator
+	err           error
 }
 
 func fromStunPacket(sp *StunPacket) *StunPacketBuilder {
 	spb := NewStunPacketBuilder()
 	spb.SetStunMessage(sp.GetStunMessageType())
 	spb.SetTXID(sp.GetTxID())
-	for _, sa := range sp.GetAllAttributes() {
-		ba := sp.GetAttribute(sa)
-		spb.SetAttribue(sa, ba)
+	pos := 20
+	for pos+4 <= len(sp.buffer) {
+		sa := StunAttribute(binary.BigEndian.Uint16(sp.buffer[pos : pos+2]))
+		s := int(binary.BigEndian.Uint16(sp.buffer[pos+2 : pos+4]))
+		if pos+4+s > len(sp.buffer) {
+			break
+		}
+		spb.SetAttribue(sa, sp.buffer[pos+4:pos+4+s])
+		pos = ((pos + s + 4 + 3) & ^3)
 	}
 	return spb
 }
@@ -240,34 +430,99 @@ func (spb *StunPacketBuilder) SetTXID(txid *TransactionID) *StunPacketBuilder {
 	return spb
 }
 
+//SetTIDGenerator sets the TIDGenerator used when no TransactionID has been set,
+//nil uses CreateTID
+func (spb *StunPacketBuilder) SetTIDGenerator(tg TIDGenerator) *StunPacketBuilder {
+	spb.tidGen = tg
+	return spb
+}
+
+func (spb *StunPacketBuilder) createTID() *TransactionID {
+	if spb.tidGen != nil {
+		return spb.tidGen.CreateTID()
+	}
+	return CreateTID()
+}
+
 func (spb *StunPacketBuilder) SetAttribue(sa StunAttribute, ba []byte) *StunPacketBuilder {
 	spb.attribs = append(spb.attribs, sa)
 	spb.attribsBuffer = append(spb.attribsBuffer, ba)
 	return spb
 }
 
+//GetAttribute returns the []byte of the first StunAttribute of this type, nil if there is none
+func (spb *StunPacketBuilder) GetAttribute(sa StunAttribute) []byte {
+	for i, a := range spb.attribs {
+		if a == sa {
+			return spb.attribsBuffer[i]
+		}
+	}
+	return nil
+}
+
+//HasAttribute returns true if there is at least one StunAttribute of this type
+func (spb *StunPacketBuilder) HasAttribute(sa StunAttribute) bool {
+	for _, a := range spb.attribs {
+		if a == sa {
+			return true
+		}
+	}
+	return false
+}
+
+//GetAttributes returns the StunAttributes in the order they will be built
+func (spb *StunPacketBuilder) GetAttributes() []StunAttribute {
+	return append([]StunAttribute(nil), spb.attribs...)
+}
+
+//RemoveAttribute removes every StunAttribute of this type
+func (spb *StunPacketBuilder) RemoveAttribute(sa StunAttribute) *StunPacketBuilder {
+	attribs := spb.attribs[:0]
+	buffers := spb.attribsBuffer[:0]
+	for i, a := range spb.attribs {
+		if a != sa {
+			attribs = append(attribs, a)
+			buffers = append(buffers, spb.attribsBuffer[i])
+		}
+	}
+	spb.attribs = attribs
+	spb.attribsBuffer = buffers
+	return spb
+}
+
+//ReplaceAttribute sets the value of the first StunAttribute of this type, keeping its
+//position, and removes any others. It is added at the end if there is none
+func (spb *StunPacketBuilder) ReplaceAttribute(sa StunAttribute, ba []byte) *StunPacketBuilder {
+	for i, a := range spb.attribs {
+		if a == sa {
+			spb.RemoveAttribute(sa)
+			return spb.InsertAttribute(i, sa, ba)
+		}
+	}
+	return spb.SetAttribue(sa, ba)
+}
+
+//InsertAttribute adds a StunAttribute at the index, moving the ones after it down.
+//An index past the end adds it at the end
+func (spb *StunPacketBuilder) InsertAttribute(index int, sa StunAttribute, ba []byte) *StunPacketBuilder {
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(spb.attribs) {
+		return spb.SetAttribue(sa, ba)
+	}
+	spb.attribs = append(spb.attribs[:index], append([]StunAttribute{sa}, spb.attribs[index:]...)...)
+	spb.attribsBuffer = append(spb.attribsBuffer[:index], append([][]byte{ba}, spb.attribsBuffer[index:]...)...)
+	return spb
+}
+
 func (spb *StunPacketBuilder) SetPaddingByte(b byte) *StunPacketBuilder {
 	spb.padding = b
 	return spb
 }
 
 func (spb *StunPacketBuilder) SetAddress(ua *net.UDPAddr) *StunPacketBuilder {
-	ip := ua.IP.To4()
-	if ip == nil {
-		ip = ua.IP
-	}
-	aba := []byte(ip)
-	var saba []byte
-	if len(aba) == 4 {
-		saba = make([]byte, 8)
-		saba[1] = 1
-	} else {
-		saba = make([]byte, 20)
-		saba[1] = 2
-	}
-	binary.BigEndian.PutUint16(saba[2:4], uint16(ua.Port))
-	copy(saba[4:], aba)
-	spb.SetAttribue(SAMappedAddress, saba)
+	spb.ReplaceAttribute(SAMappedAddress, CreateAddress(ua))
 	return spb
 }
 
@@ -277,9 +532,44 @@ func (spb *StunPacketBuilder) SetXORAddress(ua *net.UDPAddr) *StunPacketBuilder
 		ip = ua.IP
 	}
 	if spb.tid == nil {
-		spb.tid = CreateTID()
+		spb.tid = spb.createTID()
 	}
-	spb.SetAttribue(SAXORMappedAddress, CreateMaskedAddress(*spb.tid, ua))
+	spb.ReplaceAttribute(SAXORMappedAddress, CreateMaskedAddress(*spb.tid, ua))
+	return spb
+}
+
+//SetErrorCode adds an SAErrorCode with the given code (300-699) and reason phrase
+func (spb *StunPacketBuilder) SetErrorCode(code int, reason string) *StunPacketBuilder {
+	ba, _ := encodeErrorCode(spb.tid, &StunError{Code: code, Reason: reason})
+	spb.ReplaceAttribute(SAErrorCode, ba)
+	return spb
+}
+
+//SetAlternateServer adds an SAAlternateServer pointing to the provided address
+func (spb *StunPacketBuilder) SetAlternateServer(ua *net.UDPAddr) *StunPacketBuilder {
+	spb.ReplaceAttribute(SAAlternateServer, CreateAddress(ua))
+	return spb
+}
+
+//SetAlternateDomain adds an SAAlternateDomain, used with SAAlternateServer for TLS/DTLS
+func (spb *StunPacketBuilder) SetAlternateDomain(domain string) *StunPacketBuilder {
+	spb.ReplaceAttribute(SAAlternateDomain, []byte(domain))
+	return spb
+}
+
+//SetResponsePort adds an rfc5780 SAResponsePort asking the server to send the
+//response to this port on our ip instead of the one the request came from
+func (spb *StunPacketBuilder) SetResponsePort(port int) *StunPacketBuilder {
+	ba := make([]byte, 4)
+	binary.BigEndian.PutUint16(ba, uint16(port))
+	spb.ReplaceAttribute(SAResponsePort, ba)
+	return spb
+}
+
+//SetPadding adds an rfc5780 SAPadding with size bytes, used to make larger packets.
+//The padding bytes are 0
+func (spb *StunPacketBuilder) SetPadding(size int) *StunPacketBuilder {
+	spb.ReplaceAttribute(SAPadding, make([]byte, size))
 	return spb
 }
 
@@ -289,18 +579,48 @@ func (spb *StunPacketBuilder) ClearAttributes() *StunPacketBuilder {
 	return spb
 }
 
+//AddFingerprint makes Build add an SAFingerPrint, one that was added as an
+//attribute is kept either way and must be removed with RemoveAttribute
 func (spb *StunPacketBuilder) AddFingerprint(fp bool) *StunPacketBuilder {
 	spb.fingerprint = fp
 	return spb
 }
 
+//SetMessageIntegrity makes Build add an SAMessageIntegrity using the provided key,
+//nil will stop it from being added
+func (spb *StunPacketBuilder) SetMessageIntegrity(key []byte) *StunPacketBuilder {
+	spb.key = key
+	return spb
+}
+
+//Build creates the StunPacket. SAMessageIntegrity is created with the key from
+//SetMessageIntegrity after all the other attributes, an SAMessageIntegrity without
+//a key is dropped since it can not be correct anymore. SAFingerPrint is always put
+//last, as rfc5389 requires, and is recomputed when AddFingerprint is set or one was added
 func (spb *StunPacketBuilder) Build() *StunPacket {
+	attribs := make([]StunAttribute, 0, len(spb.attribs))
+	buffers := make([][]byte, 0, len(spb.attribs))
+	fingerprint := spb.fingerprint
+	for i, sa := range spb.attribs {
+		switch sa {
+		case SAMessageIntegrity:
+			//made again after the other attributes when there is a key
+		case SAFingerPrint:
+			fingerprint = true
+		default:
+			attribs = append(attribs, sa)
+			buffers = append(buffers, spb.attribsBuffer[i])
+		}
+	}
 	size := 20
-	for _, v := range spb.attribsBuffer {
+	for _, v := range buffers {
 		size += len(v) + 4
 		size = (size + 3) & ^3
 	}
-	if spb.fingerprint {
+	if spb.key != nil {
+		size += 24
+	}
+	if fingerprint {
 		size += 8
 	}
 	ba := make([]byte, size)
@@ -308,13 +628,13 @@ func (spb *StunPacketBuilder) Build() *StunPacket {
 	binary.BigEndian.PutUint16(ba[2:4], uint16(size-20))
 	binary.BigEndian.PutUint32(ba[4:8], stunMagic)
 	if spb.tid == nil {
-		copy(ba[8:20], CreateTID().GetTID())
+		copy(ba[8:20], spb.createTID().GetTID())
 	} else {
 		copy(ba[8:20], spb.tid.GetTID())
 	}
 	pos := 20
-	for i, sa := range spb.attribs {
-		sab := spb.attribsBuffer[i]
+	for i, sa := range attribs {
+		sab := buffers[i]
 		bl := len(sab)
 		binary.BigEndian.PutUint16(ba[pos:pos+2], uint16(sa))
 		binary.BigEndian.PutUint16(ba[pos+2:pos+4], uint16(bl))
@@ -326,7 +646,15 @@ func (spb *StunPacketBuilder) Build() *StunPacket {
 			pos++
 		}
 	}
-	if spb.fingerprint {
+	if spb.key != nil {
+		binary.BigEndian.PutUint16(ba[2:4], uint16(pos+24-20))
+		mac := CreateMessageIntegrity(ba[:pos], spb.key)
+		binary.BigEndian.PutUint16(ba[pos:pos+2], uint16(SAMessageIntegrity))
+		binary.BigEndian.PutUint16(ba[pos+2:pos+4], uint16(20))
+		copy(ba[pos+4:pos+24], mac)
+		binary.BigEndian.PutUint16(ba[2:4], uint16(size-20))
+	}
+	if fingerprint {
 		fps := size - 8
 		fp := CreateStunFingerPrint(ba[:fps])
 		binary.BigEndian.PutUint16(ba[fps:fps+2], uint16(SAFingerPrint))
@@ -161,6 +161,24 @@ func TestBadStunPacket(t *testing.T) {
 	assert.Equal(t, "Not a valid stun packet!", err.Error())
 }
 
+func TestBadAttributeSize(t *testing.T) {
+	ba := NewStunPacketBuilder().SetAttribue(SASoftware, []byte("TEST")).Build().GetBytes()
+	ba[23] = 200
+	_, err := NewStunPacket(ba)
+	assert.Equal(t, ErrInvalidAttributeSize, err)
+	//a header cut short by the message length
+	ba = append(NewStunPacketBuilder().Build().GetBytes(), 0, 0x22)
+	ba[3] = 2
+	_, err = NewStunPacket(ba)
+	assert.Equal(t, ErrInvalidAttributeSize, err)
+
+	sp := &StunPacket{buffer: append(make([]byte, 20), 0x80, 0x22, 0, 200)}
+	assert.Nil(t, sp.GetAttribute(SASoftware))
+	assert.Equal(t, 0, len(sp.GetAllAttributes()))
+	assert.False(t, sp.HasFingerPrint())
+	assert.Equal(t, 0, len(sp.ToBuilder().attribs))
+}
+
 func TestNoAddr(t *testing.T) {
 	sp := NewStunPacketBuilder().Build()
 	add, err := sp.GetAddress()
@@ -302,3 +320,149 @@ func BenchmarkCreateTID(b *testing.B) {
 		CreateTID()
 	}
 }
+
+func TestErrorCode(t *testing.T) {
+	sp := NewStunPacketBuilder().SetStunMessage(SMFailure).SetErrorCode(ECUnknownAttribute, "Unknown Attribute").Build()
+	code, reason, err := sp.GetErrorCode()
+	assert.NoError(t, err)
+	assert.Equal(t, 420, code)
+	assert.Equal(t, "Unknown Attribute", reason)
+	_, _, err = NewStunPacketBuilder().Build().GetErrorCode()
+	assert.Equal(t, "ErrorCode Not found!", err.Error())
+}
+
+func TestStunMessageMethodClass(t *testing.T) {
+	assert.Equal(t, SMRequest, NewStunMessage(MethodBinding, ClassRequest))
+	assert.Equal(t, SMFailure, NewStunMessage(MethodBinding, ClassFailure))
+	assert.Equal(t, StunMessage(0x0113), NewStunMessage(MethodAllocate, ClassFailure))
+	assert.Equal(t, MethodChannelBind, StunMessage(0x0109).Method())
+	assert.Equal(t, ClassSuccess, StunMessage(0x0109).Class())
+	assert.Equal(t, ClassIndication, SMIndication.Class())
+	assert.Equal(t, "Refresh Request", NewStunMessage(MethodRefresh, ClassRequest).String())
+
+	ba := NewStunPacketBuilder().SetStunMessage(NewStunMessage(MethodAllocate, ClassRequest)).Build().GetBytes()
+	assert.True(t, IsStunPacket(ba))
+	ba[0] |= 0x40
+	assert.False(t, IsStunPacket(ba))
+}
+
+func TestMessageIntegrityVectors(t *testing.T) {
+	key, err := ShortTermKey("VOkJxbRl1RmTxUk/WvJxBt")
+	assert.NoError(t, err)
+	for _, v := range []string{SPREQ1, SPRESP1} {
+		ba, _ := hex.DecodeString(v)
+		sp, _ := NewStunPacket(ba)
+		assert.True(t, sp.HasMessageIntegrity())
+		assert.True(t, sp.VerifyMessageIntegrity(key))
+		assert.False(t, sp.VerifyMessageIntegrity([]byte("wrong")))
+	}
+}
+
+func TestCreateMessageIntegrity(t *testing.T) {
+	key, err := LongTermKey("user", "example.org", "pass")
+	assert.NoError(t, err)
+	sp := NewStunPacketBuilder().SetAttribue(SASoftware, []byte("TEST!")).SetMessageIntegrity(key).AddFingerprint(true).Build()
+	assert.True(t, sp.VerifyMessageIntegrity(key))
+	assert.True(t, VerifyFingerPrint(*sp))
+	assert.Equal(t, []StunAttribute{SASoftware, SAMessageIntegrity, SAFingerPrint}, sp.GetAllAttributes())
+	assert.False(t, NewStunPacketBuilder().Build().VerifyMessageIntegrity(key))
+}
+
+func TestGetAddressFrom(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 1000}
+	xor := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 2000}
+	peer := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 3000}
+	relayed := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7).To4(), Port: 4000}
+	other := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 9).To4(), Port: 5000}
+	spb := NewStunPacketBuilder().SetStunMessage(SMSuccess).SetAddress(mapped).SetXORAddress(xor)
+	assert.NoError(t, spb.SetValue(SAXORPeerAddress, peer))
+	assert.NoError(t, spb.SetValue(SAXORRelayedAddress, relayed))
+	assert.NoError(t, spb.SetValue(SAOtherAddress, other))
+	sp := spb.Build()
+
+	ua, err := sp.GetAddress()
+	assert.NoError(t, err)
+	assert.Equal(t, mapped, ua)
+	ua, err = sp.GetAddressPreferXOR()
+	assert.NoError(t, err)
+	assert.Equal(t, xor, ua)
+	ua, sa, err := sp.GetAddressFrom(SAResponseOrigin, SAXORRelayedAddress, SAMappedAddress)
+	assert.NoError(t, err)
+	assert.Equal(t, SAXORRelayedAddress, sa)
+	assert.Equal(t, relayed, ua)
+	_, _, err = sp.GetAddressFrom(SAResponseOrigin)
+	assert.Error(t, err)
+
+	for sa, want := range map[StunAttribute]*net.UDPAddr{SAXORPeerAddress: peer, SAOtherAddress: other, SAXORMappedAddress: xor} {
+		ua, err := sp.GetAddressAttribute(sa)
+		assert.NoError(t, err)
+		assert.Equal(t, want, ua)
+		ap, err := sp.GetAddrPortAttribute(sa)
+		assert.NoError(t, err)
+		assert.Equal(t, want.String(), ap.String())
+	}
+	assert.NotEqual(t, CreateAddress(peer), sp.GetAttribute(SAXORPeerAddress))
+	_, err = sp.GetAddressAttribute(SAChangedAddress)
+	assert.Equal(t, "CHANGED-ADDRESS Not found!", err.Error())
+	assert.True(t, IsXORAddress(SAXORPeerAddress))
+	assert.False(t, IsXORAddress(SAAlternateServer))
+
+	sp = NewStunPacketBuilder().SetXORAddress(xor).Build()
+	ua, err = sp.GetAddress()
+	assert.NoError(t, err)
+	assert.Equal(t, xor, ua)
+}
+
+func TestBuilderEditing(t *testing.T) {
+	ua1 := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 1000}
+	ua2 := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2).To4(), Port: 2000}
+	spb := NewStunPacketBuilder().SetXORAddress(ua1).SetXORAddress(ua2)
+	assert.Equal(t, []StunAttribute{SAXORMappedAddress}, spb.GetAttributes())
+	ua, err := spb.Build().GetAddress()
+	assert.NoError(t, err)
+	assert.Equal(t, ua2, ua)
+
+	spb.SetSoftware("one")
+	spb.InsertAttribute(0, SAPriority, []byte{0, 0, 0, 1})
+	spb.InsertAttribute(100, SAUseCandidate, []byte{})
+	spb.ReplaceAttribute(SAPriority, []byte{0, 0, 0, 2})
+	assert.Equal(t, []StunAttribute{SAPriority, SAXORMappedAddress, SASoftware, SAUseCandidate}, spb.GetAttributes())
+	assert.Equal(t, []byte{0, 0, 0, 2}, spb.GetAttribute(SAPriority))
+	assert.True(t, spb.HasAttribute(SAUseCandidate))
+	spb.RemoveAttribute(SAUseCandidate)
+	assert.False(t, spb.HasAttribute(SAUseCandidate))
+	assert.Nil(t, spb.GetAttribute(SAUseCandidate))
+
+	spb.SetAttribue(SAFingerPrint, []byte{1, 2, 3, 4})
+	spb.SetMessageIntegrity([]byte("key")).AddFingerprint(true)
+	spb.SetAttribue(SAPriority, []byte{0, 0, 0, 3})
+	sp := spb.Build()
+	assert.Equal(t, []StunAttribute{SAPriority, SAXORMappedAddress, SASoftware, SAPriority, SAMessageIntegrity, SAFingerPrint}, sp.GetAllAttributes())
+	assert.True(t, VerifyFingerPrint(*sp))
+	assert.True(t, sp.VerifyMessageIntegrity([]byte("key")))
+
+	//without the key the MessageIntegrity is dropped, the FingerPrint is made again
+	sp2 := sp.ToBuilder().InsertAttribute(0, SAUseCandidate, []byte{}).Build()
+	assert.Equal(t, []StunAttribute{SAUseCandidate, SAPriority, SAXORMappedAddress, SASoftware, SAPriority, SAFingerPrint}, sp2.GetAllAttributes())
+	assert.True(t, VerifyFingerPrint(*sp2))
+	assert.Equal(t, sp2.GetBytes(), sp2.ToBuilder().Build().GetBytes())
+	spb = sp.ToBuilder().RemoveAttribute(SAFingerPrint)
+	assert.Equal(t, []StunAttribute{SAPriority, SAXORMappedAddress, SASoftware, SAPriority}, spb.Build().GetAllAttributes())
+
+	//the MessageIntegrity moves after attributes added behind it, only the FingerPrint follows it
+	spb = sp.ToBuilder().InsertAttribute(100, SASoftware, []byte("after"))
+	spb.SetMessageIntegrity([]byte("key"))
+	sp4 := spb.Build()
+	assert.Equal(t, []StunAttribute{SAPriority, SAXORMappedAddress, SASoftware, SAPriority, SASoftware, SAMessageIntegrity, SAFingerPrint}, sp4.GetAllAttributes())
+	assert.True(t, VerifyFingerPrint(*sp4))
+	assert.True(t, sp4.VerifyMessageIntegrity([]byte("key")))
+
+	spb = sp.ToBuilder()
+	spb.SetSoftware("two")
+	sp3 := spb.SetMessageIntegrity([]byte("key")).AddFingerprint(true).Build()
+	s, _ := sp3.GetSoftware()
+	assert.Equal(t, "two", s)
+	assert.Equal(t, []StunAttribute{SAPriority, SAXORMappedAddress, SASoftware, SAPriority, SAMessageIntegrity, SAFingerPrint}, sp3.GetAllAttributes())
+	assert.True(t, VerifyFingerPrint(*sp3))
+	assert.True(t, sp3.VerifyMessageIntegrity([]byte("key")))
+}
@@ -21,20 +21,9 @@ func CreateStunFingerPrint(sp []byte) uint32 {
 }
 
 //VerifyFingerPrint verifies there is a fingerprint and it is correct.
+//StunPacket.CheckFingerPrint says why it is not
 func VerifyFingerPrint(sp StunPacket) bool {
-	ba := sp.GetBytes()
-	size := len(ba)
-	sizep := size - 8
-	sa := binary.BigEndian.Uint16(ba[size-8 : size-6])
-	if StunAttribute(sa) != SAFingerPrint {
-		return false
-	}
-	crc32 := stunFingerPrintMagic ^ crc32.ChecksumIEEE(ba[:sizep])
-	oc := binary.BigEndian.Uint32(ba[size-4:])
-	if crc32 == oc {
-		return true
-	}
-	return false
+	return sp.CheckFingerPrint() == nil
 }
 
 func UnmaskIP(tid TransactionID, address []byte) net.IP {
@@ -56,6 +45,36 @@ func UnMaskAddress(tid TransactionID, mba []byte) *net.UDPAddr {
 	return &net.UDPAddr{IP: ip, Port: int(port)}
 }
 
+//CreateAddress turns a net.UDPAddr into an un-masked address attribute []byte
+//as used by SAMappedAddress and SAAlternateServer
+func CreateAddress(ua *net.UDPAddr) []byte {
+	ip := ua.IP.To4()
+	if ip == nil {
+		ip = ua.IP
+	}
+	var ba []byte
+	if len(ip) == 4 {
+		ba = make([]byte, 8)
+		ba[1] = 1
+	} else {
+		ba = make([]byte, 20)
+		ba[1] = 2
+	}
+	binary.BigEndian.PutUint16(ba[2:4], uint16(ua.Port))
+	copy(ba[4:], ip)
+	return ba
+}
+
+//ParseAddress turns an un-masked address attribute []byte into a net.UDPAddr
+func ParseAddress(ba []byte) (*net.UDPAddr, error) {
+	if err := checkAddress(ba); err != nil {
+		return nil, err
+	}
+	ip := net.IP(ba[4:])
+	port := binary.BigEndian.Uint16(ba[2:4])
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
 func CreateMaskedAddress(tid TransactionID, ua *net.UDPAddr) []byte {
 	ip := ua.IP.To4()
 	if ip == nil {
@@ -81,18 +100,16 @@ func CreateMaskedAddress(tid TransactionID, ua *net.UDPAddr) []byte {
 	return to
 }
 
+//IsStunPacket checks the rfc5389 header of a packet for any stun method: the top 2
+//bits are 0, the magic cookie is there and the length matches. It is what
+//NewStunPacket checks, and allows a length that is not a multiple of 4 so packets
+//with unpadded attributes can still be read. IsStunMessage is the stricter check
 func IsStunPacket(ba []byte) bool {
 	if len(ba) < 20 {
 		return false
 	}
-	sm := StunMessage(binary.BigEndian.Uint16(ba[:2]))
-	switch sm {
-	case SMRequest:
-	case SMSuccess:
-	case SMFailure:
-	case SMIndication:
-		break
-	default:
+	//any method is allowed, the top 2 bits of every StunMessage are 0
+	if ba[0]&0xc0 != 0 {
 		return false
 	}
 	size := int(binary.BigEndian.Uint16(ba[2:4]))
@@ -0,0 +1,170 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"errors"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	//rfc5389 limits for SASoftware, SARealm and SANonce, less than 128 characters
+	//which can be as long as 763 bytes
+	maxTextChars = 127
+	maxTextBytes = 763
+	//rfc5389 limit for SAUsername, less than 513 bytes
+	maxUsernameBytes = 512
+)
+
+var (
+	ErrInvalidUTF8     = errors.New("Attribute is not valid UTF-8!")
+	ErrTooManyChars    = errors.New("Attribute has too many characters!")
+	ErrTooManyBytes    = errors.New("Attribute has too many bytes!")
+	ErrEmptyString     = errors.New("Attribute can not be empty!")
+	ErrProhibitedChars = errors.New("Attribute has prohibited characters!")
+)
+
+//PrepareUsername does the mapping and checks of SASLprep on a username.
+//Non-ASCII spaces are mapped to ' ', characters commonly mapped to nothing
+//are removed and control, private use, surrogate and non-character code
+//points are rejected. Unicode normalization is not applied, so this is not
+//the full rfc8265 OpaqueString profile and strings should already be NFC
+func PrepareUsername(s string) (string, error) {
+	return prepareText(s)
+}
+
+//PreparePassword does the same preparation as PrepareUsername on a password
+//before it is used to make a MessageIntegrity key
+func PreparePassword(s string) (string, error) {
+	return prepareText(s)
+}
+
+func prepareText(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", ErrInvalidUTF8
+	}
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch {
+		case mappedToNothing(r):
+			continue
+		case r != ' ' && unicode.Is(unicode.Zs, r):
+			r = ' '
+		case prohibitedRune(r):
+			return "", ErrProhibitedChars
+		}
+		out = append(out, r)
+	}
+	if len(out) == 0 {
+		return "", ErrEmptyString
+	}
+	return string(out), nil
+}
+
+//mappedToNothing is table B.1 of rfc3454
+func mappedToNothing(r rune) bool {
+	switch {
+	case r == 0x00AD, r == 0x034F, r == 0x1806, r == 0x2060, r == 0xFEFF:
+		return true
+	case r >= 0x180B && r <= 0x180D, r >= 0x200B && r <= 0x200D, r >= 0xFE00 && r <= 0xFE0F:
+		return true
+	}
+	return false
+}
+
+func prohibitedRune(r rune) bool {
+	if unicode.In(r, unicode.Cc, unicode.Co, unicode.Cs) {
+		return true
+	}
+	//non-character code points
+	return (r >= 0xFDD0 && r <= 0xFDEF) || r&0xFFFE == 0xFFFE
+}
+
+func checkText(s string, maxChars int, maxBytes int) error {
+	if !utf8.ValidString(s) {
+		return ErrInvalidUTF8
+	}
+	if len(s) > maxBytes {
+		return ErrTooManyBytes
+	}
+	if maxChars > 0 && utf8.RuneCountInString(s) > maxChars {
+		return ErrTooManyChars
+	}
+	return nil
+}
+
+//setText adds a text attribute, or keeps the error for Validate when it is not valid
+func (spb *StunPacketBuilder) setText(sa StunAttribute, s string, err error, maxChars int, maxBytes int) *StunPacketBuilder {
+	if err == nil {
+		err = checkText(s, maxChars, maxBytes)
+	}
+	if err != nil {
+		if spb.err == nil {
+			spb.err = err
+		}
+		return spb
+	}
+	spb.ReplaceAttribute(sa, []byte(s))
+	return spb
+}
+
+//Validate returns the first error from a setter that checks its value, like
+//SetSoftware. The attribute of a setter that failed is not added
+func (spb *StunPacketBuilder) Validate() error {
+	return spb.err
+}
+
+//SetSoftware adds an SASoftware, it must be less than 128 characters
+func (spb *StunPacketBuilder) SetSoftware(software string) *StunPacketBuilder {
+	return spb.setText(SASoftware, software, nil, maxTextChars, maxTextBytes)
+}
+
+//SetUsername prepares the username with PrepareUsername and adds it as an SAUsername,
+//the result must be less than 513 bytes
+func (spb *StunPacketBuilder) SetUsername(username string) *StunPacketBuilder {
+	u, err := PrepareUsername(username)
+	return spb.setText(SAUsername, u, err, 0, maxUsernameBytes)
+}
+
+//SetRealm prepares the realm like PrepareUsername and adds it as an SARealm,
+//it must be less than 128 characters
+func (spb *StunPacketBuilder) SetRealm(realm string) *StunPacketBuilder {
+	r, err := prepareText(realm)
+	return spb.setText(SARealm, r, err, maxTextChars, maxTextBytes)
+}
+
+//SetNonce adds an SANonce, it must be less than 128 characters
+func (spb *StunPacketBuilder) SetNonce(nonce string) *StunPacketBuilder {
+	return spb.setText(SANonce, nonce, nil, maxTextChars, maxTextBytes)
+}
+
+func (sp *StunPacket) getText(sa StunAttribute, maxChars int, maxBytes int) (string, error) {
+	ba := sp.GetAttribute(sa)
+	if ba == nil {
+		return "", errors.New("Attribute Not found!")
+	}
+	s := string(ba)
+	if err := checkText(s, maxChars, maxBytes); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+//GetSoftware returns the SASoftware in this StunPacket if it exists and is valid
+func (sp *StunPacket) GetSoftware() (string, error) {
+	return sp.getText(SASoftware, maxTextChars, maxTextBytes)
+}
+
+//GetUsername returns the SAUsername in this StunPacket if it exists and is valid
+func (sp *StunPacket) GetUsername() (string, error) {
+	return sp.getText(SAUsername, 0, maxUsernameBytes)
+}
+
+//GetRealm returns the SARealm in this StunPacket if it exists and is valid
+func (sp *StunPacket) GetRealm() (string, error) {
+	return sp.getText(SARealm, maxTextChars, maxTextBytes)
+}
+
+//GetNonce returns the SANonce in this StunPacket if it exists and is valid
+func (sp *StunPacket) GetNonce() (string, error) {
+	return sp.getText(SANonce, maxTextChars, maxTextBytes)
+}
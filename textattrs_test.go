@@ -0,0 +1,74 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextAttributes(t *testing.T) {
+	spb := NewStunPacketBuilder().SetSoftware("stunlib test").SetUsername("user\u00a0name\u00ad").
+		SetRealm("example.org").SetNonce("f//499k954d6OL34oL9FSTvy64sA")
+	assert.NoError(t, spb.Validate())
+	sp := spb.Build()
+
+	s, err := sp.GetSoftware()
+	assert.NoError(t, err)
+	assert.Equal(t, "stunlib test", s)
+	s, err = sp.GetUsername()
+	assert.NoError(t, err)
+	assert.Equal(t, "user name", s)
+	s, err = sp.GetRealm()
+	assert.NoError(t, err)
+	assert.Equal(t, "example.org", s)
+	s, err = sp.GetNonce()
+	assert.NoError(t, err)
+	assert.Equal(t, "f//499k954d6OL34oL9FSTvy64sA", s)
+}
+
+func TestTextAttributeLimits(t *testing.T) {
+	assert.Equal(t, ErrTooManyChars, NewStunPacketBuilder().SetSoftware(strings.Repeat("a", 128)).Validate())
+	assert.NoError(t, NewStunPacketBuilder().SetSoftware(strings.Repeat("a", 127)).Validate())
+	assert.Equal(t, ErrTooManyBytes, NewStunPacketBuilder().SetUsername(strings.Repeat("u", 513)).Validate())
+	assert.NoError(t, NewStunPacketBuilder().SetUsername(strings.Repeat("u", 512)).Validate())
+	assert.Equal(t, ErrInvalidUTF8, NewStunPacketBuilder().SetRealm("\xff").Validate())
+	assert.Equal(t, ErrProhibitedChars, NewStunPacketBuilder().SetUsername("bad\x00user").Validate())
+	assert.Equal(t, ErrEmptyString, NewStunPacketBuilder().SetUsername("\u200b").Validate())
+
+	//the first error is kept and the bad attributes are left out
+	spb := NewStunPacketBuilder().SetSoftware("\xff").SetNonce("nonce").SetUsername("")
+	assert.Equal(t, ErrInvalidUTF8, spb.Validate())
+	assert.Equal(t, []StunAttribute{SANonce}, spb.GetAttributes())
+
+	//763 bytes is the most allowed, less than 128 characters can not get there in UTF-8
+	assert.NoError(t, checkText(strings.Repeat("a", 763), 0, maxTextBytes))
+	assert.Equal(t, ErrTooManyBytes, checkText(strings.Repeat("a", 764), 0, maxTextBytes))
+}
+
+func TestServerTextSettings(t *testing.T) {
+	keys := func(username string) ([]byte, bool) { return nil, false }
+	assert.Equal(t, ErrTooManyChars, NewStunServer(nil).SetSoftware(strings.Repeat("a", 128)).Serve())
+	assert.Equal(t, ErrInvalidUTF8, NewStunServer(nil).SetLongTermAuth("\xff", keys).ServeStream(nil))
+	assert.Equal(t, ErrProhibitedChars, NewStunServer(nil).SetLongTermAuth("bad\x00realm", keys).ServeDTLS(nil))
+	assert.Equal(t, ErrEmptyString, NewTurnServer(nil).SetLongTermAuth("", keys).ServeStream(nil))
+	//the first error is kept
+	ss := NewStunServer(nil).SetSoftware("\xff").SetLongTermAuth("", keys).SetSoftware("ok")
+	assert.Equal(t, ErrInvalidUTF8, ss.Serve())
+}
+
+func TestPreparePassword(t *testing.T) {
+	p, err := PreparePassword("The\u3000pass\ufeffword")
+	assert.NoError(t, err)
+	assert.Equal(t, "The password", p)
+	_, err = PreparePassword("\ufdd0")
+	assert.Equal(t, ErrProhibitedChars, err)
+}
+
+func TestGetBadText(t *testing.T) {
+	sp := NewStunPacketBuilder().SetAttribue(SASoftware, []byte{0xff, 0xfe}).Build()
+	_, err := sp.GetSoftware()
+	assert.Equal(t, ErrInvalidUTF8, err)
+	_, err = sp.GetNonce()
+	assert.Equal(t, "Attribute Not found!", err.Error())
+}
@@ -0,0 +1,56 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	crypto_rand "crypto/rand"
+	"io"
+	"math/rand"
+	"sync"
+)
+
+//tidBufferSize is how many TransactionIDs worth of random bytes are read at once
+const tidBufferSize = 12 * 128
+
+//TIDGenerator creates the TransactionIDs for new StunPackets
+type TIDGenerator interface {
+	CreateTID() *TransactionID
+}
+
+//DefaultTIDGenerator is used by CreateTID, it reads from crypto/rand
+var DefaultTIDGenerator TIDGenerator = NewBufferedTIDGenerator(crypto_rand.Reader)
+
+type bufferedTIDGenerator struct {
+	lock   sync.Mutex
+	r      io.Reader
+	buffer []byte
+	pos    int
+}
+
+//NewBufferedTIDGenerator creates a TIDGenerator that reads random bytes from r in
+//large chunks so each TransactionID does not need its own read. It panics if r
+//fails, since a TransactionID that is not random is not safe to use
+func NewBufferedTIDGenerator(r io.Reader) TIDGenerator {
+	buffer := make([]byte, tidBufferSize)
+	return &bufferedTIDGenerator{r: r, buffer: buffer, pos: len(buffer)}
+}
+
+//NewSeededTIDGenerator creates a TIDGenerator that always makes the same
+//TransactionIDs for the same seed. It is only meant for tests that need
+//reproducible StunPackets
+func NewSeededTIDGenerator(seed int64) TIDGenerator {
+	return NewBufferedTIDGenerator(rand.New(rand.NewSource(seed)))
+}
+
+func (btg *bufferedTIDGenerator) CreateTID() *TransactionID {
+	btg.lock.Lock()
+	defer btg.lock.Unlock()
+	if btg.pos+12 > len(btg.buffer) {
+		if _, err := io.ReadFull(btg.r, btg.buffer); err != nil {
+			panic("Could not read random bytes for a TransactionID: " + err.Error())
+		}
+		btg.pos = 0
+	}
+	ba := make([]byte, 12)
+	copy(ba, btg.buffer[btg.pos:btg.pos+12])
+	btg.pos += 12
+	return &TransactionID{tid: ba}
+}
@@ -0,0 +1,60 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTIDUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < tidBufferSize; i++ {
+		tid := CreateTID().GetTID()
+		assert.Equal(t, 12, len(tid))
+		assert.False(t, seen[string(tid)])
+		seen[string(tid)] = true
+	}
+}
+
+func TestSeededTIDGenerator(t *testing.T) {
+	g1 := NewSeededTIDGenerator(42)
+	g2 := NewSeededTIDGenerator(42)
+	for i := 0; i < 200; i++ {
+		assert.Equal(t, g1.CreateTID(), g2.CreateTID())
+	}
+	assert.NotEqual(t, NewSeededTIDGenerator(1).CreateTID(), NewSeededTIDGenerator(2).CreateTID())
+
+	build := func() []byte {
+		return NewStunPacketBuilder().SetTIDGenerator(NewSeededTIDGenerator(7)).
+			SetStunMessage(SMSuccess).
+			SetXORAddress(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 32853}).
+			AddFingerprint(true).Build().GetBytes()
+	}
+	assert.Equal(t, build(), build())
+}
+
+func TestBufferedTIDGenerator(t *testing.T) {
+	src := make([]byte, tidBufferSize*2)
+	for i := range src {
+		src[i] = byte(i)
+	}
+	g := NewBufferedTIDGenerator(bytes.NewReader(src))
+	for i := 0; i < tidBufferSize/6; i++ {
+		assert.Equal(t, src[i*12:i*12+12], g.CreateTID().GetTID())
+	}
+	assert.Panics(t, func() { g.CreateTID() })
+}
+
+func TestClientTIDGenerator(t *testing.T) {
+	_, sconn := startServer(t)
+	defer sconn.Close()
+	sc, cconn := newClient(t)
+	defer cconn.Close()
+	sc.SetTIDGenerator(NewSeededTIDGenerator(99))
+
+	br, err := sc.Bind(sconn.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, NewSeededTIDGenerator(99).CreateTID(), br.Response.GetTxID())
+}
@@ -0,0 +1,198 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+//DefaultCacheTime is how long a TransactionManager replays a response for, rfc5389
+//says to remember a transaction for 40 seconds, just over the 39.5 second client timeout
+const DefaultCacheTime = 40 * time.Second
+
+//ErrStopped is returned by Serve, and to any StunClient still waiting, after Stop
+var ErrStopped = errors.New("TransactionManager Stopped!")
+
+//RequestHandler creates the response for a request or indication, nil means nothing is sent
+type RequestHandler func(sp *StunPacket, addr net.Addr) *StunPacket
+
+type cachedResponse struct {
+	ba      []byte
+	expires time.Time
+}
+
+//TransactionManager reads StunPackets from a net.PacketConn and dispatches them.
+//Responses go to the StunClient waiting for their TransactionID and requests go to
+//the RequestHandler. The response to each request is cached so a retransmitted
+//request gets the same response again instead of being handled twice, which
+//matters for non-idempotent methods. This lets one net.PacketConn act as both a
+//client and a server
+type TransactionManager struct {
+	conn      net.PacketConn
+	handler   RequestHandler
+	cacheTime time.Duration
+	logger    *slog.Logger
+	lock      sync.Mutex
+	pending   map[string]chan *StunPacket
+	cache     map[string]*cachedResponse
+	nextSweep time.Time
+	stopped   bool
+	done      chan struct{}
+	err       error
+}
+
+//NewTransactionManager creates a TransactionManager on the provided net.PacketConn,
+//Serve must be called before it does anything
+func NewTransactionManager(conn net.PacketConn) *TransactionManager {
+	return &TransactionManager{
+		conn:      conn,
+		cacheTime: DefaultCacheTime,
+		pending:   make(map[string]chan *StunPacket),
+		cache:     make(map[string]*cachedResponse),
+		done:      make(chan struct{}),
+	}
+}
+
+//SetHandler sets the RequestHandler for requests and indications, when nil they are dropped
+func (tm *TransactionManager) SetHandler(handler RequestHandler) *TransactionManager {
+	tm.handler = handler
+	return tm
+}
+
+//SetCacheTime sets how long responses are replayed for retransmitted requests, 0 disables the cache
+func (tm *TransactionManager) SetCacheTime(d time.Duration) *TransactionManager {
+	tm.cacheTime = d
+	return tm
+}
+
+//SetLogger sets the slog.Logger used to log dropped and replayed packets, nil disables logging
+func (tm *TransactionManager) SetLogger(logger *slog.Logger) *TransactionManager {
+	tm.logger = logger
+	return tm
+}
+
+//Serve reads from the net.PacketConn and dispatches packets until a read fails
+//or Stop is called
+func (tm *TransactionManager) Serve() error {
+	ba := make([]byte, 65536)
+	for {
+		n, addr, err := tm.conn.ReadFrom(ba)
+		tm.lock.Lock()
+		stopped := tm.stopped
+		tm.lock.Unlock()
+		if stopped {
+			//the net.PacketConn is left as it was found so it can be read again
+			tm.conn.SetReadDeadline(time.Time{})
+			err = ErrStopped
+		}
+		if err != nil {
+			tm.err = err
+			close(tm.done)
+			return err
+		}
+		sp, err := NewStunPacket(append([]byte(nil), ba[:n]...))
+		if err != nil {
+			logPacket(tm.logger, slog.LevelDebug, "Dropped invalid packet", nil, addr, errAttr(err))
+			continue
+		}
+		switch sp.GetStunMessageType().Class() {
+		case ClassSuccess, ClassFailure:
+			tm.dispatch(sp, addr)
+		default:
+			tm.handle(sp, addr)
+		}
+	}
+}
+
+//Stop makes Serve return ErrStopped without closing the net.PacketConn, it
+//interrupts the read in progress with a read deadline
+func (tm *TransactionManager) Stop() {
+	tm.lock.Lock()
+	tm.stopped = true
+	tm.lock.Unlock()
+	tm.conn.SetReadDeadline(time.Now())
+}
+
+//dispatch passes a response to the StunClient waiting for it, false means
+//nothing was waiting
+func (tm *TransactionManager) dispatch(sp *StunPacket, addr net.Addr) bool {
+	tm.lock.Lock()
+	ch, ok := tm.pending[string(sp.GetTxID().GetTID())]
+	tm.lock.Unlock()
+	if !ok {
+		logPacket(tm.logger, slog.LevelDebug, "Dropped unexpected response", sp, addr)
+		return false
+	}
+	select {
+	case ch <- sp:
+	default:
+	}
+	return true
+}
+
+func (tm *TransactionManager) handle(sp *StunPacket, addr net.Addr) {
+	if tm.handler == nil {
+		return
+	}
+	key := addr.String() + string(sp.GetTxID().GetTID())
+	now := time.Now()
+	tm.lock.Lock()
+	cr, ok := tm.cache[key]
+	tm.lock.Unlock()
+	if ok && now.Before(cr.expires) {
+		logPacket(tm.logger, slog.LevelDebug, "Replaying response", sp, addr)
+		tm.conn.WriteTo(cr.ba, addr)
+		return
+	}
+	resp := tm.handler(sp, addr)
+	if resp == nil {
+		return
+	}
+	if tm.cacheTime > 0 && sp.GetStunMessageType().Class() == ClassRequest {
+		tm.lock.Lock()
+		if now.After(tm.nextSweep) {
+			for k, cr := range tm.cache {
+				if now.After(cr.expires) {
+					delete(tm.cache, k)
+				}
+			}
+			tm.nextSweep = now.Add(tm.cacheTime)
+		}
+		tm.cache[key] = &cachedResponse{ba: resp.GetBytes(), expires: now.Add(tm.cacheTime)}
+		tm.lock.Unlock()
+	}
+	tm.conn.WriteTo(resp.GetBytes(), addr)
+}
+
+//expect registers a request so its response is dispatched back, the returned
+//wait func must be followed by a call to forget
+func (tm *TransactionManager) expect(ctx context.Context, req *StunPacket) func(time.Time) (*StunPacket, error) {
+	tid := string(req.GetTxID().GetTID())
+	ch := make(chan *StunPacket, 1)
+	tm.lock.Lock()
+	tm.pending[tid] = ch
+	tm.lock.Unlock()
+	return func(deadline time.Time) (*StunPacket, error) {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case sp := <-ch:
+			return sp, nil
+		case <-timer.C:
+			return nil, nil
+		case <-tm.done:
+			return nil, tm.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (tm *TransactionManager) forget(req *StunPacket) {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	delete(tm.pending, string(req.GetTxID().GetTID()))
+}
@@ -0,0 +1,119 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startManager(t *testing.T, handler RequestHandler) (*TransactionManager, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	tm := NewTransactionManager(conn).SetHandler(handler)
+	go tm.Serve()
+	return tm, conn
+}
+
+func TestTransactionManagerReplay(t *testing.T) {
+	var handled int32
+	ss := NewStunServer(nil)
+	_, sconn := startManager(t, func(sp *StunPacket, addr net.Addr) *StunPacket {
+		atomic.AddInt32(&handled, 1)
+		return ss.HandlePacket(sp, addr)
+	})
+	defer sconn.Close()
+	cconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer cconn.Close()
+
+	req := NewStunPacketBuilder().SetStunMessage(SMRequest).Build()
+	ba := make([]byte, 1500)
+	responses := make([][]byte, 0)
+	for i := 0; i < 3; i++ {
+		cconn.WriteTo(req.GetBytes(), sconn.LocalAddr())
+		cconn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := cconn.ReadFrom(ba)
+		assert.NoError(t, err)
+		responses = append(responses, append([]byte(nil), ba[:n]...))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&handled))
+	assert.Equal(t, responses[0], responses[1])
+	assert.Equal(t, responses[0], responses[2])
+
+	req2 := NewStunPacketBuilder().SetStunMessage(SMRequest).Build()
+	cconn.WriteTo(req2.GetBytes(), sconn.LocalAddr())
+	_, _, err = cconn.ReadFrom(ba)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&handled))
+}
+
+func TestTransactionManagerSharedConn(t *testing.T) {
+	tm1, conn1 := startManager(t, NewStunServer(nil).HandlePacket)
+	defer conn1.Close()
+	tm2, conn2 := startManager(t, NewStunServer(nil).HandlePacket)
+	defer conn2.Close()
+	sc1 := NewStunClient(conn1).SetTransactionManager(tm1).SetRTO(50 * time.Millisecond)
+	sc2 := NewStunClient(conn2).SetTransactionManager(tm2).SetRTO(50 * time.Millisecond)
+
+	errs := make(chan error, 2)
+	go func() {
+		br, err := sc1.Bind(conn2.LocalAddr())
+		if err == nil {
+			assert.Equal(t, conn1.LocalAddr().String(), br.Address.String())
+		}
+		errs <- err
+	}()
+	br, err := sc2.Bind(conn1.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, conn2.LocalAddr().String(), br.Address.String())
+	assert.NoError(t, <-errs)
+
+	resp, err := sc1.Do(conn2.LocalAddr(), NewStunPacketBuilder().SetStunMessage(SMRequest).Build())
+	assert.NoError(t, err)
+	assert.Equal(t, SMSuccess, resp.GetStunMessageType())
+}
+
+func TestTransactionManagerClosed(t *testing.T) {
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer dead.Close()
+	tm, conn := startManager(t, nil)
+	sc := NewStunClient(conn).SetTransactionManager(tm)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.Close()
+	}()
+	start := time.Now()
+	_, err = sc.Bind(dead.LocalAddr())
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrTimeout, err)
+	assert.True(t, time.Since(start) < DefaultRTO)
+}
+
+func TestTransactionManagerStop(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer conn.Close()
+	tm := NewTransactionManager(conn)
+	served := make(chan error, 1)
+	go func() { served <- tm.Serve() }()
+	time.Sleep(10 * time.Millisecond)
+	tm.Stop()
+	select {
+	case err = <-served:
+		assert.Equal(t, ErrStopped, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not stop")
+	}
+
+	//the conn can still be read after Stop
+	_, err = conn.WriteTo([]byte("ping"), conn.LocalAddr())
+	assert.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(make([]byte, 10))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+}
@@ -0,0 +1,132 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	//TransportUDP is the rfc5766 SARequestedTransport protocol number for UDP
+	TransportUDP uint8 = 17
+	//TransportTCP is the rfc6062 SARequestedTransport protocol number for TCP
+	TransportTCP uint8 = 6
+
+	//DefaultAllocationLifetime is how long a TURN allocation lasts without a refresh
+	DefaultAllocationLifetime = 10 * time.Minute
+	//MaxAllocationLifetime is the longest lifetime a TurnServer will give
+	MaxAllocationLifetime = time.Hour
+	//PermissionLifetime is how long a CreatePermission lets a peer send data
+	PermissionLifetime = 5 * time.Minute
+	//ConnectionTimeout is the rfc6062 time a TurnServer waits to connect to a
+	//peer, and for the ConnectionBind of a peer connection
+	ConnectionTimeout = 30 * time.Second
+	//DefaultAllocationQuota is how many allocations a TurnServer gives one
+	//username, or one ip when there is no long-term auth
+	DefaultAllocationQuota = 10
+)
+
+var (
+	ErrNoAllocation     = errors.New("No TURN Allocation!")
+	ErrNoMobilityTicket = errors.New("No MobilityTicket for Allocation!")
+)
+
+//GetLifetime returns the SALifetime of this StunPacket
+func (sp *StunPacket) GetLifetime() (time.Duration, error) {
+	ba := sp.GetAttribute(SALifetime)
+	if len(ba) != 4 {
+		return 0, errors.New("Lifetime Not found!")
+	}
+	return time.Duration(binary.BigEndian.Uint32(ba)) * time.Second, nil
+}
+
+//SetLifetime adds an SALifetime, it is sent in whole seconds
+func (spb *StunPacketBuilder) SetLifetime(d time.Duration) *StunPacketBuilder {
+	ba := make([]byte, 4)
+	binary.BigEndian.PutUint32(ba, uint32(d/time.Second))
+	spb.ReplaceAttribute(SALifetime, ba)
+	return spb
+}
+
+//GetRequestedTransport returns the protocol number in the SARequestedTransport of this StunPacket
+func (sp *StunPacket) GetRequestedTransport() (uint8, error) {
+	ba := sp.GetAttribute(SARequestedTransport)
+	if len(ba) != 4 {
+		return 0, errors.New("RequestedTransport Not found!")
+	}
+	return ba[0], nil
+}
+
+//SetRequestedTransport adds an SARequestedTransport, like TransportUDP
+func (spb *StunPacketBuilder) SetRequestedTransport(protocol uint8) *StunPacketBuilder {
+	spb.ReplaceAttribute(SARequestedTransport, []byte{protocol, 0, 0, 0})
+	return spb
+}
+
+//GetData returns the SAData of this StunPacket, nil if there is none
+func (sp *StunPacket) GetData() []byte {
+	return sp.GetAttribute(SAData)
+}
+
+//SetData adds an SAData with the data relayed to or from a peer
+func (spb *StunPacketBuilder) SetData(ba []byte) *StunPacketBuilder {
+	spb.ReplaceAttribute(SAData, ba)
+	return spb
+}
+
+//GetXORPeerAddress returns the SAXORPeerAddress of this StunPacket
+func (sp *StunPacket) GetXORPeerAddress() (*net.UDPAddr, error) {
+	return sp.GetAddressAttribute(SAXORPeerAddress)
+}
+
+//SetXORPeerAddress adds an SAXORPeerAddress for the peer
+func (spb *StunPacketBuilder) SetXORPeerAddress(ua *net.UDPAddr) *StunPacketBuilder {
+	spb.SetValue(SAXORPeerAddress, ua)
+	return spb
+}
+
+//GetXORRelayedAddress returns the SAXORRelayedAddress of this StunPacket
+func (sp *StunPacket) GetXORRelayedAddress() (*net.UDPAddr, error) {
+	return sp.GetAddressAttribute(SAXORRelayedAddress)
+}
+
+//SetXORRelayedAddress adds an SAXORRelayedAddress for the relayed address of an allocation
+func (spb *StunPacketBuilder) SetXORRelayedAddress(ua *net.UDPAddr) *StunPacketBuilder {
+	spb.SetValue(SAXORRelayedAddress, ua)
+	return spb
+}
+
+//GetMobilityTicket returns the rfc8016 SAMobilityTicket of this StunPacket, the
+//bool is false if there is none. An empty ticket in an Allocate asks for mobility
+func (sp *StunPacket) GetMobilityTicket() ([]byte, bool) {
+	ba := sp.GetAttribute(SAMobilityTicket)
+	return ba, ba != nil
+}
+
+//SetMobilityTicket adds an rfc8016 SAMobilityTicket, use an empty ticket in an
+//Allocate request to ask for mobility
+func (spb *StunPacketBuilder) SetMobilityTicket(ticket []byte) *StunPacketBuilder {
+	if ticket == nil {
+		ticket = []byte{}
+	}
+	spb.ReplaceAttribute(SAMobilityTicket, ticket)
+	return spb
+}
+
+//GetConnectionID returns the rfc6062 SAConnectionID of this StunPacket
+func (sp *StunPacket) GetConnectionID() (uint32, error) {
+	ba := sp.GetAttribute(SAConnectionID)
+	if len(ba) != 4 {
+		return 0, errors.New("ConnectionID Not found!")
+	}
+	return binary.BigEndian.Uint32(ba), nil
+}
+
+//SetConnectionID adds an rfc6062 SAConnectionID for a peer TCP connection
+func (spb *StunPacketBuilder) SetConnectionID(id uint32) *StunPacketBuilder {
+	ba := make([]byte, 4)
+	binary.BigEndian.PutUint32(ba, id)
+	spb.ReplaceAttribute(SAConnectionID, ba)
+	return spb
+}
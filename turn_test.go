@@ -0,0 +1,376 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startTurnServer(t *testing.T, mobility bool) (*TurnServer, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	ts := NewTurnServer(conn).SetMobility(mobility).SetLongTermAuth("example.org", func(username string) ([]byte, bool) {
+		key, _ := LongTermKey(username, "example.org", "pass")
+		return key, true
+	})
+	go ts.Serve()
+	return ts, conn
+}
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	return conn
+}
+
+func readPeer(t *testing.T, conn *net.UDPConn) (string, net.Addr) {
+	ba := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := conn.ReadFrom(ba)
+	assert.NoError(t, err)
+	return string(ba[:n]), from
+}
+
+func readTurn(t *testing.T, tc *TurnClient) (string, net.Addr) {
+	type read struct {
+		s    string
+		from net.Addr
+	}
+	ch := make(chan read, 1)
+	go func() {
+		ba := make([]byte, 1500)
+		n, from, _ := tc.ReadFrom(ba)
+		ch <- read{string(ba[:n]), from}
+	}()
+	select {
+	case r := <-ch:
+		return r.s, r.from
+	case <-time.After(2 * time.Second):
+		t.Fatal("no data from peer")
+		return "", nil
+	}
+}
+
+func TestTurnMessageTypes(t *testing.T) {
+	assert.Equal(t, StunMessage(0x0103), NewStunMessage(MethodAllocate, ClassSuccess))
+	assert.Equal(t, SMRequest, NewStunMessage(MethodBinding, ClassRequest))
+	assert.Equal(t, MethodRefresh, StunMessage(0x0114).Method())
+	assert.Equal(t, ClassFailure, StunMessage(0x0114).Class())
+	assert.Equal(t, "Allocate Error Response", StunMessage(0x0113).String())
+	assert.Equal(t, "Data Indication", NewStunMessage(MethodData, ClassIndication).String())
+	assert.Equal(t, "ConnectionAttempt Indication", NewStunMessage(MethodConnectionAttempt, ClassIndication).String())
+}
+
+func TestTurnAttributes(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+	sp := NewStunPacketBuilder().SetStunMessage(NewStunMessage(MethodAllocate, ClassRequest)).
+		SetLifetime(20 * time.Minute).SetRequestedTransport(TransportUDP).SetMobilityTicket(nil).
+		SetXORPeerAddress(peer).SetData([]byte("hello")).Build()
+	sp, err := NewStunPacket(sp.GetBytes())
+	assert.NoError(t, err)
+	lt, err := sp.GetLifetime()
+	assert.NoError(t, err)
+	assert.Equal(t, 20*time.Minute, lt)
+	protocol, err := sp.GetRequestedTransport()
+	assert.NoError(t, err)
+	assert.Equal(t, TransportUDP, protocol)
+	ticket, ok := sp.GetMobilityTicket()
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(ticket))
+	ua, err := sp.GetXORPeerAddress()
+	assert.NoError(t, err)
+	assert.Equal(t, peer.String(), ua.String())
+	assert.Equal(t, "hello", string(sp.GetData()))
+
+	sp = NewStunPacketBuilder().SetMobilityTicket([]byte{1, 2, 3}).Build()
+	ticket, ok = sp.GetMobilityTicket()
+	assert.True(t, ok)
+	assert.Equal(t, []byte{1, 2, 3}, ticket)
+	attrs, err := sp.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, "MOBILITY-TICKET", attrs[0].Name())
+
+	_, ok = NewStunPacketBuilder().Build().GetMobilityTicket()
+	assert.False(t, ok)
+}
+
+func TestTurnRelay(t *testing.T) {
+	_, sconn := startTurnServer(t, false)
+	defer sconn.Close()
+	cconn := listenLoopback(t)
+	defer cconn.Close()
+	peer := listenLoopback(t)
+	defer peer.Close()
+
+	tc := NewTurnClient(cconn, sconn.LocalAddr()).SetCredentials("user", "wrong")
+	_, err := tc.Allocate()
+	assert.Equal(t, &StunError{Code: ECUnauthorized, Reason: "Unauthorized"}, err)
+	tc.SetCredentials("user", "pass")
+	relayed, err := tc.Allocate()
+	assert.NoError(t, err)
+	assert.Equal(t, cconn.LocalAddr().String(), tc.MappedAddr().String())
+	assert.Equal(t, DefaultAllocationLifetime, tc.Lifetime())
+	_, err = tc.Allocate()
+	assert.Equal(t, &StunError{Code: ECAllocationMismatch, Reason: "Allocation Mismatch"}, err)
+
+	assert.NoError(t, tc.CreatePermission(peer.LocalAddr().(*net.UDPAddr)))
+	_, err = tc.WriteTo([]byte("to peer"), peer.LocalAddr())
+	assert.NoError(t, err)
+	s, from := readPeer(t, peer)
+	assert.Equal(t, "to peer", s)
+	assert.Equal(t, relayed.String(), from.String())
+	peer.WriteTo([]byte("to client"), relayed)
+	s, from = readTurn(t, tc)
+	assert.Equal(t, "to client", s)
+	assert.Equal(t, peer.LocalAddr().String(), from.String())
+
+	assert.NoError(t, tc.Refresh(30*time.Minute))
+	assert.Equal(t, 30*time.Minute, tc.Lifetime())
+	assert.Equal(t, ErrNoMobilityTicket, tc.Migrate(peer))
+	mconn := listenLoopback(t)
+	defer mconn.Close()
+	_, err = NewTurnClient(mconn, sconn.LocalAddr()).SetCredentials("user", "pass").SetMobility(true).Allocate()
+	assert.Equal(t, &StunError{Code: ECMobilityForbidden, Reason: "Mobility Forbidden"}, err)
+	assert.NoError(t, tc.Close())
+	assert.Equal(t, ErrStopped, tc.Refresh(time.Minute))
+	//conn is free to be read again, the allocation on it is gone
+	err = NewTurnClient(cconn, sconn.LocalAddr()).SetCredentials("user", "pass").Refresh(time.Minute)
+	assert.Equal(t, &StunError{Code: ECAllocationMismatch, Reason: "Allocation Mismatch"}, err)
+}
+
+func TestTurnMobility(t *testing.T) {
+	ts, sconn := startTurnServer(t, true)
+	defer sconn.Close()
+	c1 := listenLoopback(t)
+	defer c1.Close()
+	c2 := listenLoopback(t)
+	defer c2.Close()
+	peer := listenLoopback(t)
+	defer peer.Close()
+
+	tc := NewTurnClient(c1, sconn.LocalAddr()).SetCredentials("user", "pass").SetMobility(true)
+	relayed, err := tc.Allocate()
+	assert.NoError(t, err)
+	assert.NoError(t, tc.CreatePermission(peer.LocalAddr().(*net.UDPAddr)))
+
+	assert.NoError(t, tc.Migrate(c2))
+	c1.Close()
+	peer.WriteTo([]byte("moved"), relayed)
+	s, _ := readTurn(t, tc)
+	assert.Equal(t, "moved", s)
+	tc.WriteTo([]byte("from new conn"), peer.LocalAddr())
+	s, from := readPeer(t, peer)
+	assert.Equal(t, "from new conn", s)
+	assert.Equal(t, relayed.String(), from.String())
+	assert.Equal(t, relayed.String(), tc.RelayedAddr().String())
+
+	c3 := listenLoopback(t)
+	defer c3.Close()
+	c4 := listenLoopback(t)
+	defer c4.Close()
+	other := NewTurnClient(c3, sconn.LocalAddr()).SetCredentials("user", "pass")
+	other.ticket = []byte("not a real ticket")
+	assert.Equal(t, &StunError{Code: ECBadRequest, Reason: "Bad Request"}, other.Migrate(c4))
+
+	assert.NoError(t, tc.Close())
+	ts.lock.Lock()
+	assert.Equal(t, 0, len(ts.allocations))
+	assert.Equal(t, 0, len(ts.tickets))
+	ts.lock.Unlock()
+}
+
+func TestTurnExpiry(t *testing.T) {
+	ts, sconn := startTurnServer(t, false)
+	defer sconn.Close()
+	cconn := listenLoopback(t)
+	defer cconn.Close()
+	peer := listenLoopback(t)
+	defer peer.Close()
+
+	tc := NewTurnClient(cconn, sconn.LocalAddr()).SetCredentials("user", "pass")
+	_, err := tc.Allocate()
+	assert.NoError(t, err)
+	assert.NoError(t, tc.CreatePermission(peer.LocalAddr().(*net.UDPAddr)))
+
+	//an allocation past its lifetime relays nothing before its timer frees it
+	ts.lock.Lock()
+	a := ts.allocations[allocationKey(cconn.LocalAddr())]
+	a.timer.Stop()
+	a.expires = time.Now()
+	ts.lock.Unlock()
+	tc.WriteTo([]byte("to peer"), peer.LocalAddr())
+	peer.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = peer.ReadFrom(make([]byte, 100))
+	assert.Error(t, err)
+
+	ts.lock.Lock()
+	a.timer.Reset(time.Millisecond)
+	ts.lock.Unlock()
+	assert.Eventually(t, func() bool {
+		ts.lock.Lock()
+		defer ts.lock.Unlock()
+		return len(ts.allocations) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	err = tc.Refresh(time.Minute)
+	assert.Equal(t, &StunError{Code: ECAllocationMismatch, Reason: "Allocation Mismatch"}, err)
+}
+
+func TestTurnTCP(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	ts := NewTurnServer(nil).SetRelayIP(net.IPv4(127, 0, 0, 1)).SetLongTermAuth("example.org", func(username string) ([]byte, bool) {
+		key, _ := LongTermKey(username, "example.org", "pass")
+		return key, true
+	})
+	go ts.ServeStream(l)
+	pl, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pl.Close()
+
+	tc, err := NewTurnTCPClient(func() (net.Conn, error) {
+		return net.Dial("tcp4", l.Addr().String())
+	})
+	assert.NoError(t, err)
+	tc.SetCredentials("user", "pass")
+	relayed, err := tc.Allocate()
+	assert.NoError(t, err)
+	peer := pl.Addr().(*net.TCPAddr)
+	_, err = tc.Connect(peer)
+	assert.Equal(t, &StunError{Code: ECForbidden, Reason: "Forbidden"}, err)
+	assert.NoError(t, tc.CreatePermission(&net.UDPAddr{IP: peer.IP}))
+
+	//a connection to the peer
+	c, err := tc.Connect(peer)
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, peer.String(), c.RemoteAddr().String())
+	pc, err := pl.Accept()
+	assert.NoError(t, err)
+	defer pc.Close()
+	assertRelayed(t, c, pc)
+	_, err = tc.Connect(peer)
+	assert.Equal(t, &StunError{Code: ECConnectionAlreadyExists, Reason: "Connection Already Exists"}, err)
+	_, err = tc.bind(12345, peer)
+	assert.Equal(t, &StunError{Code: ECBadRequest, Reason: "Bad Request"}, err)
+
+	//a connection from a peer
+	pc2, err := net.Dial("tcp4", relayed.String())
+	assert.NoError(t, err)
+	defer pc2.Close()
+	c2, err := tc.Accept()
+	assert.NoError(t, err)
+	defer c2.Close()
+	assert.Equal(t, pc2.LocalAddr().String(), c2.RemoteAddr().String())
+	assertRelayed(t, c2, pc2)
+
+	assert.NoError(t, tc.Close())
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = pc.Read(make([]byte, 10))
+	assert.Error(t, err)
+	ts.lock.Lock()
+	assert.Equal(t, 0, len(ts.allocations))
+	assert.Equal(t, 0, len(ts.connections))
+	ts.lock.Unlock()
+}
+
+func TestTurnStreamIdle(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	go NewTurnServer(nil).SetRelayIP(net.IPv4(127, 0, 0, 1)).SetIdleTimeout(50 * time.Millisecond).ServeStream(l)
+
+	//a connection that never sends, and one idle after a Binding request, are closed
+	for _, send := range []bool{false, true} {
+		c, err := net.Dial("tcp4", l.Addr().String())
+		assert.NoError(t, err)
+		if send {
+			_, err = c.Write(NewStunPacketBuilder().Build().GetBytes())
+			assert.NoError(t, err)
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, err = ReadStunPacket(c)
+			assert.NoError(t, err)
+		}
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = c.Read(make([]byte, 10))
+		assert.Equal(t, io.EOF, err)
+		c.Close()
+	}
+}
+
+func TestTurnTCPOverUDP(t *testing.T) {
+	ts := NewTurnServer(nil)
+	from := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+	req := NewStunPacketBuilder().SetStunMessage(NewStunMessage(MethodAllocate, ClassRequest)).
+		SetRequestedTransport(TransportTCP).Build()
+	code, _, err := ts.HandlePacket(req, from).GetErrorCode()
+	assert.NoError(t, err)
+	assert.Equal(t, ECBadRequest, code)
+	req = NewStunPacketBuilder().SetStunMessage(NewStunMessage(MethodConnect, ClassRequest)).
+		SetXORPeerAddress(from).Build()
+	code, _, err = ts.HandlePacket(req, from).GetErrorCode()
+	assert.NoError(t, err)
+	assert.Equal(t, ECAllocationMismatch, code)
+}
+
+func TestTurnAllocationQuota(t *testing.T) {
+	ts := NewTurnServer(nil).SetRelayIP(net.IPv4(127, 0, 0, 1)).SetAllocationQuota(1)
+	allocate := func(from *net.UDPAddr) int {
+		req := NewStunPacketBuilder().SetStunMessage(NewStunMessage(MethodAllocate, ClassRequest)).
+			SetRequestedTransport(TransportUDP).Build()
+		code, _, _ := ts.HandlePacket(req, from).GetErrorCode()
+		return code
+	}
+	assert.Equal(t, 0, allocate(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}))
+	assert.Equal(t, ECAllocationQuotaReached, allocate(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5001}))
+	assert.Equal(t, 0, allocate(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 5000}))
+
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	ts.free(ts.allocations[allocationKey(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000})])
+	assert.Equal(t, map[string]int{"192.0.2.2": 1}, ts.quotas)
+	for _, a := range ts.allocations {
+		ts.free(a)
+	}
+}
+
+func assertRelayed(t *testing.T, a net.Conn, b net.Conn) {
+	ba := make([]byte, 100)
+	_, err := a.Write([]byte("ping"))
+	assert.NoError(t, err)
+	b.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := b.Read(ba)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(ba[:n]))
+	_, err = b.Write([]byte("pong"))
+	assert.NoError(t, err)
+	a.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err = a.Read(ba)
+	assert.NoError(t, err)
+	assert.Equal(t, "pong", string(ba[:n]))
+}
+
+func TestTurnMalformedAllocate(t *testing.T) {
+	_, sconn := startTurnServer(t, false)
+	defer sconn.Close()
+	cconn := listenLoopback(t)
+	defer cconn.Close()
+
+	//a REQUESTED-TRANSPORT claiming 200 bytes in an 8 byte attribute
+	ba := NewStunPacketBuilder().SetStunMessage(NewStunMessage(MethodAllocate, ClassRequest)).
+		SetRequestedTransport(TransportUDP).Build().GetBytes()
+	binary.BigEndian.PutUint16(ba[22:24], 200)
+	_, err := NewStunPacket(ba)
+	assert.Equal(t, ErrInvalidAttributeSize, err)
+	_, err = cconn.WriteTo(ba, sconn.LocalAddr())
+	assert.NoError(t, err)
+
+	tc := NewTurnClient(cconn, sconn.LocalAddr()).SetCredentials("user", "pass")
+	_, err = tc.Allocate()
+	assert.NoError(t, err)
+	assert.NoError(t, tc.Close())
+}
@@ -0,0 +1,394 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+type turnData struct {
+	peer *net.UDPAddr
+	ba   []byte
+}
+
+type connectionAttempt struct {
+	id   uint32
+	peer *net.UDPAddr
+}
+
+//TurnClient makes and uses an rfc5766 TURN allocation for UDP. Data from peers
+//comes in Data indications and is sent in Send indications, ChannelBind is not
+//used. With mobility on, the allocation can be moved to a new net.PacketConn
+//using rfc8016 MobilityTickets, like when a phone switches networks.
+//A TurnClient from NewTurnTCPClient makes an rfc6062 TCP allocation instead,
+//where each peer connection is a net.Conn from Connect or Accept
+type TurnClient struct {
+	lock      sync.Mutex
+	server    net.Addr
+	conn      net.PacketConn
+	sc        *StunClient
+	auth      *clientAuth
+	username  string
+	password  string
+	mobility  bool
+	transport uint8
+	dial      func() (net.Conn, error)
+	relayed   *net.UDPAddr
+	mapped    *net.UDPAddr
+	lifetime  time.Duration
+	ticket    []byte
+	data      chan turnData
+	attempts  chan connectionAttempt
+	done      chan struct{}
+	once      sync.Once
+}
+
+//relayedConn is a data connection to the server bound to a peer connection
+type relayedConn struct {
+	net.Conn
+	peer net.Addr
+}
+
+//RemoteAddr returns the address of the peer
+func (rc *relayedConn) RemoteAddr() net.Addr {
+	return rc.peer
+}
+
+//NewTurnClient creates a TurnClient for the TURN server. It reads conn with a
+//TransactionManager from now on, so conn should not be read by anything else
+func NewTurnClient(conn net.PacketConn, server net.Addr) *TurnClient {
+	tc := &TurnClient{
+		server:    server,
+		auth:      &clientAuth{},
+		transport: TransportUDP,
+		data:      make(chan turnData, 64),
+		attempts:  make(chan connectionAttempt, 16),
+		done:      make(chan struct{}),
+	}
+	tc.conn = conn
+	tc.sc = tc.newStunClient(conn)
+	return tc
+}
+
+//NewTurnTCPClient creates a TurnClient for an rfc6062 TCP allocation. dial
+//connects to the TURN server, it is used for the control connection now and for
+//a data connection with each peer, so it can add TLS. WriteTo, ReadFrom and
+//Migrate are not used with TCP allocations
+func NewTurnTCPClient(dial func() (net.Conn, error)) (*TurnClient, error) {
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	tc := NewTurnClient(NewStreamPacketConn(c), c.RemoteAddr())
+	tc.transport = TransportTCP
+	tc.dial = dial
+	//the one wait on a stream is lastWaitRTOs of the rto
+	tc.sc.SetRTO(StreamRTO / lastWaitRTOs).SetRetransmits(0)
+	return tc, nil
+}
+
+func (tc *TurnClient) newStunClient(conn net.PacketConn) *StunClient {
+	tm := NewTransactionManager(conn).SetHandler(tc.handleIndication)
+	go tm.Serve()
+	return NewStunClient(conn).SetTransactionManager(tm).SetCredentials(tc.username, tc.password)
+}
+
+//SetCredentials sets the username and password for the servers long-term credentials
+func (tc *TurnClient) SetCredentials(username string, password string) *TurnClient {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.username = username
+	tc.password = password
+	tc.auth = &clientAuth{}
+	tc.sc.SetCredentials(username, password)
+	return tc
+}
+
+//SetMobility makes Allocate ask for an rfc8016 MobilityTicket so Migrate can be used
+func (tc *TurnClient) SetMobility(mobility bool) *TurnClient {
+	tc.mobility = mobility
+	return tc
+}
+
+//RelayedAddr returns the relayed address of the allocation, peers send to it
+func (tc *TurnClient) RelayedAddr() *net.UDPAddr {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	return tc.relayed
+}
+
+//MappedAddr returns the mapped address the server saw the Allocate come from
+func (tc *TurnClient) MappedAddr() *net.UDPAddr {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	return tc.mapped
+}
+
+//Lifetime returns how long the server will keep the allocation without a Refresh
+func (tc *TurnClient) Lifetime() time.Duration {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	return tc.lifetime
+}
+
+//Allocate asks the server for an allocation and returns the relayed address. For
+//TCP allocations only the ip and port of it matter
+func (tc *TurnClient) Allocate() (*net.UDPAddr, error) {
+	resp, err := tc.do(tc.stunClient(), MethodAllocate, func(spb *StunPacketBuilder) {
+		spb.SetRequestedTransport(tc.transport)
+		if tc.mobility {
+			spb.SetMobilityTicket(nil)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	relayed, err := resp.GetXORRelayedAddress()
+	if err != nil {
+		return nil, err
+	}
+	mapped, _ := resp.GetAddressAttribute(SAXORMappedAddress)
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.relayed = relayed
+	tc.mapped = mapped
+	tc.update(resp)
+	return relayed, nil
+}
+
+//Refresh keeps the allocation for the lifetime, 0 frees it
+func (tc *TurnClient) Refresh(lifetime time.Duration) error {
+	resp, err := tc.do(tc.stunClient(), MethodRefresh, func(spb *StunPacketBuilder) {
+		spb.SetLifetime(lifetime)
+	})
+	if err != nil {
+		return err
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.update(resp)
+	return nil
+}
+
+//CreatePermission lets the peers send to the relayed address, and lets data be
+//sent to them. Only the ip of each peer matters
+func (tc *TurnClient) CreatePermission(peers ...*net.UDPAddr) error {
+	_, err := tc.do(tc.stunClient(), MethodCreatePermission, func(spb *StunPacketBuilder) {
+		tid := spb.createTID()
+		spb.SetTXID(tid)
+		for _, peer := range peers {
+			spb.SetAttribue(SAXORPeerAddress, CreateMaskedAddress(*tid, peer))
+		}
+	})
+	return err
+}
+
+//Connect asks the server to open an rfc6062 TCP connection to the peer from a
+//TCP allocation, and returns a net.Conn relayed to it. There has to be a
+//permission for the peer
+func (tc *TurnClient) Connect(peer *net.TCPAddr) (net.Conn, error) {
+	resp, err := tc.do(tc.stunClient(), MethodConnect, func(spb *StunPacketBuilder) {
+		spb.SetXORPeerAddress(toUDPAddr(peer))
+	})
+	if err != nil {
+		return nil, err
+	}
+	id, err := resp.GetConnectionID()
+	if err != nil {
+		return nil, err
+	}
+	return tc.bind(id, peer)
+}
+
+//Accept waits for a permitted peer to connect to the relayed address of a TCP
+//allocation, and returns a net.Conn relayed to it
+func (tc *TurnClient) Accept() (net.Conn, error) {
+	select {
+	case ca := <-tc.attempts:
+		return tc.bind(ca.id, &net.TCPAddr{IP: ca.peer.IP, Port: ca.peer.Port, Zone: ca.peer.Zone})
+	case <-tc.done:
+		return nil, net.ErrClosed
+	}
+}
+
+//bind opens a data connection to the server and binds it to a peer connection
+func (tc *TurnClient) bind(id uint32, peer net.Addr) (net.Conn, error) {
+	if tc.dial == nil {
+		return nil, ErrUnknownTransport
+	}
+	c, err := tc.dial()
+	if err != nil {
+		return nil, err
+	}
+	tc.lock.Lock()
+	sc := NewStunClient(NewStreamPacketConn(c)).SetRTO(StreamRTO/lastWaitRTOs).SetRetransmits(0).
+		SetCredentials(tc.username, tc.password)
+	tc.lock.Unlock()
+	_, err = tc.do(sc, MethodConnectionBind, func(spb *StunPacketBuilder) {
+		spb.SetConnectionID(id)
+	})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	//rfc6062, after the ConnectionBind the data connection only has the peers data
+	return &relayedConn{Conn: c, peer: peer}, nil
+}
+
+//Migrate moves the allocation to conn with its MobilityTicket, all traffic uses
+//conn after it returns. The old net.PacketConn is not closed but is no longer
+//read, if Migrate fails it is still used
+func (tc *TurnClient) Migrate(conn net.PacketConn) error {
+	tc.lock.Lock()
+	ticket := tc.ticket
+	tc.lock.Unlock()
+	if ticket == nil {
+		return ErrNoMobilityTicket
+	}
+	sc := tc.newStunClient(conn)
+	resp, err := tc.do(sc, MethodRefresh, func(spb *StunPacketBuilder) {
+		spb.SetMobilityTicket(ticket)
+	})
+	if err != nil {
+		sc.tm.Stop()
+		return err
+	}
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.sc.tm.Stop()
+	tc.conn = conn
+	tc.sc = sc
+	tc.update(resp)
+	return nil
+}
+
+//WriteTo sends data to a peer through the relayed address in a Send indication,
+//there has to be a permission for the peer
+func (tc *TurnClient) WriteTo(ba []byte, addr net.Addr) (int, error) {
+	peer := toUDPAddr(addr)
+	if peer == nil {
+		return 0, ErrInvalidFamily
+	}
+	ind := NewStunPacketBuilder().SetStunMessage(NewStunMessage(MethodSend, ClassIndication)).
+		SetXORPeerAddress(peer).SetData(ba).Build()
+	tc.lock.Lock()
+	conn := tc.conn
+	tc.lock.Unlock()
+	if _, err := conn.WriteTo(ind.GetBytes(), tc.server); err != nil {
+		return 0, err
+	}
+	return len(ba), nil
+}
+
+//ReadFrom returns the next data a peer sent to the relayed address
+func (tc *TurnClient) ReadFrom(ba []byte) (int, net.Addr, error) {
+	select {
+	case d := <-tc.data:
+		return copy(ba, d.ba), d.peer, nil
+	case <-tc.done:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+//Close frees the allocation on the server and stops ReadFrom and Accept. The
+//net.PacketConn is not closed, except the control connection of NewTurnTCPClient,
+//and can be read by something else again
+func (tc *TurnClient) Close() error {
+	var err error
+	tc.once.Do(func() {
+		if tc.RelayedAddr() != nil {
+			err = tc.Refresh(0)
+		}
+		close(tc.done)
+		if tc.dial != nil {
+			tc.conn.Close()
+		}
+		tc.stunClient().tm.Stop()
+	})
+	return err
+}
+
+func (tc *TurnClient) stunClient() *StunClient {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	return tc.sc
+}
+
+//update takes the lifetime and any new MobilityTicket from a response, lock must be held
+func (tc *TurnClient) update(resp *StunPacket) {
+	if lt, err := resp.GetLifetime(); err == nil {
+		tc.lifetime = lt
+	}
+	if ticket, ok := resp.GetMobilityTicket(); ok && len(ticket) > 0 {
+		tc.ticket = append([]byte(nil), ticket...)
+	}
+}
+
+//do sends a request, answering long-term credential challenges, and returns the success response
+func (tc *TurnClient) do(sc *StunClient, method StunMethod, build func(spb *StunPacketBuilder)) (*StunPacket, error) {
+	for {
+		spb := NewStunPacketBuilder().SetStunMessage(NewStunMessage(method, ClassRequest))
+		build(spb)
+		tc.lock.Lock()
+		err := tc.auth.sign(sc, spb)
+		key := tc.auth.key
+		tc.lock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := sc.Do(tc.server, spb.Build())
+		if err != nil {
+			return nil, err
+		}
+		if resp.GetStunMessageType().Class() == ClassFailure {
+			code, reason, err := resp.GetErrorCode()
+			if err != nil {
+				return nil, err
+			}
+			tc.lock.Lock()
+			retry := (code == ECUnauthorized || code == ECStaleNonce) && tc.auth.challenged(sc, resp, code)
+			tc.lock.Unlock()
+			if retry {
+				continue
+			}
+			return nil, &StunError{Code: code, Reason: reason}
+		}
+		if key != nil && !resp.VerifyMessageIntegrity(key) {
+			return nil, ErrBadIntegrity
+		}
+		tc.lock.Lock()
+		tc.auth.tries = 0
+		tc.lock.Unlock()
+		return resp, nil
+	}
+}
+
+//handleIndication is the RequestHandler for the TransactionManager, it takes
+//the data out of Data indications and the peer connections out of
+//ConnectionAttempt indications from the server
+func (tc *TurnClient) handleIndication(sp *StunPacket, addr net.Addr) *StunPacket {
+	if addr.String() != tc.server.String() {
+		return nil
+	}
+	peer, err := sp.GetXORPeerAddress()
+	if err != nil {
+		return nil
+	}
+	switch sp.GetStunMessageType() {
+	case NewStunMessage(MethodData, ClassIndication):
+		select {
+		case tc.data <- turnData{peer: peer, ba: append([]byte(nil), sp.GetData()...)}:
+		default:
+		}
+	case NewStunMessage(MethodConnectionAttempt, ClassIndication):
+		id, err := sp.GetConnectionID()
+		if err != nil {
+			return nil
+		}
+		select {
+		case tc.attempts <- connectionAttempt{id: id, peer: peer}:
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,721 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	crypto_rand "crypto/rand"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+type allocation struct {
+	client      net.Addr
+	conn        net.PacketConn
+	relay       net.PacketConn
+	listener    net.Listener
+	username    string
+	quotaKey    string
+	expires     time.Time
+	timer       *time.Timer
+	permissions map[string]time.Time
+	ticket      []byte
+	connections map[uint32]*peerConnection
+}
+
+//peerConnection is an rfc6062 TCP connection with a peer, relayed to the client
+//once a data connection is bound to it
+type peerConnection struct {
+	id    uint32
+	alloc *allocation
+	peer  net.Conn
+	data  net.Conn
+	timer *time.Timer
+}
+
+//TurnServer is a minimal rfc5766 TURN server relaying UDP with Send and Data
+//indications, and rfc8016 mobility so an allocation can move to a new 5-tuple.
+//With ServeStream it also takes TCP control connections and makes rfc6062 TCP
+//allocations. Binding requests are answered like a StunServer. ChannelBind is
+//not supported.
+//
+//Without SetLongTermAuth the TurnServer is an open relay, anyone that can reach
+//it can use it to send to any address the server can reach, including ones
+//behind the same firewall. Only leave it off on a trusted network
+type TurnServer struct {
+	conn        net.PacketConn
+	tm          *TransactionManager
+	stun        *StunServer
+	auth        *longTermAuth
+	relayIP     net.IP
+	mobility    bool
+	quota       int
+	idleTimeout time.Duration
+	logger      *slog.Logger
+	lock        sync.Mutex
+	quotas      map[string]int
+	allocations map[string]*allocation
+	tickets     map[string]*allocation
+	connections map[uint32]*peerConnection
+	//err is the first invalid setting, it is returned by the Serve methods
+	err error
+}
+
+//NewTurnServer creates a TurnServer answering on the provided net.PacketConn.
+//Relayed addresses are opened on the ip conn is listening on. conn can be nil
+//when only ServeStream is used, then SetRelayIP should be used. The TurnServer is
+//an open relay until SetLongTermAuth is set
+func NewTurnServer(conn net.PacketConn) *TurnServer {
+	ts := &TurnServer{
+		conn:        conn,
+		stun:        NewStunServer(conn),
+		quota:       DefaultAllocationQuota,
+		idleTimeout: ConnectionTimeout,
+		quotas:      make(map[string]int),
+		allocations: make(map[string]*allocation),
+		tickets:     make(map[string]*allocation),
+		connections: make(map[uint32]*peerConnection),
+	}
+	if conn != nil {
+		if ua := toUDPAddr(conn.LocalAddr()); ua != nil {
+			ts.relayIP = ua.IP
+		}
+	}
+	ts.tm = NewTransactionManager(conn).SetHandler(ts.HandlePacket)
+	return ts
+}
+
+//SetLongTermAuth makes the server require the rfc5389 long-term credential
+//mechanism for TURN requests, see StunServer.SetLongTermAuth. Binding requests
+//are still answered without it. When realm can not be used as an SARealm the
+//Serve methods return the error
+func (ts *TurnServer) SetLongTermAuth(realm string, keys func(username string) ([]byte, bool)) *TurnServer {
+	lta, err := newLongTermAuth(realm, keys)
+	if err != nil {
+		if ts.err == nil {
+			ts.err = err
+		}
+		return ts
+	}
+	ts.auth = lta
+	return ts
+}
+
+//SetRelayIP sets the ip relayed addresses are opened on, it has to be set when
+//conn is listening on all ips
+func (ts *TurnServer) SetRelayIP(ip net.IP) *TurnServer {
+	ts.relayIP = ip
+	return ts
+}
+
+//SetMobility turns on rfc8016 mobility, Allocate requests with an
+//SAMobilityTicket get a ticket that can move the allocation to a new 5-tuple.
+//When off those requests get a 405 Mobility Forbidden
+func (ts *TurnServer) SetMobility(mobility bool) *TurnServer {
+	ts.mobility = mobility
+	return ts
+}
+
+//SetAllocationQuota sets how many allocations one username, or one ip when there
+//is no long-term auth, can have at once. Past it Allocate requests get a 486
+//Allocation Quota Reached. 0 is no limit, the default is DefaultAllocationQuota
+func (ts *TurnServer) SetAllocationQuota(quota int) *TurnServer {
+	ts.quota = quota
+	return ts
+}
+
+//SetIdleTimeout sets how long a TCP or TLS connection from ServeStream can wait
+//before its first message, and how long a control connection without an
+//allocation can be idle. A control connection with an allocation is closed when
+//the allocation expires. The default is ConnectionTimeout
+func (ts *TurnServer) SetIdleTimeout(d time.Duration) *TurnServer {
+	ts.idleTimeout = d
+	return ts
+}
+
+//SetLogger sets the slog.Logger used to log allocations and failed requests, nil disables logging
+func (ts *TurnServer) SetLogger(logger *slog.Logger) *TurnServer {
+	ts.logger = logger
+	ts.stun.SetLogger(logger)
+	ts.tm.SetLogger(logger)
+	return ts
+}
+
+//Serve answers requests until the net.PacketConn is closed, then frees every allocation
+func (ts *TurnServer) Serve() error {
+	if ts.err != nil {
+		return ts.err
+	}
+	ts.warnOpenRelay()
+	err := ts.tm.Serve()
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	for _, a := range ts.allocations {
+		ts.free(a)
+	}
+	return err
+}
+
+//ServeStream accepts TCP or TLS connections from a net.Listener until Accept
+//fails. A connection starting with a ConnectionBind request is an rfc6062 data
+//connection, anything else is a control connection that is answered like the
+//net.PacketConn. The allocation of a control connection is freed when it closes
+func (ts *TurnServer) ServeStream(l net.Listener) error {
+	if ts.err != nil {
+		return ts.err
+	}
+	ts.warnOpenRelay()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go ts.serveConn(c)
+	}
+}
+
+func (ts *TurnServer) warnOpenRelay() {
+	if ts.auth == nil && ts.logger != nil {
+		ts.logger.Warn("TURN server has no long-term auth, it is an open relay")
+	}
+}
+
+func (ts *TurnServer) serveConn(c net.Conn) {
+	c.SetReadDeadline(time.Now().Add(ts.idleTimeout))
+	sp, err := ReadStunPacket(c)
+	if err != nil {
+		c.Close()
+		return
+	}
+	if sp.GetStunMessageType() == NewStunMessage(MethodConnectionBind, ClassRequest) {
+		ts.serveDataConn(sp, c)
+		return
+	}
+	conn := NewStreamPacketConn(c)
+	defer ts.closeControl(conn)
+	for {
+		resp := ts.handle(sp, c.RemoteAddr(), conn)
+		if resp != nil {
+			if _, err := c.Write(resp.GetBytes()); err != nil {
+				return
+			}
+		}
+		c.SetReadDeadline(ts.idleDeadline(c.RemoteAddr()))
+		if sp, err = ReadStunPacket(c); err != nil {
+			return
+		}
+	}
+}
+
+//idleDeadline is when a control connection that sends nothing is closed, when
+//its allocation expires or after the idle timeout without one
+func (ts *TurnServer) idleDeadline(addr net.Addr) time.Time {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if a, ok := ts.allocations[allocationKey(addr)]; ok {
+		return a.expires
+	}
+	return time.Now().Add(ts.idleTimeout)
+}
+
+//closeControl closes a control connection and frees the allocation made on it
+func (ts *TurnServer) closeControl(conn net.PacketConn) {
+	conn.Close()
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	for _, a := range ts.allocations {
+		if a.conn == conn {
+			ts.free(a)
+		}
+	}
+}
+
+//HandlePacket creates the response for a StunPacket received from addr, it is
+//the RequestHandler Serve uses. nil is returned when nothing should be sent back
+func (ts *TurnServer) HandlePacket(sp *StunPacket, addr net.Addr) *StunPacket {
+	return ts.handle(sp, addr, ts.conn)
+}
+
+//handle is HandlePacket for a StunPacket that came in on conn
+func (ts *TurnServer) handle(sp *StunPacket, addr net.Addr, conn net.PacketConn) *StunPacket {
+	sm := sp.GetStunMessageType()
+	switch {
+	case sm == SMRequest:
+		return ts.stun.HandlePacket(sp, addr)
+	case sm == NewStunMessage(MethodSend, ClassIndication):
+		ts.send(sp, addr)
+		return nil
+	case sm.Class() != ClassRequest:
+		return nil
+	}
+	spb := sp.ToBuilder().ClearAttributes().AddFingerprint(sp.HasFingerPrint()).
+		SetStunMessage(NewStunMessage(sm.Method(), ClassSuccess))
+	username := ""
+	if ts.auth != nil {
+		key, failed := ts.auth.check(sp, spb)
+		if failed {
+			return spb.Build()
+		}
+		username, _ = sp.GetUsername()
+		spb.SetMessageIntegrity(key)
+	}
+	switch sm.Method() {
+	case MethodAllocate:
+		ts.allocate(sp, addr, conn, username, spb)
+	case MethodRefresh:
+		ts.refresh(sp, addr, conn, username, spb)
+	case MethodCreatePermission:
+		ts.createPermission(sp, addr, spb)
+	case MethodConnect:
+		ts.connect(sp, addr, spb)
+	default:
+		turnError(spb, ECBadRequest, "Bad Request")
+	}
+	resp := spb.Build()
+	if resp.GetStunMessageType().Class() == ClassFailure {
+		logPacket(ts.logger, slog.LevelDebug, "Sent error response", resp, addr)
+	}
+	return resp
+}
+
+func turnError(spb *StunPacketBuilder, code int, reason string) {
+	spb.SetStunMessage(NewStunMessage(spb.mt.Method(), ClassFailure)).SetErrorCode(code, reason)
+}
+
+//allocationLifetime picks the lifetime from an SALifetime, between the default and the max
+func allocationLifetime(sp *StunPacket) time.Duration {
+	lt, err := sp.GetLifetime()
+	if err != nil || lt < DefaultAllocationLifetime {
+		return DefaultAllocationLifetime
+	}
+	if lt > MaxAllocationLifetime {
+		return MaxAllocationLifetime
+	}
+	return lt
+}
+
+//allocationKey is the key of the allocation for the 5-tuple a client sends from,
+//it has the network so udp and tcp clients on the same port do not collide
+func allocationKey(addr net.Addr) string {
+	return addr.Network() + "/" + addr.String()
+}
+
+func (ts *TurnServer) allocate(sp *StunPacket, addr net.Addr, conn net.PacketConn, username string, spb *StunPacketBuilder) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if _, ok := ts.allocations[allocationKey(addr)]; ok {
+		turnError(spb, ECAllocationMismatch, "Allocation Mismatch")
+		return
+	}
+	protocol, err := sp.GetRequestedTransport()
+	if err != nil {
+		turnError(spb, ECBadRequest, "Bad Request")
+		return
+	}
+	switch {
+	case protocol == TransportTCP && conn == ts.conn:
+		//rfc6062, TCP allocations need a TCP control connection
+		turnError(spb, ECBadRequest, "Bad Request")
+		return
+	case protocol != TransportUDP && protocol != TransportTCP:
+		turnError(spb, ECUnsupportedTransport, "Unsupported Transport Protocol")
+		return
+	}
+	_, mobile := sp.GetMobilityTicket()
+	if mobile && !ts.mobility {
+		turnError(spb, ECMobilityForbidden, "Mobility Forbidden")
+		return
+	}
+	quotaKey := username
+	if quotaKey == "" {
+		quotaKey = toUDPAddr(addr).IP.String()
+	}
+	if ts.quota > 0 && ts.quotas[quotaKey] >= ts.quota {
+		turnError(spb, ECAllocationQuotaReached, "Allocation Quota Reached")
+		return
+	}
+	lt := allocationLifetime(sp)
+	a := &allocation{
+		client:      addr,
+		conn:        conn,
+		username:    username,
+		quotaKey:    quotaKey,
+		expires:     time.Now().Add(lt),
+		permissions: make(map[string]time.Time),
+		connections: make(map[uint32]*peerConnection),
+	}
+	var relayed net.Addr
+	if protocol == TransportTCP {
+		a.listener, err = net.ListenTCP("tcp", &net.TCPAddr{IP: ts.relayIP})
+		if err == nil {
+			relayed = a.listener.Addr()
+			go ts.acceptPeers(a)
+		}
+	} else {
+		a.relay, err = net.ListenUDP("udp", &net.UDPAddr{IP: ts.relayIP})
+		if err == nil {
+			relayed = a.relay.LocalAddr()
+			go ts.relayFromPeers(a)
+		}
+	}
+	if err != nil {
+		turnError(spb, ECInsufficientCapacity, "Insufficient Capacity")
+		return
+	}
+	ts.allocations[allocationKey(addr)] = a
+	ts.quotas[quotaKey]++
+	a.timer = time.AfterFunc(lt, func() { ts.expire(a) })
+	spb.SetXORRelayedAddress(toUDPAddr(relayed)).SetXORAddress(toUDPAddr(addr)).SetLifetime(lt)
+	if mobile {
+		spb.SetMobilityTicket(ts.newTicket(a))
+	}
+	logPacket(ts.logger, slog.LevelDebug, "Allocated relay", sp, addr, slog.String("relayed", relayed.String()))
+}
+
+func (ts *TurnServer) refresh(sp *StunPacket, addr net.Addr, conn net.PacketConn, username string, spb *StunPacketBuilder) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	a, ok := ts.allocations[allocationKey(addr)]
+	if ticket, _ := sp.GetMobilityTicket(); len(ticket) > 0 {
+		if !ts.mobility {
+			turnError(spb, ECMobilityForbidden, "Mobility Forbidden")
+			return
+		}
+		ta, tok := ts.tickets[string(ticket)]
+		if !tok {
+			turnError(spb, ECBadRequest, "Bad Request")
+			return
+		}
+		if ok && a != ta {
+			turnError(spb, ECAllocationMismatch, "Allocation Mismatch")
+			return
+		}
+		if ta.username != username {
+			turnError(spb, ECWrongCredentials, "Wrong Credentials")
+			return
+		}
+		if !ok {
+			//rfc8016, the allocation moves to the 5-tuple the ticket came from
+			logPacket(ts.logger, slog.LevelDebug, "Moved allocation", sp, addr, slog.String("from", ta.client.String()))
+			delete(ts.allocations, allocationKey(ta.client))
+			ta.client = addr
+			ta.conn = conn
+			ts.allocations[allocationKey(addr)] = ta
+		}
+		a = ta
+	} else if !ok {
+		turnError(spb, ECAllocationMismatch, "Allocation Mismatch")
+		return
+	} else if a.username != username {
+		turnError(spb, ECWrongCredentials, "Wrong Credentials")
+		return
+	}
+	if lt, err := sp.GetLifetime(); err == nil && lt == 0 {
+		ts.free(a)
+		spb.SetLifetime(0)
+		return
+	}
+	lt := allocationLifetime(sp)
+	a.expires = time.Now().Add(lt)
+	spb.SetLifetime(lt)
+	if a.ticket != nil {
+		spb.SetMobilityTicket(ts.newTicket(a))
+	}
+}
+
+func (ts *TurnServer) createPermission(sp *StunPacket, addr net.Addr, spb *StunPacketBuilder) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	a, ok := ts.allocations[allocationKey(addr)]
+	if !ok {
+		turnError(spb, ECAllocationMismatch, "Allocation Mismatch")
+		return
+	}
+	attrs, _ := sp.Decode()
+	peers := make([]*net.UDPAddr, 0)
+	for _, attr := range attrs {
+		if ua, ok := attr.Value.(*net.UDPAddr); ok && attr.Type == SAXORPeerAddress {
+			peers = append(peers, ua)
+		}
+	}
+	if len(peers) == 0 {
+		turnError(spb, ECBadRequest, "Bad Request")
+		return
+	}
+	for _, peer := range peers {
+		a.permissions[peer.IP.String()] = time.Now().Add(PermissionLifetime)
+	}
+}
+
+//connect opens an rfc6062 TCP connection from a TCP allocation to a peer. The
+//connection is made from the relayed ip, but not the relayed port
+func (ts *TurnServer) connect(sp *StunPacket, addr net.Addr, spb *StunPacketBuilder) {
+	peer, err := sp.GetXORPeerAddress()
+	if err != nil {
+		turnError(spb, ECBadRequest, "Bad Request")
+		return
+	}
+	ts.lock.Lock()
+	a, ok := ts.allocations[allocationKey(addr)]
+	code, reason := 0, ""
+	switch {
+	case !ok:
+		code, reason = ECAllocationMismatch, "Allocation Mismatch"
+	case a.listener == nil:
+		code, reason = ECBadRequest, "Bad Request"
+	case !a.permitted(peer.IP):
+		code, reason = ECForbidden, "Forbidden"
+	case a.connectedTo(peer):
+		code, reason = ECConnectionAlreadyExists, "Connection Already Exists"
+	}
+	ts.lock.Unlock()
+	if code != 0 {
+		turnError(spb, code, reason)
+		return
+	}
+	d := &net.Dialer{Timeout: ConnectionTimeout, LocalAddr: &net.TCPAddr{IP: ts.relayIP}}
+	c, err := d.Dial("tcp", peer.String())
+	if err != nil {
+		turnError(spb, ECConnectionTimeoutOrFailure, "Connection Timeout or Failure")
+		return
+	}
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if ts.allocations[allocationKey(addr)] != a {
+		c.Close()
+		turnError(spb, ECAllocationMismatch, "Allocation Mismatch")
+		return
+	}
+	spb.SetConnectionID(ts.newConnection(a, c).id)
+}
+
+//acceptPeers takes TCP connections from permitted peers to the relayed address
+//of a TCP allocation and tells the client with ConnectionAttempt indications
+func (ts *TurnServer) acceptPeers(a *allocation) {
+	for {
+		c, err := a.listener.Accept()
+		if err != nil {
+			return
+		}
+		peer := toUDPAddr(c.RemoteAddr())
+		ts.lock.Lock()
+		if !a.permitted(peer.IP) {
+			ts.lock.Unlock()
+			c.Close()
+			continue
+		}
+		pc := ts.newConnection(a, c)
+		conn, client := a.conn, a.client
+		ts.lock.Unlock()
+		ind := NewStunPacketBuilder().SetStunMessage(NewStunMessage(MethodConnectionAttempt, ClassIndication)).
+			SetConnectionID(pc.id).SetXORPeerAddress(peer).Build()
+		conn.WriteTo(ind.GetBytes(), client)
+	}
+}
+
+//newConnection adds a peer connection, which is closed if no ConnectionBind for
+//it comes within the ConnectionTimeout, lock must be held
+func (ts *TurnServer) newConnection(a *allocation, c net.Conn) *peerConnection {
+	pc := &peerConnection{alloc: a, peer: c}
+	ba := make([]byte, 4)
+	for pc.id == 0 || ts.connections[pc.id] != nil {
+		crypto_rand.Read(ba)
+		pc.id = binary.BigEndian.Uint32(ba)
+	}
+	ts.connections[pc.id] = pc
+	a.connections[pc.id] = pc
+	pc.timer = time.AfterFunc(ConnectionTimeout, func() {
+		ts.lock.Lock()
+		defer ts.lock.Unlock()
+		if pc.data == nil {
+			ts.closeConnection(pc)
+		}
+	})
+	return pc
+}
+
+//serveDataConn answers ConnectionBind requests on an rfc6062 data connection,
+//once one succeeds the connection is relayed to its peer
+func (ts *TurnServer) serveDataConn(sp *StunPacket, c net.Conn) {
+	for {
+		resp, pc := ts.connectionBind(sp, c)
+		_, err := c.Write(resp.GetBytes())
+		if pc != nil {
+			if err == nil {
+				//the relayed data has no deadline, it stops when the allocation is freed
+				c.SetReadDeadline(time.Time{})
+				relayConn(pc.peer, c)
+			}
+			ts.lock.Lock()
+			ts.closeConnection(pc)
+			ts.lock.Unlock()
+			return
+		}
+		//only a failed authentication can be tried again
+		if code, _, _ := resp.GetErrorCode(); err != nil || (code != ECUnauthorized && code != ECStaleNonce) {
+			break
+		}
+		c.SetReadDeadline(time.Now().Add(ts.idleTimeout))
+		if sp, err = ReadStunPacket(c); err != nil || sp.GetStunMessageType() != NewStunMessage(MethodConnectionBind, ClassRequest) {
+			break
+		}
+	}
+	c.Close()
+}
+
+func (ts *TurnServer) connectionBind(sp *StunPacket, c net.Conn) (*StunPacket, *peerConnection) {
+	spb := sp.ToBuilder().ClearAttributes().AddFingerprint(sp.HasFingerPrint()).
+		SetStunMessage(NewStunMessage(MethodConnectionBind, ClassSuccess))
+	username := ""
+	if ts.auth != nil {
+		key, failed := ts.auth.check(sp, spb)
+		if failed {
+			return spb.Build(), nil
+		}
+		username, _ = sp.GetUsername()
+		spb.SetMessageIntegrity(key)
+	}
+	id, err := sp.GetConnectionID()
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	pc, ok := ts.connections[id]
+	if err != nil || !ok || pc.data != nil || pc.alloc.username != username {
+		turnError(spb, ECBadRequest, "Bad Request")
+		resp := spb.Build()
+		logPacket(ts.logger, slog.LevelDebug, "Sent error response", resp, c.RemoteAddr())
+		return resp, nil
+	}
+	pc.data = c
+	pc.timer.Stop()
+	return spb.Build(), pc
+}
+
+//relayConn copies between two connections until either one is done
+func relayConn(a net.Conn, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+		b.Close()
+	}()
+	io.Copy(b, a)
+	a.Close()
+	b.Close()
+}
+
+//closeConnection closes a peer connection and its data connection, lock must be held
+func (ts *TurnServer) closeConnection(pc *peerConnection) {
+	pc.timer.Stop()
+	pc.peer.Close()
+	if pc.data != nil {
+		pc.data.Close()
+	}
+	delete(ts.connections, pc.id)
+	delete(pc.alloc.connections, pc.id)
+}
+
+func (a *allocation) connectedTo(peer *net.UDPAddr) bool {
+	for _, pc := range a.connections {
+		if pc.peer.RemoteAddr().String() == peer.String() {
+			return true
+		}
+	}
+	return false
+}
+
+//send relays the data in a Send indication to its peer
+func (ts *TurnServer) send(sp *StunPacket, addr net.Addr) {
+	peer, err := sp.GetXORPeerAddress()
+	if err != nil {
+		return
+	}
+	ts.lock.Lock()
+	a, ok := ts.allocations[allocationKey(addr)]
+	permitted := ok && a.relay != nil && a.live() && a.permitted(peer.IP)
+	ts.lock.Unlock()
+	if permitted {
+		a.relay.WriteTo(sp.GetData(), peer)
+	}
+}
+
+//relayFromPeers sends what peers send to the relayed address to the client in
+//Data indications until the allocation is freed
+func (ts *TurnServer) relayFromPeers(a *allocation) {
+	ba := make([]byte, 65536)
+	for {
+		n, from, err := a.relay.ReadFrom(ba)
+		if err != nil {
+			return
+		}
+		peer := toUDPAddr(from)
+		ts.lock.Lock()
+		permitted := a.live() && a.permitted(peer.IP)
+		conn, client := a.conn, a.client
+		ts.lock.Unlock()
+		if !permitted {
+			continue
+		}
+		ind := NewStunPacketBuilder().SetStunMessage(NewStunMessage(MethodData, ClassIndication)).
+			SetXORPeerAddress(peer).SetData(ba[:n]).Build()
+		conn.WriteTo(ind.GetBytes(), client)
+	}
+}
+
+//live is false once the lifetime of the allocation is over, even if expire has
+//not freed it yet
+func (a *allocation) live() bool {
+	return time.Now().Before(a.expires)
+}
+
+func (a *allocation) permitted(ip net.IP) bool {
+	expires, ok := a.permissions[ip.String()]
+	return ok && time.Now().Before(expires)
+}
+
+//newTicket replaces the MobilityTicket of an allocation, lock must be held
+func (ts *TurnServer) newTicket(a *allocation) []byte {
+	if a.ticket != nil {
+		delete(ts.tickets, string(a.ticket))
+	}
+	a.ticket = make([]byte, 16)
+	crypto_rand.Read(a.ticket)
+	ts.tickets[string(a.ticket)] = a
+	return a.ticket
+}
+
+//free closes an allocation, lock must be held
+func (ts *TurnServer) free(a *allocation) {
+	a.timer.Stop()
+	if a.relay != nil {
+		a.relay.Close()
+	}
+	if a.listener != nil {
+		a.listener.Close()
+	}
+	for _, pc := range a.connections {
+		ts.closeConnection(pc)
+	}
+	delete(ts.allocations, allocationKey(a.client))
+	if ts.quotas[a.quotaKey]--; ts.quotas[a.quotaKey] <= 0 {
+		delete(ts.quotas, a.quotaKey)
+	}
+	if a.ticket != nil {
+		delete(ts.tickets, string(a.ticket))
+	}
+}
+
+//expire is run by the timer of an allocation, it frees the allocation unless a
+//Refresh moved its expiry, then the timer is set again
+func (ts *TurnServer) expire(a *allocation) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if ts.allocations[allocationKey(a.client)] != a {
+		return
+	}
+	if a.live() {
+		a.timer.Reset(time.Until(a.expires))
+		return
+	}
+	logPacket(ts.logger, slog.LevelDebug, "Expired allocation", nil, a.client)
+	ts.free(a)
+}
@@ -0,0 +1,269 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ErrInvalidURI is returned when a STUN or TURN URI can not be parsed
+var ErrInvalidURI = errors.New("Invalid STUN/TURN URI!")
+
+//ErrUnknownScheme is returned for a URI scheme other than stun, stuns, turn or turns
+var ErrUnknownScheme = errors.New("Unknown URI Scheme!")
+
+//StreamRTO is the rfc5389 section 7.2.2 time to wait for a response on a stream,
+//where requests are never retransmitted
+const StreamRTO = 39500 * time.Millisecond
+
+//URI is an rfc7064 stun/stuns or rfc7065 turn/turns URI
+type URI struct {
+	Scheme string
+	Host   string
+	//Port is 0 when the URI does not have one, so DNS is used to find the server
+	Port int
+	//Transport is only set for turn and turns URIs that have a transport parameter
+	Transport string
+}
+
+//ParseURI parses a URI like "stun:stun.example.com", "stuns:[2001:db8::1]:5349"
+//or "turn:turn.example.com?transport=tcp"
+func ParseURI(s string) (*URI, error) {
+	scheme, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, ErrInvalidURI
+	}
+	u := &URI{Scheme: strings.ToLower(scheme)}
+	switch u.Scheme {
+	case "stun", "stuns", "turn", "turns":
+	default:
+		return nil, ErrUnknownScheme
+	}
+	hostport, query, hasQuery := strings.Cut(rest, "?")
+	if hasQuery {
+		if !u.IsTurn() {
+			return nil, ErrInvalidURI
+		}
+		values, err := url.ParseQuery(query)
+		if err != nil || len(values) != 1 || len(values["transport"]) != 1 {
+			return nil, ErrInvalidURI
+		}
+		u.Transport = strings.ToLower(values.Get("transport"))
+		if u.Transport != "udp" && u.Transport != "tcp" {
+			return nil, ErrUnknownTransport
+		}
+	}
+	//IPv6 literals have to be in brackets, so a port is after the last ']'
+	u.Host = hostport
+	if i := strings.LastIndex(hostport, ":"); i >= 0 && i > strings.LastIndex(hostport, "]") {
+		port, err := strconv.ParseUint(hostport[i+1:], 10, 16)
+		if err != nil || port == 0 {
+			return nil, ErrInvalidURI
+		}
+		u.Host = hostport[:i]
+		u.Port = int(port)
+	}
+	if strings.HasPrefix(u.Host, "[") && strings.HasSuffix(u.Host, "]") {
+		u.Host = u.Host[1 : len(u.Host)-1]
+		if net.ParseIP(u.Host) == nil || !strings.Contains(u.Host, ":") {
+			return nil, ErrInvalidURI
+		}
+	} else if strings.Contains(u.Host, ":") {
+		return nil, ErrInvalidURI
+	}
+	if u.Host == "" || strings.ContainsAny(u.Host, "/@[]") {
+		return nil, ErrInvalidURI
+	}
+	return u, nil
+}
+
+//MustParseURI is ParseURI that panics on an invalid URI, for constants
+func MustParseURI(s string) *URI {
+	u, err := ParseURI(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+//IsTurn returns true for turn and turns URIs
+func (u *URI) IsTurn() bool {
+	return u.Scheme == "turn" || u.Scheme == "turns"
+}
+
+//IsSecure returns true for stuns and turns URIs
+func (u *URI) IsSecure() bool {
+	return u.Scheme == "stuns" || u.Scheme == "turns"
+}
+
+//DefaultPort returns the port used for the URI when DNS finds no SRV records
+func (u *URI) DefaultPort() int {
+	if u.IsSecure() {
+		return DefaultTLSPort
+	}
+	return DefaultPort
+}
+
+func (u *URI) String() string {
+	host := u.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if u.Port != 0 {
+		host += ":" + strconv.Itoa(u.Port)
+	}
+	s := u.Scheme + ":" + host
+	if u.Transport != "" {
+		s += "?transport=" + u.Transport
+	}
+	return s
+}
+
+//Resolve finds the servers for the URI, using DNS when it has no port. stun URIs
+//use udp, and stuns URIs tcp, TLS, as rfc7064 has no transport parameter
+func (u *URI) Resolve(ctx context.Context, sr *ServerResolver) ([]ServerTarget, error) {
+	host := u.Host
+	if u.Port != 0 {
+		host = net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+	}
+	if u.IsTurn() {
+		return sr.LookupTurn(ctx, host, u.Transport, u.IsSecure())
+	}
+	transport := "udp"
+	if u.IsSecure() {
+		transport = "tcp"
+	}
+	return sr.LookupStun(ctx, host, transport, u.IsSecure())
+}
+
+//URIDialer connects StunClients to the servers named by URIs
+type URIDialer struct {
+	resolver  *ServerResolver
+	tlsConfig *tls.Config
+	dtls      DTLSTransport
+	timeout   time.Duration
+}
+
+//NewURIDialer creates a URIDialer using net.DefaultResolver and the system roots for TLS
+func NewURIDialer() *URIDialer {
+	return &URIDialer{resolver: NewServerResolver(nil), timeout: 10 * time.Second}
+}
+
+//SetResolver sets the ServerResolver used for URIs without a port
+func (ud *URIDialer) SetResolver(sr *ServerResolver) *URIDialer {
+	ud.resolver = sr
+	return ud
+}
+
+//SetTLSConfig sets the tls.Config for secure URIs over tcp. The ServerName is
+//filled in from the URI if it is not set
+func (ud *URIDialer) SetTLSConfig(config *tls.Config) *URIDialer {
+	ud.tlsConfig = config
+	return ud
+}
+
+//SetDTLSTransport sets the DTLSTransport for secure URIs over udp. Without one
+//those servers are skipped
+func (ud *URIDialer) SetDTLSTransport(dt DTLSTransport) *URIDialer {
+	ud.dtls = dt
+	return ud
+}
+
+//SetTimeout sets how long to wait to connect to each server
+func (ud *URIDialer) SetTimeout(timeout time.Duration) *URIDialer {
+	ud.timeout = timeout
+	return ud
+}
+
+//Dial resolves the URI and returns a StunClient for the first server that can be
+//connected to, along with the server address to send its requests to, which is
+//also set with SetServer. Stream clients are set up to not retransmit. Close the
+//StunClient when done
+func (ud *URIDialer) Dial(ctx context.Context, u *URI) (*StunClient, net.Addr, error) {
+	sts, err := u.Resolve(ctx, ud.resolver)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = ErrNoServers
+	for _, st := range sts {
+		var sc *StunClient
+		var server net.Addr
+		sc, server, err = ud.dialTarget(ctx, u, st)
+		if err == nil {
+			return sc.SetServer(server), server, nil
+		}
+	}
+	return nil, nil, err
+}
+
+func (ud *URIDialer) dialTarget(ctx context.Context, u *URI, st ServerTarget) (*StunClient, net.Addr, error) {
+	if st.Transport == "udp" {
+		server, err := net.ResolveUDPAddr("udp", st.Addr())
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, err := net.ListenPacket("udp", ":0")
+		if err != nil {
+			return nil, nil, err
+		}
+		if !st.Secure {
+			return NewStunClient(conn), server, nil
+		}
+		if ud.dtls == nil {
+			conn.Close()
+			return nil, nil, ErrUnknownTransport
+		}
+		sc, err := DialDTLS(ud.dtls, conn, server)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		return sc, server, nil
+	}
+	d := &net.Dialer{Timeout: ud.timeout}
+	c, err := d.DialContext(ctx, "tcp", st.Addr())
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.Secure {
+		config := &tls.Config{}
+		if ud.tlsConfig != nil {
+			config = ud.tlsConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Host
+		}
+		tc := tls.Client(c, config)
+		hctx, cancel := context.WithTimeout(ctx, ud.timeout)
+		defer cancel()
+		if err := tc.HandshakeContext(hctx); err != nil {
+			c.Close()
+			return nil, nil, err
+		}
+		c = tc
+	}
+	//the one wait on a stream is lastWaitRTOs of the rto
+	sc := NewStunClient(NewStreamPacketConn(c)).SetRTO(StreamRTO / lastWaitRTOs).SetRetransmits(0)
+	return sc, c.RemoteAddr(), nil
+}
+
+//DialURI is NewURIDialer().Dial, it parses and connects to a URI string
+func DialURI(ctx context.Context, uri string) (*StunClient, net.Addr, error) {
+	u, err := ParseURI(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewURIDialer().Dial(ctx, u)
+}
+
+//NewStunClientURI is NewURIDialer().Dial, it returns a StunClient for the server
+//named by the URI, so Bind and Do can be given a nil server
+func NewStunClientURI(ctx context.Context, u *URI) (*StunClient, error) {
+	sc, _, err := NewURIDialer().Dial(ctx, u)
+	return sc, err
+}
@@ -0,0 +1,125 @@
+package stunlib // import "github.com/lwahlmeier/stunlib"
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseURI(t *testing.T) {
+	good := []struct {
+		in  string
+		uri URI
+		out string
+	}{
+		{"stun:stun.example.com", URI{Scheme: "stun", Host: "stun.example.com"}, ""},
+		{"STUN:stun.example.com:3479", URI{Scheme: "stun", Host: "stun.example.com", Port: 3479}, "stun:stun.example.com:3479"},
+		{"stuns:192.0.2.1", URI{Scheme: "stuns", Host: "192.0.2.1"}, ""},
+		{"stun:[2001:db8::1]", URI{Scheme: "stun", Host: "2001:db8::1"}, ""},
+		{"stuns:[2001:db8::1]:5350", URI{Scheme: "stuns", Host: "2001:db8::1", Port: 5350}, ""},
+		{"turn:turn.example.com", URI{Scheme: "turn", Host: "turn.example.com"}, ""},
+		{"turn:turn.example.com?transport=TCP", URI{Scheme: "turn", Host: "turn.example.com", Transport: "tcp"}, "turn:turn.example.com?transport=tcp"},
+		{"turns:[::1]:443?transport=tcp", URI{Scheme: "turns", Host: "::1", Port: 443, Transport: "tcp"}, ""},
+	}
+	for _, g := range good {
+		u, err := ParseURI(g.in)
+		assert.NoError(t, err, g.in)
+		if err != nil {
+			continue
+		}
+		assert.Equal(t, g.uri, *u, g.in)
+		if g.out == "" {
+			g.out = g.in
+		}
+		assert.Equal(t, g.out, u.String())
+	}
+
+	bad := map[string]error{
+		"stun.example.com":                  ErrInvalidURI,
+		"http:example.com":                  ErrUnknownScheme,
+		"stun:":                             ErrInvalidURI,
+		"stun:example.com:0":                ErrInvalidURI,
+		"stun:example.com:70000":            ErrInvalidURI,
+		"stun:2001:db8::1":                  ErrInvalidURI,
+		"stun:[192.0.2.1]":                  ErrInvalidURI,
+		"stun://example.com":                ErrInvalidURI,
+		"stun:user@example.com":             ErrInvalidURI,
+		"stun:example.com?transport=udp":    ErrInvalidURI,
+		"turn:example.com?transport=sctp":   ErrUnknownTransport,
+		"turn:example.com?foo=bar":          ErrInvalidURI,
+		"turn:example.com?transport=udp&x=": ErrInvalidURI,
+	}
+	for in, want := range bad {
+		_, err := ParseURI(in)
+		assert.Equal(t, want, err, in)
+	}
+
+	assert.Equal(t, DefaultPort, MustParseURI("turn:example.com").DefaultPort())
+	assert.Equal(t, DefaultTLSPort, MustParseURI("turns:example.com").DefaultPort())
+	assert.Panics(t, func() { MustParseURI("bad") })
+}
+
+func TestURIResolve(t *testing.T) {
+	sr := NewServerResolver(&fakeDNS{srv: map[string][]*net.SRV{
+		"_stun._udp.example.com":  {{Target: "stun.example.com.", Port: 3479}},
+		"_stuns._tcp.example.com": {{Target: "tls.example.com.", Port: 443}},
+		"_turn._tcp.example.com":  {{Target: "relay.example.com.", Port: 80}},
+	}})
+	ctx := context.Background()
+	for in, want := range map[string]ServerTarget{
+		"stun:example.com":                  {Host: "stun.example.com", Port: 3479, Transport: "udp"},
+		"stuns:example.com":                 {Host: "tls.example.com", Port: 443, Transport: "tcp", Secure: true},
+		"stun:example.com:5000":             {Host: "example.com", Port: 5000, Transport: "udp"},
+		"turn:example.com?transport=tcp":    {Host: "relay.example.com", Port: 80, Transport: "tcp"},
+		"turns:[2001:db8::1]?transport=udp": {Host: "2001:db8::1", Port: 5349, Transport: "udp", Secure: true},
+	} {
+		sts, err := MustParseURI(in).Resolve(ctx, sr)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, sts[0], in)
+	}
+}
+
+func TestDialURI(t *testing.T) {
+	_, sconn := startServer(t)
+	defer sconn.Close()
+	sc, server, err := DialURI(context.Background(), "stun:"+sconn.LocalAddr().String())
+	assert.NoError(t, err)
+	defer sc.Close()
+	assert.Equal(t, sconn.LocalAddr().String(), server.String())
+	_, err = sc.Bind(server)
+	assert.NoError(t, err)
+
+	//the server from the URI is used when none is given
+	sc, err = NewStunClientURI(context.Background(), MustParseURI("stun:"+sconn.LocalAddr().String()))
+	assert.NoError(t, err)
+	defer sc.Close()
+	assert.Equal(t, sconn.LocalAddr().String(), sc.Server().String())
+	br, err := sc.Bind(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, sc.Server(), br.Server)
+	resp, err := sc.Do(nil, NewStunPacketBuilder().Build())
+	assert.NoError(t, err)
+	assert.Equal(t, SMSuccess, resp.GetStunMessageType())
+	_, err = NewStunClient(sc.conn).Bind(nil)
+	assert.Equal(t, ErrNoServer, err)
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	go NewStunServer(nil).ServeStream(l)
+	u := MustParseURI("turn:" + l.Addr().String() + "?transport=tcp")
+	sc, server, err = NewURIDialer().SetTimeout(time.Second).Dial(context.Background(), u)
+	assert.NoError(t, err)
+	defer sc.Close()
+	br, err = sc.Bind(server)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, br.Retransmits)
+	assert.Equal(t, l.Addr().String(), br.Server.String())
+
+	//no DTLSTransport means stuns over udp can not be used
+	_, _, err = NewURIDialer().Dial(context.Background(), MustParseURI("turns:127.0.0.1?transport=udp"))
+	assert.Equal(t, ErrUnknownTransport, err)
+}